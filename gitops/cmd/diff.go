@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/internal/controller"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/signals"
+	k8sutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var diffNamespace string
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff APPLICATION",
+	Short: "Show the diff between Git and the cluster",
+	Long:  `Show the diff between the manifests rendered from Git and the live resources of an application, without syncing it. The values of the Secrets are masked.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stopCh := signals.SetupSignalHandler()
+		ctrl, start, err := newController()
+		if err != nil {
+			return err
+		}
+		start(stopCh)
+
+		status, diffs, err := ctrl.Diff(context.Background(), diffNamespace, args[0], stopCh)
+		if err != nil {
+			return err
+		}
+		diff, err := k8sutil.RenderDiff(diffs)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Application %s at revision %s: %s", args[0], controller.DescribeRevision(status.Revision, status.RevisionInfo), k8sutil.SummarizeDiff(diffs))
+		fmt.Fprint(cmd.OutOrStdout(), diff)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "default", "Namespace of the application")
+}
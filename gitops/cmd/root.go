@@ -2,14 +2,32 @@ package cmd
 
 import (
 	"os"
+	"path/filepath"
 
+	logutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/log"
 	"github.com/spf13/cobra"
 )
 
+var (
+	kubeconfig   string
+	logLevel     string
+	healthChecks string
+	gpgKeys      string
+
+	webhookSecret string
+
+	credentialsNamespace string
+	workspace            string
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "gitops",
 	Short: "GitOps controller for Kubernetes",
 	Long:  `GitOps controller for Kubernetes. This controller watches for changes in a Git repository and applies them to a Kubernetes cluster.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Set up the logger
+		return logutil.SetUpLogrus(logLevel)
+	},
 }
 
 func Execute() {
@@ -20,4 +38,11 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k", "~/.kube/config", "Path to a kubeconfig. Only required if out-of-cluster.")
+	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "Log level (debug, info, warn, error, fatal, panic)")
+	rootCmd.PersistentFlags().StringVar(&healthChecks, "health-checks", "default/gitops-health-checks", "Namespace/name of the ConfigMap holding the custom health checks")
+	rootCmd.PersistentFlags().StringVar(&gpgKeys, "gpg-keys", "default/gitops-gpg-keys", "Namespace/name of the ConfigMap holding the armored public GPG keys the signatures of the revisions are verified with")
+	rootCmd.PersistentFlags().StringVar(&webhookSecret, "webhook-secret", "default/gitops-webhook", "Namespace/name of the Secret holding the secrets of the webhooks per Git provider")
+	rootCmd.PersistentFlags().StringVar(&credentialsNamespace, "credentials-namespace", "default", "Namespace of the Secrets labelled as repository credentials")
+	rootCmd.PersistentFlags().StringVar(&workspace, "workspace", filepath.Join(os.TempDir(), "gitops"), "Directory holding the caches of the repositories and the trees of the applications")
 }
@@ -1,28 +1,41 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/internal/controller"
 	appclient "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/clientset/versioned"
 	appinformers "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/informers/externalversions"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/signals"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/git"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm"
 	k8sutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
-	logutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/log"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	kubeconfig string
-	numWorkers int
-	logLevel   string
+	numWorkers     int
+	pollInterval   time.Duration
+	webhookAddress string
 )
 
 // runCmd represents the run command
@@ -30,76 +43,178 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run the gitops controller",
 	Long:  `Run the gitops controller. Can be run locally with kubeconfig provided or in-cluster.`,
-	PreRunE: func(cmd *cobra.Command, args []string) error {
-		// Set up the logger
-		err := logutil.SetUpLogrus(logLevel)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stopCh := signals.SetupSignalHandler()
+		ctrl, start, err := newController()
 		if err != nil {
 			return err
 		}
+		start(stopCh)
+		if webhookAddress != "" {
+			go serveWebhook(ctrl, stopCh)
+		}
+		if err = ctrl.Run(numWorkers, stopCh); err != nil {
+			return err
+		}
 
 		return nil
 	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		// Set up the kubernetes client
-		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			log.Infof("Failed to load kubeconfig, falling back to in-cluster config...")
+}
 
-			// Fallback to in-cluster config
-			config, err = rest.InClusterConfig()
-			if err != nil {
-				return err
-			}
-		}
-		config.Timeout = 120 * time.Second
-		config.QPS = 1000
-		config.Burst = 1000
+// serveWebhook serves the webhook of the Git providers until stopCh is closed
+func serveWebhook(ctrl *controller.Controller, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/webhook", ctrl.HandleWebhook)
+	server := &http.Server{
+		Addr:              webhookAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-stopCh
+		server.Shutdown(context.Background())
+	}()
 
-		appClientSet, err := appclient.NewForConfig(config)
-		if err != nil {
-			return err
-		}
-		clientSet, err := kubernetes.NewForConfig(config)
-		if err != nil {
-			return err
-		}
+	log.Infof("Serving webhook on %s/api/webhook", webhookAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Error serving webhook: %s", err)
+	}
+}
+
+// newController sets up the controller and returns a function starting its informers
+func newController() (*controller.Controller, func(stopCh <-chan struct{}), error) {
+	// Set up the kubernetes client
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		log.Infof("Failed to load kubeconfig, falling back to in-cluster config...")
 
-		// Set up the git client
-		gitUtil := git.NewGitClient("")
-		dynClientSet, err := dynamic.NewForConfig(config)
+		// Fallback to in-cluster config
+		config, err = rest.InClusterConfig()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
+	}
+	config.Timeout = 120 * time.Second
+	config.QPS = 1000
+	config.Burst = 1000
 
-		// Set up k8s utility
-		discoveryClient := clientSet.Discovery()
-		k8sutil := k8sutil.NewK8s(discoveryClient, dynClientSet)
+	appClientSet, err := appclient.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Set up the controller
-		resyncPeriod := 30 * time.Second
-		appInformerFactory := appinformers.NewSharedInformerFactory(appClientSet, resyncPeriod)
-		stopCh := signals.SetupSignalHandler()
-		ctrl := controller.NewController(
-			clientSet,
-			appClientSet,
-			appInformerFactory.Thongdepzai().V1alpha1().Applications(),
-			gitUtil,
-			k8sutil,
-			resyncPeriod,
-		)
+	// Set up the git client
+	gitUtil := git.NewGitClient(workspace)
+	dynClientSet, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set up the health checks, the custom ones are read from a ConfigMap
+	healthChecker, err := k8sutil.NewHealthChecker()
+	if err != nil {
+		return nil, nil, err
+	}
+	healthChecksNamespace, healthChecksName, err := cache.SplitMetaNamespaceKey(healthChecks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set up the keyring of the signatures, the keys are read from a ConfigMap
+	gpgKeysNamespace, gpgKeysName, err := cache.SplitMetaNamespaceKey(gpgKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set up the webhook, its secrets are read from a Secret
+	webhookUtil := webhook.NewWebhook()
+	webhookSecretNamespace, webhookSecretName, err := cache.SplitMetaNamespaceKey(webhookSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set up k8s utility. The discovery is cached, it is refreshed when the CRDs change
+	discoveryClient := memory.NewMemCacheClient(clientSet.Discovery())
+	k8sutil := k8sutil.NewK8s(discoveryClient, dynClientSet)
+
+	// Set up kustomize utility
+	kustomizeUtil := kustomize.NewKustomize()
+
+	// Set up helm utility
+	helmUtil := helm.NewHelm(discoveryClient)
+
+	// Set up the controller
+	resyncPeriod := 30 * time.Second
+	clusterCache := clustercache.NewClusterCache(discoveryClient, dynClientSet, resyncPeriod, common.LabelKeyAppInstance, common.LabelKeyAppNamespace)
+	appInformerFactory := appinformers.NewSharedInformerFactory(appClientSet, resyncPeriod)
+	healthChecksInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+		clientSet,
+		resyncPeriod,
+		informers.WithNamespace(healthChecksNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", healthChecksName).String()
+		}),
+	)
+	gpgKeysInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+		clientSet,
+		resyncPeriod,
+		informers.WithNamespace(gpgKeysNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", gpgKeysName).String()
+		}),
+	)
+	credentialsInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+		clientSet,
+		resyncPeriod,
+		informers.WithNamespace(credentialsNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = fmt.Sprintf("%s in (%s,%s)", common.LabelKeySecretType, common.SecretTypeRepository, common.SecretTypeRepoCreds)
+		}),
+	)
+	webhookSecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+		clientSet,
+		resyncPeriod,
+		informers.WithNamespace(webhookSecretNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", webhookSecretName).String()
+		}),
+	)
+	ctrl := controller.NewController(
+		clientSet,
+		appClientSet,
+		appInformerFactory.Thongdepzai().V1alpha1().Applications(),
+		gitUtil,
+		k8sutil,
+		kustomizeUtil,
+		helmUtil,
+		healthChecker,
+		healthChecksInformerFactory.Core().V1().ConfigMaps(),
+		gpgKeysInformerFactory.Core().V1().ConfigMaps(),
+		credentialsInformerFactory.Core().V1().Secrets(),
+		webhookUtil,
+		webhookSecretInformerFactory.Core().V1().Secrets(),
+		clusterCache,
+		pollInterval,
+	)
+	start := func(stopCh <-chan struct{}) {
 		appInformerFactory.Start(stopCh)
-		if err = ctrl.Run(numWorkers, stopCh); err != nil {
-			return err
-		}
+		healthChecksInformerFactory.Start(stopCh)
+		gpgKeysInformerFactory.Start(stopCh)
+		credentialsInformerFactory.Start(stopCh)
+		webhookSecretInformerFactory.Start(stopCh)
+	}
 
-		return nil
-	},
+	return ctrl, start, nil
 }
 
 func init() {
 	rootCmd.AddCommand(runCmd)
 
-	runCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k", "~/.kube/config", "Path to a kubeconfig. Only required if out-of-cluster.")
 	runCmd.PersistentFlags().IntVarP(&numWorkers, "workers", "w", 2, "Number of workers")
-	runCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "Log level (debug, info, warn, error, fatal, panic)")
+	runCmd.PersistentFlags().DurationVar(&pollInterval, "poll-interval", 3*time.Minute, "How often the repositories are checked for new commits, unless the application sets spec.pollInterval")
+	runCmd.PersistentFlags().StringVar(&webhookAddress, "webhook-address", "", "Address serving the webhook of the Git providers on /api/webhook, e.g. :8080. Disabled by default")
 }
@@ -3,8 +3,25 @@ package common
 const (
 	MetadataPrefix = "thongdepzai.cloud"
 	ControllerName = "gitops-controller"
+
+	// SecretTypeRepository is the type of a Secret holding the credentials of the repository of its url
+	SecretTypeRepository = "repository"
+	// SecretTypeRepoCreds is the type of a Secret holding the credentials of the repositories
+	// whose URL starts with its url, e.g. all the repositories of a GitHub organization
+	SecretTypeRepoCreds = "repo-creds"
 )
 
 var (
 	LabelKeyAppInstance = MetadataPrefix + "/app-instance"
+	// LabelKeyAppNamespace is the namespace of the application of LabelKeyAppInstance,
+	// the applications of different namespaces may have the same name
+	LabelKeyAppNamespace = MetadataPrefix + "/app-namespace"
+
+	// LabelKeySecretType marks the Secrets holding the credentials of repositories,
+	// its value is SecretTypeRepository or SecretTypeRepoCreds
+	LabelKeySecretType = MetadataPrefix + "/secret-type"
+
+	// FinalizerResources is set on every Application so that its resources
+	// are cleaned up before the Application is removed
+	FinalizerResources = MetadataPrefix + "/resources-finalizer"
 )
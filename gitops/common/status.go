@@ -7,4 +7,43 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when an Application
 	// is synced successfully
 	MessageResourceSynced = "App synced successfully"
+
+	// FailedSync is used as part of the Event 'reason' when an Application
+	// fails to sync
+	FailedSync = "SyncFailed"
+
+	// FailedDelete is used as part of the Event 'reason' when the resources
+	// of an Application fail to be deleted
+	FailedDelete = "DeletionFailed"
+
+	// ResourcePruned is used as part of the Event 'reason' when a resource
+	// that is no longer defined in Git is deleted
+	ResourcePruned = "Pruned"
+
+	// MessageResourcePruned is the message used for an Event fired when a resource
+	// is pruned
+	MessageResourcePruned = "Pruned %s %s"
+
+	// ResourceDrifted is used as part of the Event 'reason' when the resources
+	// of an Application are changed in the cluster
+	ResourceDrifted = "Drifted"
+
+	// MessageResourceDrifted is the message used for an Event fired when
+	// the resources of an Application are changed in the cluster
+	MessageResourceDrifted = "Resources drifted from Git: %s"
+
+	// ResourceSelfHealed is used as part of the Event 'reason' when the resources
+	// changed in the cluster are re-applied
+	ResourceSelfHealed = "SelfHealed"
+
+	// MessageResourceSelfHealed is the message used for an Event fired when
+	// the resources changed in the cluster are re-applied
+	MessageResourceSelfHealed = "Reverted drift: %s"
+
+	// MessageResourceOutOfSync is the status message of an Application synced manually
+	// that differs from Git
+	MessageResourceOutOfSync = "Revision %s is not synced, waiting for a sync operation"
+
+	// MessageDiffTruncated is appended to the diff of a sync Event when it is too long
+	MessageDiffTruncated = "... (truncated, run `gitops diff %s -n %s` for the full diff)\n"
 )
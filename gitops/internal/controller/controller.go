@@ -2,34 +2,49 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/apis/application/v1alpha1"
 	appclientset "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/clientset/versioned"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/clientset/versioned/scheme"
 	appinformers "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/informers/externalversions/application/v1alpha1"
 	applisters "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/listers/application/v1alpha1"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/git"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm"
 	k8sutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/exp/rand"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/keymutex"
 )
 
 type Controller struct {
@@ -42,6 +57,21 @@ type Controller struct {
 	// Notifies the controller when the cache is synced
 	appCacheSync cache.InformerSynced
 
+	// Notifies the controller when the custom health checks are loaded
+	healthChecksSync cache.InformerSynced
+
+	// Notifies the controller when the GPG keys are loaded
+	gpgKeysSync cache.InformerSynced
+
+	// credentialsLister lists the Secrets labelled as repository credentials
+	credentialsLister corelisters.SecretLister
+
+	// Notifies the controller when the repository credentials are loaded
+	credentialsSync cache.InformerSynced
+
+	// Notifies the controller when the secrets of the webhooks are loaded
+	webhookSecretSync cache.InformerSynced
+
 	// Every time a new event detected by informer, it will be added to the queue
 	queue workqueue.RateLimitingInterface
 
@@ -50,11 +80,37 @@ type Controller struct {
 
 	gitUtil git.GitClient
 
+	// appLocks are held by the workers while they use the trees of an application
+	appLocks keymutex.KeyMutex
+
 	k8sUtil k8sutil.K8s
 
+	kustomizeUtil kustomize.Kustomize
+
+	helmUtil helm.Helm
+
+	healthChecker k8sutil.HealthChecker
+
+	webhookUtil webhook.Webhook
+
+	// clusterCache holds the live resources of the applications and notifies
+	// the controller when they are changed
+	clusterCache clustercache.ClusterCache
+
 	eventRecorder record.EventRecorder
 
-	resyncPeriod time.Duration
+	// pollInterval is how often the repositories are checked for new commits,
+	// unless the application sets its own
+	pollInterval time.Duration
+
+	gpgKeysMutex sync.RWMutex
+	// gpgKeys is the keyring the signatures of the revisions are verified with
+	gpgKeys openpgp.EntityList
+
+	refreshMutex sync.Mutex
+	// refreshRequested are the applications compared with Git and the cluster on their next
+	// refresh. The other refreshes are polls, skipped if the revision didn't move
+	refreshRequested map[string]bool
 }
 
 func NewController(
@@ -63,7 +119,16 @@ func NewController(
 	informer appinformers.ApplicationInformer,
 	gitUtil git.GitClient,
 	k8sUtil k8sutil.K8s,
-	resyncPeriod time.Duration,
+	kustomizeUtil kustomize.Kustomize,
+	helmUtil helm.Helm,
+	healthChecker k8sutil.HealthChecker,
+	healthChecksInformer coreinformers.ConfigMapInformer,
+	gpgKeysInformer coreinformers.ConfigMapInformer,
+	credentialsInformer coreinformers.SecretInformer,
+	webhookUtil webhook.Webhook,
+	webhookSecretInformer coreinformers.SecretInformer,
+	clusterCache clustercache.ClusterCache,
+	pollInterval time.Duration,
 ) *Controller {
 	log.Info("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
@@ -72,10 +137,15 @@ func NewController(
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: common.ControllerName})
 
 	c := &Controller{
-		clientSet:    clientSet,
-		appClientSet: appClientSet,
-		appLister:    informer.Lister(),
-		appCacheSync: informer.Informer().HasSynced,
+		clientSet:         clientSet,
+		appClientSet:      appClientSet,
+		appLister:         informer.Lister(),
+		appCacheSync:      informer.Informer().HasSynced,
+		healthChecksSync:  healthChecksInformer.Informer().HasSynced,
+		gpgKeysSync:       gpgKeysInformer.Informer().HasSynced,
+		credentialsLister: credentialsInformer.Lister(),
+		credentialsSync:   credentialsInformer.Informer().HasSynced,
+		webhookSecretSync: webhookSecretInformer.Informer().HasSynced,
 		queue: workqueue.NewNamedRateLimitingQueue(
 			workqueue.DefaultControllerRateLimiter(),
 			"application",
@@ -85,9 +155,17 @@ func NewController(
 			"application-reconcile",
 		),
 		gitUtil:       gitUtil,
+		appLocks:      keymutex.NewHashed(0),
 		k8sUtil:       k8sUtil,
+		kustomizeUtil: kustomizeUtil,
+		helmUtil:      helmUtil,
+		healthChecker: healthChecker,
+		webhookUtil:   webhookUtil,
+		clusterCache:  clusterCache,
 		eventRecorder: recorder,
-		resyncPeriod:  resyncPeriod,
+		pollInterval:  pollInterval,
+
+		refreshRequested: make(map[string]bool),
 	}
 
 	informer.Informer().AddEventHandler(
@@ -98,6 +176,32 @@ func NewController(
 		},
 	)
 
+	healthChecksInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.loadHealthChecks,
+			UpdateFunc: func(old, new interface{}) { c.loadHealthChecks(new) },
+			DeleteFunc: func(obj interface{}) { c.loadHealthChecks(nil) },
+		},
+	)
+
+	gpgKeysInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.loadGPGKeys,
+			UpdateFunc: func(old, new interface{}) { c.loadGPGKeys(new) },
+			DeleteFunc: func(obj interface{}) { c.loadGPGKeys(nil) },
+		},
+	)
+
+	webhookSecretInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.loadWebhookSecrets,
+			UpdateFunc: func(old, new interface{}) { c.loadWebhookSecrets(new) },
+			DeleteFunc: func(obj interface{}) { c.loadWebhookSecrets(nil) },
+		},
+	)
+
+	clusterCache.AddEventHandler(c.handleResourceEvent)
+
 	return c
 }
 
@@ -109,8 +213,10 @@ func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}) error {
 		c.queue.ShutDown()
 	}()
 
+	go c.clusterCache.Run(stopCh)
+
 	// Wait for the caches to be synced before starting workers
-	if !cache.WaitForCacheSync(stopCh, c.appCacheSync) {
+	if !cache.WaitForCacheSync(stopCh, c.appCacheSync, c.healthChecksSync, c.gpgKeysSync, c.credentialsSync, c.webhookSecretSync, c.clusterCache.HasSynced) {
 		return fmt.Errorf("timed out waiting for caches to sync")
 	}
 
@@ -142,66 +248,69 @@ func (c *Controller) applicationRefreshWorker() {
 func (c *Controller) processNextItem() bool {
 	ctx := context.Background()
 
-	obj, shutdown := c.queue.Get()
+	key, shutdown := c.queue.Get()
 	if shutdown {
 		return false
 	}
 
 	// We wrap this block in a func so we can defer c.workqueue.Done.
-	err := func(obj interface{}) error {
-		defer c.queue.Done(obj)
-
-		// Extract the key from the item in namespace/name format
-		key, err := cache.MetaNamespaceKeyFunc(obj)
-		if err != nil {
-			// Since we can't process the item, we stop processing it
-			c.queue.Forget(obj)
-			return fmt.Errorf("error getting key from item: %s", err)
-		}
+	err := func(key string) error {
+		defer c.queue.Done(key)
 
 		// Split the key into namespace and name
 		ns, name, err := cache.SplitMetaNamespaceKey(key)
 		if err != nil {
 			// Since we can't process the item, we stop processing it
-			c.queue.Forget(obj)
+			c.queue.Forget(key)
 			return fmt.Errorf("error splitting key: %s", err)
 		}
 
-		// Since we only know about the deployment object,
-		// We have to check with the API server to determine
-		// if the deployment is added or deleted.
 		app, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(ns).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
+			// The finalizer is only removed after the resources are cleaned up,
+			// so there is nothing left to do for a deleted application
 			if apierrors.IsNotFound(err) {
-				app = obj.(*v1alpha1.Application)
-				err = c.deleteResources(app)
-				if err != nil {
-					c.queue.AddRateLimited(obj)
-					return fmt.Errorf("error cleaning up resources: %s", err)
-				}
-
-				c.queue.Forget(obj)
+				c.queue.Forget(key)
 				return nil
 			}
 
 			// If there is another type of error, requeue the item
-			c.queue.AddRateLimited(obj)
-			return fmt.Errorf("error getting deployment info: %s", err)
+			c.queue.AddRateLimited(key)
+			return fmt.Errorf("error getting application info: %s", err)
+		}
+
+		// The application is being deleted
+		if app.DeletionTimestamp != nil {
+			done, err := c.finalizeApp(ctx, app)
+			if err != nil {
+				c.eventRecorder.Event(app, corev1.EventTypeWarning, common.FailedDelete, err.Error())
+				c.queue.AddRateLimited(key)
+				return fmt.Errorf("error cleaning up resources: %s", err)
+			}
+			if !done {
+				c.queue.AddRateLimited(key)
+				return nil
+			}
+
+			c.queue.Forget(key)
+			return nil
+		}
+
+		err = c.addFinalizer(ctx, app)
+		if err != nil {
+			c.queue.AddRateLimited(key)
+			return fmt.Errorf("error adding finalizer: %s", err)
 		}
 
 		// Hand it over to the refresh queue on creation
-		// TODO: use a cache instead of depending on Informer
 		c.requestAppRefresh(app.GetName(), app.GetNamespace())
-		c.queue.Forget(obj)
+		c.queue.Forget(key)
 
 		return nil
-	}(obj)
+	}(key.(string))
 
 	if err != nil {
 		utilruntime.HandleError(err)
-		c.updateAppStatus(ctx, obj.(*v1alpha1.Application), &v1alpha1.ApplicationStatus{
-			HealthStatus: v1alpha1.HealthStatusDegraded,
-		})
 	}
 
 	return true
@@ -234,7 +343,7 @@ func (c *Controller) processNextAppRefreshItem() bool {
 			// This means the application is deleted while processing
 			if apierrors.IsNotFound(err) {
 				c.appRefreshQueue.Forget(appKey)
-				return app, nil
+				return nil, nil
 			}
 
 			// If there is another type of error, requeue the item
@@ -242,163 +351,1290 @@ func (c *Controller) processNextAppRefreshItem() bool {
 			return nil, fmt.Errorf("error getting deployment info: %s", err)
 		}
 
-		err = c.createResources(ctx, app)
-		if err != nil {
-			c.appRefreshQueue.AddRateLimited(appKey)
-			return nil, fmt.Errorf("error creating resources: %s", err)
+		// Don't sync an application that is being deleted
+		if app.DeletionTimestamp != nil {
+			c.appRefreshQueue.Forget(appKey)
+			return nil, nil
+		}
+
+		// A poll only checks the revision, nothing changed if it didn't move
+		if !c.isRefreshRequested(appKey) && app.Operation == nil && c.isUpToDate(ctx, app) {
+			log.WithField("application", app.Name).Debugf("Revision %s is up to date", app.Status.Sync.Revision)
+			// The resources may still be rolling out
+			err = c.refreshHealth(ctx, app)
+			if err != nil {
+				c.appRefreshQueue.AddRateLimited(appKey)
+				return app, fmt.Errorf("error refreshing health: %s", err)
+			}
+		} else {
+			err = c.createResources(ctx, app)
+			if err != nil {
+				c.appRefreshQueue.AddRateLimited(appKey)
+				return app, fmt.Errorf("error creating resources: %s", err)
+			}
 		}
 		c.appRefreshQueue.Forget(appKey)
+		c.appRefreshQueue.AddAfter(appKey, c.nextPoll(app))
 
 		return app, nil
 	}(appKey.(string))
 
 	if err != nil {
 		utilruntime.HandleError(err)
-		c.updateAppStatus(ctx, app, &v1alpha1.ApplicationStatus{
-			HealthStatus: v1alpha1.HealthStatusDegraded,
-		})
+		if app != nil {
+			c.eventRecorder.Event(app, corev1.EventTypeWarning, common.FailedSync, err.Error())
+		}
 	}
 
 	return true
 }
 
-func (c *Controller) createResources(ctx context.Context, app *v1alpha1.Application) error {
-	repoPath := path.Join(os.TempDir(), app.Name, strings.Replace(app.Spec.Repository, "/", "_", -1))
-	log.Println(repoPath)
-	err := c.updateAppStatus(
-		ctx,
-		app,
-		&v1alpha1.ApplicationStatus{
-			HealthStatus: v1alpha1.HealthStatusProgressing,
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("error updating application status to Processing: %s", err)
+// comparison is the result of the comparison of an application with the cluster
+type comparison struct {
+	// revision is the commit checked out
+	revision string
+	// revisionInfo is the metadata of the commit
+	revisionInfo *v1alpha1.RevisionInfo
+	// signatureKeyID is the key whose signature of the revision was verified, if required
+	signatureKeyID string
+	// desired are the resources rendered from Git
+	desired []*unstructured.Unstructured
+	diffs   []k8sutil.ResourceDiff
+}
+
+// newRevisionInfo converts the metadata of a commit for the status
+func newRevisionInfo(info *git.CommitInfo) *v1alpha1.RevisionInfo {
+	return &v1alpha1.RevisionInfo{
+		Author:    info.Author,
+		Committer: info.Committer,
+		Date:      metav1.NewTime(info.Date),
+		Subject:   info.Subject,
+		Tags:      info.Tags,
 	}
+}
 
-	log.WithField("application", app.Name).Info("Creating resources")
+// DescribeRevision describes the commit of a revision on one line, e.g.
+// <sha> (v1.2.0) "Fix the probes" by Jane Doe <jane@example.com> on 2024-05-13T14:00:00Z
+func DescribeRevision(revision string, info *v1alpha1.RevisionInfo) string {
+	if info == nil {
+		return revision
+	}
+
+	description := revision
+	if len(info.Tags) > 0 {
+		description += fmt.Sprintf(" (%s)", strings.Join(info.Tags, ", "))
+	}
+	description += fmt.Sprintf(" %q by %s", info.Subject, info.Author)
+	if info.Committer != "" && info.Committer != info.Author {
+		description += fmt.Sprintf(", committed by %s", info.Committer)
+	}
+	if !info.Date.IsZero() {
+		description += " on " + info.Date.UTC().Format(time.RFC3339)
+	}
+	return description
+}
+
+// syncStatus returns the sync status of the application compared with the revision
+func (r *comparison) syncStatus(status v1alpha1.SyncStatusCode) v1alpha1.SyncStatus {
+	return v1alpha1.SyncStatus{
+		Status:         status,
+		Revision:       r.revision,
+		RevisionInfo:   r.revisionInfo,
+		SignatureKeyID: r.signatureKeyID,
+		Diff:           newDiffSummary(r.diffs),
+	}
+}
+
+// compareApp fetches the revision of the source of an application, renders its manifests and
+// diffs them with the live resources. On error, it returns the condition of the stage that
+// failed and the comparison done so far.
+func (c *Controller) compareApp(ctx context.Context, app *v1alpha1.Application, source *v1alpha1.ApplicationSource) (*comparison, string, error) {
+	result := &comparison{}
+
+	// The trees of an application are checked out and rendered by one worker at a time
+	worktree := cache.NewObjectName(app.Namespace, app.Name).String()
+	c.appLocks.LockKey(worktree)
+	defer c.appLocks.UnlockKey(worktree)
+
+	creds, err := c.getCredentials(ctx, app, source.Repository)
+	if err != nil {
+		return result, v1alpha1.ApplicationConditionFetchError, fmt.Errorf("error getting repository credentials: %s", err)
+	}
 
-	// Clone the repository
-	log.Debugf("Cloning repository to %s", repoPath)
-	err = c.gitUtil.CloneOrFetch(app.Spec.Repository, repoPath)
+	// Fetch the repository
+	log.Debugf("Fetching repository %s", source.Repository)
+	err = c.gitUtil.CloneOrFetch(source.Repository, creds, cloneOptions(app, source))
 	if err != nil {
-		return fmt.Errorf("error cloning repository: %s", err)
+		return result, v1alpha1.ApplicationConditionFetchError, fmt.Errorf("error cloning repository: %s", err)
 	}
-	log.Debugf("Repository cloned to %s", repoPath)
-	sha, err := c.gitUtil.Checkout(repoPath, app.Spec.Revision)
+	repoPath, sha, err := c.gitUtil.Checkout(source.Repository, source.Revision, worktree, checkoutPaths(app, source))
 	if err != nil {
-		return fmt.Errorf("error checking out revision: %s", err)
+		return result, v1alpha1.ApplicationConditionFetchError, fmt.Errorf("error checking out revision: %s", err)
+	}
+	result.revision = sha
+	log.Debugf("Checked out revision %s to %s", source.Revision, repoPath)
+	info, err := c.gitUtil.CommitInfo(source.Repository, sha)
+	if err != nil {
+		return result, v1alpha1.ApplicationConditionFetchError, fmt.Errorf("error reading commit: %s", err)
+	}
+	result.revisionInfo = newRevisionInfo(info)
+
+	if len(app.Spec.SignatureKeys) > 0 {
+		result.signatureKeyID, err = c.verifySignature(app, source, sha)
+		if err != nil {
+			return result, v1alpha1.ApplicationConditionSignatureError, fmt.Errorf("error verifying signature of revision %s: %s", sha, err)
+		}
 	}
-	log.Debugf("Checked out revision %s", app.Spec.Revision)
 
 	// Generate manifests
 	log.Infof("Generating manifests for application %s", app.Name)
-	generatedResources, err := c.k8sUtil.GenerateManifests(path.Join(repoPath, app.Spec.Path))
+	generatedResources, err := c.generateManifests(app, source, repoPath)
 	if err != nil {
-		return fmt.Errorf("error generating manifests: %s", err)
+		return result, v1alpha1.ApplicationConditionRenderError, fmt.Errorf("error generating manifests: %s", err)
 	}
 
 	// Get current resources
 	log.Infof("Getting resources for application %s", app.Name)
-	label := map[string]string{
-		common.LabelKeyAppInstance: app.Name,
-	}
-	currentResources, err := c.k8sUtil.GetResourceWithLabel(label)
+	currentResources, err := c.clusterCache.GetResources(app.Namespace, app.Name)
 	if err != nil {
-		return fmt.Errorf("error getting resources with label: %s, %s", label, err)
+		return result, v1alpha1.ApplicationConditionDiffError, fmt.Errorf("error getting resources: %s", err)
 	}
 
 	// Set the label for the generated resources
-	label = map[string]string{
-		common.LabelKeyAppInstance: app.Name,
+	label := map[string]string{
+		common.LabelKeyAppInstance:  app.Name,
+		common.LabelKeyAppNamespace: app.Namespace,
 	}
 	err = c.k8sUtil.SetLabelsForResources(generatedResources, label)
 	if err != nil {
-		return fmt.Errorf("error setting labels for resources: %s", err)
+		return result, v1alpha1.ApplicationConditionRenderError, fmt.Errorf("error setting labels for resources: %s", err)
 	}
+	result.desired = generatedResources
 
 	// Calculate diff
 	log.Infof("Diffing resources for application %s", app.Name)
-	diff, err := c.k8sUtil.DiffResources(currentResources, generatedResources)
+	ignore, err := newIgnoreDifferences(app)
+	if err != nil {
+		return result, v1alpha1.ApplicationConditionDiffError, fmt.Errorf("error parsing ignoreDifferences: %s", err)
+	}
+	result.diffs, err = c.k8sUtil.DiffResources(ctx, currentResources, generatedResources, app.GetNamespace(), ignore)
+	if err != nil {
+		return result, v1alpha1.ApplicationConditionDiffError, fmt.Errorf("error diffing resources: %s", err)
+	}
+
+	return result, "", nil
+}
+
+// cloneOptions returns the options the source is fetched with, nil for the full history of
+// all the references
+func cloneOptions(app *v1alpha1.Application, source *v1alpha1.ApplicationSource) *git.CloneOptions {
+	clone := app.Spec.Clone
+	if clone == nil || (clone.Depth == 0 && !clone.SingleBranch) {
+		return nil
+	}
+
+	return &git.CloneOptions{
+		Depth:        int(clone.Depth),
+		SingleBranch: clone.SingleBranch,
+		Revision:     source.Revision,
+	}
+}
+
+// checkoutPaths returns the paths of a sparse checkout of the source, nil for the whole tree
+func checkoutPaths(app *v1alpha1.Application, source *v1alpha1.ApplicationSource) []string {
+	clone := app.Spec.Clone
+	if clone == nil || !clone.Sparse {
+		return nil
+	}
+
+	return append([]string{source.Path}, clone.ExtraPaths...)
+}
+
+// verifySignature returns the ID of the key that signed the revision, it must be one of the
+// signature keys of the application
+func (c *Controller) verifySignature(app *v1alpha1.Application, source *v1alpha1.ApplicationSource, sha string) (string, error) {
+	c.gpgKeysMutex.RLock()
+	keyRing := c.gpgKeys
+	c.gpgKeysMutex.RUnlock()
+
+	signature, err := c.gitUtil.VerifySignature(source.Repository, source.Revision, sha, keyRing)
 	if err != nil {
-		return fmt.Errorf("error diffing resources: %s", err)
+		return "", err
+	}
+	if !signature.SignedBy(app.Spec.SignatureKeys) {
+		return "", fmt.Errorf("signed with key %s of %s which is not allowed", signature.KeyID, signature.Signer)
 	}
-	if diff {
-		// Create resources
+
+	log.WithField("application", app.Name).Debugf("Revision %s signed with key %s of %s", sha, signature.KeyID, signature.Signer)
+	return signature.KeyID, nil
+}
+
+// getCredentials returns the credentials of the Secret named by the application, or else
+// of the labelled Secret matching the repository. It returns nil for public repositories.
+func (c *Controller) getCredentials(ctx context.Context, app *v1alpha1.Application, repository string) (*git.Credentials, error) {
+	if app.Spec.CredentialsSecret != "" {
+		secret, err := c.clientSet.CoreV1().Secrets(app.Namespace).Get(ctx, app.Spec.CredentialsSecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return git.NewCredentialsFromSecret(secret), nil
+	}
+
+	secrets, err := c.credentialsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	return git.FindCredentials(repository, secrets), nil
+}
+
+// Diff compares an application with the cluster without syncing it, once the live resources
+// are listed. It returns the sync status of the revision compared and the differences
+func (c *Controller) Diff(ctx context.Context, namespace string, name string, stopCh <-chan struct{}) (*v1alpha1.SyncStatus, []k8sutil.ResourceDiff, error) {
+	go c.clusterCache.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, c.gpgKeysSync, c.credentialsSync, c.clusterCache.HasSynced) {
+		return nil, nil, fmt.Errorf("timed out waiting for caches to sync")
+	}
+
+	app, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, _, err := c.compareApp(ctx, app, &app.Spec.ApplicationSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	syncStatus := v1alpha1.SyncStatusCode(v1alpha1.SyncStatusSynced)
+	if needsApply(result.diffs) {
+		syncStatus = v1alpha1.SyncStatusOutOfSync
+	}
+	status := result.syncStatus(syncStatus)
+	return &status, result.diffs, nil
+}
+
+func (c *Controller) createResources(ctx context.Context, app *v1alpha1.Application) error {
+	log.WithField("application", app.Name).Info("Creating resources")
+
+	// The requested sync is removed before it runs, so it runs once even if it fails
+	operation := app.Operation
+	source := &app.Spec.ApplicationSource
+	var state *v1alpha1.OperationState
+	if operation != nil {
+		var err error
+		app, source, err = c.startOperation(ctx, app)
+		if err != nil {
+			return fmt.Errorf("error starting operation: %s", err)
+		}
+		state = newOperationState()
+		if source == nil {
+			return c.syncFailed(ctx, app, v1alpha1.ApplicationConditionFetchError, "", nil, state,
+				fmt.Errorf("history ID %d not found", *operation.Sync.HistoryID))
+		}
+	}
+
+	result, conditionType, err := c.compareApp(ctx, app, source)
+	if err != nil {
+		return c.syncFailed(ctx, app, conditionType, result.revision, nil, state, err)
+	}
+	sha, generatedResources, diffs := result.revision, result.desired, result.diffs
+	resources, resourceByObj := c.newResourceStatuses(diffs)
+
+	// Get notified when the resources are changed in the cluster
+	c.watchResources(generatedResources)
+
+	automated := automatedSyncPolicy(app)
+	if operation == nil && automated == nil {
+		return c.compared(ctx, app, result, resources)
+	}
+
+	if operation == nil && needsApply(diffs) && isDrift(app, sha) {
+		if !automated.SelfHeal {
+			return c.driftDetected(ctx, app, result, resources)
+		}
+		log.WithField("application", app.Name).Info("Reverting drift")
+		c.eventRecorder.Eventf(app, corev1.EventTypeNormal, common.ResourceSelfHealed, common.MessageResourceSelfHealed, describeDrift(diffs))
+	}
+
+	prune := (automated != nil && automated.Prune) || (operation != nil && operation.Sync != nil && operation.Sync.Prune)
+
+	// An automated sync is only recorded when it changes something
+	if state == nil && (needsApply(diffs) || (prune && hasOrphans(diffs)) || sha != app.Status.Revision) {
+		state = newOperationState()
+	}
+	if state != nil {
+		state.Revision = sha
+		err = c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+			status.OperationState = state.DeepCopy()
+		})
+		if err != nil {
+			return fmt.Errorf("error updating operation state: %s", err)
+		}
+	}
+
+	if needsApply(diffs) {
+		// Create resources, dependencies first. Every resource is applied
+		// so the errors of all the resources are recorded.
+		var applyErr error
+		k8sutil.SortByInstallOrder(generatedResources)
 		for _, r := range generatedResources {
-			// TODO: use namespace from application spec
-			err = c.k8sUtil.CreateResource(ctx, r, app.GetNamespace())
+			namespace := r.GetNamespace()
+			if namespace == "" {
+				namespace = app.GetNamespace()
+			}
+
+			resource := resourceByObj[r]
+			live, err := c.k8sUtil.CreateResource(ctx, r, namespace)
+			addResourceResult(state, r, v1alpha1.ResourceResultSynced, v1alpha1.ResourceResultSyncFailed, err)
 			if err != nil {
-				return fmt.Errorf("error creating resources: %s", err)
+				resource.Message = err.Error()
+				if applyErr == nil {
+					applyErr = fmt.Errorf("%s %s: %s", r.GetKind(), r.GetName(), err)
+				}
+				continue
 			}
+			resource.Status = v1alpha1.SyncStatusSynced
+			resource.Message = ""
+			c.setResourceHealth(resource, live)
+		}
+		if applyErr != nil {
+			return c.syncFailed(ctx, app, v1alpha1.ApplicationConditionApplyError, sha, resources, state, fmt.Errorf("error creating resources: %s", applyErr))
 		}
 	} else {
 		log.WithField("application", app.Name).Info("No changes in resources")
 	}
 
-	err = c.updateAppStatus(
-		ctx,
-		app,
-		&v1alpha1.ApplicationStatus{
-			HealthStatus: v1alpha1.HealthStatusHealthy,
-			Revision:     sha,
-			LastSyncAt:   metav1.Now(),
-		},
-	)
+	// Prune resources that are no longer defined in Git
+	var prunedResources []v1alpha1.ResourceRef
+	if prune {
+		prunedResources, err = c.pruneResources(ctx, app, diffs, resourceByObj, state)
+		resources = slices.DeleteFunc(resources, func(r v1alpha1.ResourceStatus) bool {
+			return slices.Contains(prunedResources, r.ResourceRef)
+		})
+		if err != nil {
+			return c.syncFailed(ctx, app, v1alpha1.ApplicationConditionApplyError, sha, resources, state, fmt.Errorf("error pruning resources: %s", err))
+		}
+	}
+
+	health := aggregateHealth(resources)
+	log.WithField("application", app.Name).Infof("Application is %s", health)
+
+	err = c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.HealthStatus = health
+		status.Sync = result.syncStatus(v1alpha1.SyncStatusSynced)
+		status.Revision = sha
+		status.LastSyncAt = metav1.Now()
+		status.Message = common.MessageResourceSynced
+		status.ObservedGeneration = app.Generation
+		status.PrunedResources = prunedResources
+		status.Resources = resources
+		for _, conditionType := range syncConditionTypes {
+			meta.RemoveStatusCondition(&status.Conditions, conditionType)
+		}
+		if state != nil {
+			finishOperation(state, v1alpha1.OperationSucceeded, common.MessageResourceSynced)
+			status.OperationState = state
+			status.History = addHistory(status.History, sha, state.StartedAt, source)
+		}
+	})
 	if err != nil {
-		return fmt.Errorf("error updating application status to Ready: %s", err)
+		return fmt.Errorf("error updating application status to Synced: %s", err)
 	}
 
-	c.eventRecorder.Event(app, corev1.EventTypeNormal, common.SuccessSynced, common.MessageResourceSynced)
+	message := common.MessageResourceSynced + ": " + DescribeRevision(sha, result.revisionInfo)
+	if needsApply(diffs) || len(prunedResources) > 0 {
+		message += "\n" + eventDiff(app, diffs)
+	}
+	c.eventRecorder.Event(app, corev1.EventTypeNormal, common.SuccessSynced, message)
 
 	log.WithField("application", app.Name).Info("Resources created")
 
 	return nil
 }
 
-func (c *Controller) deleteResources(app *v1alpha1.Application) error {
-	if app.Name == "" {
-		return fmt.Errorf("application name is empty")
+// automatedSyncPolicy returns nil if the application is synced manually
+func automatedSyncPolicy(app *v1alpha1.Application) *v1alpha1.SyncPolicyAutomated {
+	if app.Spec.SyncPolicy == nil {
+		return nil
 	}
+	return app.Spec.SyncPolicy.Automated
+}
 
-	repoPath := path.Join(os.TempDir(), app.Name, strings.Replace(app.Spec.Repository, "/", "_", -1))
+// startOperation removes the operation of the application and returns the updated application
+// with the source to sync. A rollback syncs the source of an entry of the history, which is
+// nil if the entry doesn't exist, and disables the automated sync.
+func (c *Controller) startOperation(ctx context.Context, app *v1alpha1.Application) (*v1alpha1.Application, *v1alpha1.ApplicationSource, error) {
+	app = app.DeepCopy()
+	operation := app.Operation
+	app.Operation = nil
+
+	var source *v1alpha1.ApplicationSource
+	if operation.Sync != nil && operation.Sync.HistoryID != nil {
+		log.WithField("application", app.Name).Infof("Rolling back to history ID %d", *operation.Sync.HistoryID)
+		for _, h := range app.Status.History {
+			if h.ID == *operation.Sync.HistoryID {
+				// The exact commit synced
+				source = h.Source.DeepCopy()
+				source.Revision = h.Revision
+			}
+		}
+		if app.Spec.SyncPolicy != nil {
+			app.Spec.SyncPolicy.Automated = nil
+		}
+	} else {
+		log.WithField("application", app.Name).Info("Starting requested sync")
+	}
 
-	// Get all resources with the label
-	label := map[string]string{
-		common.LabelKeyAppInstance: app.Name,
+	app, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Update(ctx, app, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if operation.Sync == nil || operation.Sync.HistoryID == nil {
+		source = &app.Spec.ApplicationSource
+	}
+	return app, source, nil
+}
+
+func newOperationState() *v1alpha1.OperationState {
+	return &v1alpha1.OperationState{
+		Phase:     v1alpha1.OperationRunning,
+		StartedAt: metav1.Now(),
+	}
+}
+
+func finishOperation(state *v1alpha1.OperationState, phase v1alpha1.OperationPhase, message string) {
+	now := metav1.Now()
+	state.Phase = phase
+	state.Message = message
+	state.FinishedAt = &now
+}
+
+// addResourceResult records the result of the sync of a resource, if an operation is running
+func addResourceResult(state *v1alpha1.OperationState, obj *unstructured.Unstructured, success, failure v1alpha1.ResourceResultCode, err error) {
+	if state == nil {
+		return
+	}
+
+	result := v1alpha1.ResourceResult{ResourceRef: newResourceRef(obj), Status: success}
+	if err != nil {
+		result.Status = failure
+		result.Message = err.Error()
+	}
+	state.Resources = append(state.Resources, result)
+}
+
+// addHistory appends a successful sync to the history, keeping the last maxHistory entries
+func addHistory(history []v1alpha1.RevisionHistory, revision string, deployedAt metav1.Time, source *v1alpha1.ApplicationSource) []v1alpha1.RevisionHistory {
+	var id int64
+	if len(history) > 0 {
+		id = history[len(history)-1].ID + 1
+	}
+	history = append(history, v1alpha1.RevisionHistory{
+		ID:         id,
+		Revision:   revision,
+		DeployedAt: deployedAt,
+		Source:     *source.DeepCopy(),
+	})
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return history
+}
+
+// compared records the comparison of an application synced manually, without touching the resources
+func (c *Controller) compared(
+	ctx context.Context,
+	app *v1alpha1.Application,
+	result *comparison,
+	resources []v1alpha1.ResourceStatus,
+) error {
+	revision, diffs := result.revision, result.diffs
+	syncStatus := v1alpha1.SyncStatusCode(v1alpha1.SyncStatusSynced)
+	message := common.MessageResourceSynced
+	if needsApply(diffs) {
+		syncStatus = v1alpha1.SyncStatusOutOfSync
+		message = fmt.Sprintf(common.MessageResourceOutOfSync, revision)
+	}
+	log.WithField("application", app.Name).Infof("Application is %s with revision %s", syncStatus, revision)
+
+	health := aggregateHealth(resources)
+	err := c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.HealthStatus = health
+		status.Sync = result.syncStatus(syncStatus)
+		status.Message = message
+		status.ObservedGeneration = app.Generation
+		status.Resources = resources
+		// The errors of the last sync are kept until the next sync
+		for _, conditionType := range syncConditionTypes {
+			if conditionType != v1alpha1.ApplicationConditionApplyError {
+				meta.RemoveStatusCondition(&status.Conditions, conditionType)
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("error updating application status to %s: %s", syncStatus, err)
+	}
+
+	return nil
+}
+
+// isDrift returns whether the differences found for a revision already synced successfully
+// come from changes made in the cluster rather than in Git or in the application spec
+func isDrift(app *v1alpha1.Application, revision string) bool {
+	if app.Status.Revision != revision || app.Status.ObservedGeneration != app.Generation {
+		return false
+	}
+	for _, conditionType := range syncConditionTypes {
+		if meta.IsStatusConditionTrue(app.Status.Conditions, conditionType) {
+			return false
+		}
+	}
+	return true
+}
+
+// driftDetected marks the application OutOfSync without touching the resources
+func (c *Controller) driftDetected(
+	ctx context.Context,
+	app *v1alpha1.Application,
+	result *comparison,
+	resources []v1alpha1.ResourceStatus,
+) error {
+	drift := describeDrift(result.diffs)
+	log.WithField("application", app.Name).Warnf("Resources drifted from Git: %s", drift)
+
+	health := aggregateHealth(resources)
+	err := c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.HealthStatus = health
+		status.Sync = result.syncStatus(v1alpha1.SyncStatusOutOfSync)
+		status.Message = fmt.Sprintf(common.MessageResourceDrifted, drift)
+		status.ObservedGeneration = app.Generation
+		status.Resources = resources
+	})
+	if err != nil {
+		return fmt.Errorf("error updating application status to OutOfSync: %s", err)
 	}
-	resources, err := c.k8sUtil.GetResourceWithLabel(label)
+
+	c.eventRecorder.Eventf(app, corev1.EventTypeWarning, common.ResourceDrifted, common.MessageResourceDrifted, drift)
+
+	return nil
+}
+
+// describeDrift lists the resources that drifted with their modified fields
+func describeDrift(diffs []k8sutil.ResourceDiff) string {
+	var drift []string
+	for _, d := range diffs {
+		if d.Desired == nil {
+			continue
+		}
+
+		name := d.Desired.GetKind() + " " + d.Desired.GetName()
+		if d.Live != nil {
+			name = d.Live.GetKind() + " " + cache.NewObjectName(d.Live.GetNamespace(), d.Live.GetName()).String()
+		}
+		switch {
+		case d.Live == nil:
+			drift = append(drift, name+" was deleted")
+		case d.Modified:
+			drift = append(drift, name+" ("+strings.Join(d.ModifiedFields(), ", ")+")")
+		}
+	}
+
+	return strings.Join(drift, "; ")
+}
+
+func newIgnoreDifferences(app *v1alpha1.Application) (*k8sutil.IgnoreDifferences, error) {
+	rules := make([]k8sutil.IgnoreRule, 0, len(app.Spec.IgnoreDifferences))
+	for _, r := range app.Spec.IgnoreDifferences {
+		rules = append(rules, k8sutil.IgnoreRule{
+			Group:        r.Group,
+			Kind:         r.Kind,
+			Name:         r.Name,
+			Namespace:    r.Namespace,
+			JSONPointers: r.JSONPointers,
+			JSONPaths:    r.JSONPaths,
+		})
+	}
+	return k8sutil.NewIgnoreDifferences(rules)
+}
+
+func newDiffSummary(diffs []k8sutil.ResourceDiff) *v1alpha1.DiffSummary {
+	summary := k8sutil.SummarizeDiff(diffs)
+	return &v1alpha1.DiffSummary{
+		Added:    summary.Added,
+		Modified: summary.Modified,
+		Removed:  summary.Removed,
+	}
+}
+
+// eventDiff renders the diff for an event, truncated to keep the event small
+func eventDiff(app *v1alpha1.Application, diffs []k8sutil.ResourceDiff) string {
+	summary := k8sutil.SummarizeDiff(diffs).String()
+	diff, err := k8sutil.RenderDiff(diffs)
 	if err != nil {
-		return fmt.Errorf("error getting resources with label: %s, %s", label, err)
+		log.WithField("application", app.Name).Warnf("Error rendering diff: %s", err)
+		return summary
 	}
 
-	log.WithField("application", app.Name).Info("Deleting resources")
+	if len(diff) > maxEventDiffLength {
+		// Cut at the end of a line
+		diff = diff[:strings.LastIndex(diff[:maxEventDiffLength], "\n")+1]
+		diff += fmt.Sprintf(common.MessageDiffTruncated, app.Name, app.Namespace)
+	}
+	return summary + "\n" + diff
+}
+
+// watchResources makes sure the kinds of the resources are watched
+func (c *Controller) watchResources(resources []*unstructured.Unstructured) {
+	var gvks []schema.GroupVersionKind
 	for _, r := range resources {
-		err := c.k8sUtil.DeleteResource(context.Background(), r, r.GetNamespace())
+		gvk := r.GroupVersionKind()
+		if !slices.Contains(gvks, gvk) {
+			gvks = append(gvks, gvk)
+		}
+	}
+	c.clusterCache.Watch(gvks)
+}
+
+// maxEventDiffLength bounds the diff included in the sync Event
+const maxEventDiffLength = 1024
+
+// maxHistory is the number of successful syncs kept in the history
+const maxHistory = 10
+
+// syncConditionTypes are the conditions describing a failed sync
+var syncConditionTypes = []string{
+	v1alpha1.ApplicationConditionFetchError,
+	v1alpha1.ApplicationConditionSignatureError,
+	v1alpha1.ApplicationConditionRenderError,
+	v1alpha1.ApplicationConditionDiffError,
+	v1alpha1.ApplicationConditionApplyError,
+}
+
+// syncFailed records a failed sync in the status of the application, only the condition
+// of the failed stage is kept. The health of the application is left untouched, so are
+// the resources when nil. The operation is recorded as failed when not nil.
+// It returns the error so it can be used on return.
+func (c *Controller) syncFailed(
+	ctx context.Context,
+	app *v1alpha1.Application,
+	conditionType string,
+	revision string,
+	resources []v1alpha1.ResourceStatus,
+	state *v1alpha1.OperationState,
+	syncErr error,
+) error {
+	syncStatus := v1alpha1.SyncStatusCode(v1alpha1.SyncStatusUnknown)
+	if conditionType == v1alpha1.ApplicationConditionApplyError {
+		// The diff is done, we know the cluster is not in the desired state
+		syncStatus = v1alpha1.SyncStatusOutOfSync
+	}
+
+	err := c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.Sync = v1alpha1.SyncStatus{
+			Status:   syncStatus,
+			Revision: revision,
+		}
+		status.Message = syncErr.Error()
+		status.ObservedGeneration = app.Generation
+		if resources != nil {
+			status.Resources = resources
+		}
+		if state != nil {
+			finishOperation(state, v1alpha1.OperationFailed, syncErr.Error())
+			status.OperationState = state
+		}
+		for _, t := range syncConditionTypes {
+			if t != conditionType {
+				meta.RemoveStatusCondition(&status.Conditions, t)
+			}
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             metav1.ConditionTrue,
+			Reason:             strings.TrimSuffix(conditionType, "Error") + "Failed",
+			Message:            syncErr.Error(),
+			ObservedGeneration: app.Generation,
+		})
+	})
+	if err != nil {
+		log.WithField("application", app.Name).Errorf("Error updating application status: %s", err)
+	}
+
+	return syncErr
+}
+
+// generateManifests renders the manifests of an application from the checkout of its
+// repository, using the source type set in the spec, or the detected one.
+func (c *Controller) generateManifests(app *v1alpha1.Application, source *v1alpha1.ApplicationSource, repoPath string) ([]*unstructured.Unstructured, error) {
+	appPath := path.Join(repoPath, source.Path)
+	sourceType := source.SourceType
+	if sourceType == "" {
+		switch {
+		case helm.IsChart(appPath):
+			sourceType = v1alpha1.SourceTypeHelm
+		case kustomize.IsKustomization(appPath):
+			sourceType = v1alpha1.SourceTypeKustomize
+		default:
+			sourceType = v1alpha1.SourceTypeDirectory
+		}
+	}
+
+	switch sourceType {
+	case v1alpha1.SourceTypeHelm:
+		opts := &helm.TemplateOptions{
+			ReleaseName: app.Name,
+			Namespace:   app.Namespace,
+			RootPath:    repoPath,
+		}
+		if h := source.Helm; h != nil {
+			if h.ReleaseName != "" {
+				opts.ReleaseName = h.ReleaseName
+			}
+			opts.ValueFiles = h.ValueFiles
+			opts.Values = h.Values
+		}
+		return c.helmUtil.Template(appPath, opts)
+	case v1alpha1.SourceTypeKustomize:
+		var opts *kustomize.BuildOptions
+		if k := source.Kustomize; k != nil {
+			opts = &kustomize.BuildOptions{
+				Images:       k.Images,
+				NamePrefix:   k.NamePrefix,
+				NameSuffix:   k.NameSuffix,
+				CommonLabels: k.CommonLabels,
+				Namespace:    k.Namespace,
+			}
+		}
+		return c.kustomizeUtil.Build(appPath, opts)
+	default:
+		return c.k8sUtil.GenerateManifests(appPath)
+	}
+}
+
+// pruneResources deletes the live resources of an application that are no longer
+// part of the generated resources, dependents first. The error of a resource that
+// couldn't be deleted is recorded in its status.
+func (c *Controller) pruneResources(
+	ctx context.Context,
+	app *v1alpha1.Application,
+	diffs []k8sutil.ResourceDiff,
+	resourceByObj map[*unstructured.Unstructured]*v1alpha1.ResourceStatus,
+	state *v1alpha1.OperationState,
+) ([]v1alpha1.ResourceRef, error) {
+	var orphans []*unstructured.Unstructured
+	for _, d := range diffs {
+		if d.Desired == nil {
+			orphans = append(orphans, d.Live)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil, nil
+	}
+	k8sutil.SortByUninstallOrder(orphans)
+
+	var pruned []v1alpha1.ResourceRef
+	for _, r := range orphans {
+		log.WithField("application", app.Name).Infof("Pruning %s %s/%s", r.GetKind(), r.GetNamespace(), r.GetName())
+		err := c.k8sUtil.DeleteResource(ctx, r, r.GetNamespace(), metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			err = nil
+		}
+		addResourceResult(state, r, v1alpha1.ResourceResultPruned, v1alpha1.ResourceResultPruneFailed, err)
+		if err != nil {
+			resourceByObj[r].Message = err.Error()
+			return pruned, fmt.Errorf("error deleting %s %s/%s: %s", r.GetKind(), r.GetNamespace(), r.GetName(), err)
+		}
+
+		pruned = append(pruned, newResourceRef(r))
+		c.eventRecorder.Eventf(
+			app,
+			corev1.EventTypeNormal,
+			common.ResourcePruned,
+			common.MessageResourcePruned,
+			r.GetKind(),
+			cache.NewObjectName(r.GetNamespace(), r.GetName()).String(),
+		)
+	}
+
+	return pruned, nil
+}
+
+// refreshHealth reassesses the health of the resources of an application from their live
+// objects in the cluster cache, without comparing them with Git
+func (c *Controller) refreshHealth(ctx context.Context, app *v1alpha1.Application) error {
+	liveResources, err := c.clusterCache.GetResources(app.Namespace, app.Name)
+	if err != nil {
+		return err
+	}
+	liveByRef := make(map[v1alpha1.ResourceRef]*unstructured.Unstructured, len(liveResources))
+	for _, r := range liveResources {
+		liveByRef[newResourceRef(r)] = r
+	}
+
+	resources := make([]v1alpha1.ResourceStatus, 0, len(app.Status.Resources))
+	for _, r := range app.Status.Resources {
+		resource := r
+		if live, ok := liveByRef[r.ResourceRef]; ok {
+			c.setResourceHealth(&resource, live)
+		} else if resource.Status != v1alpha1.SyncStatusOrphaned {
+			resource.Health = v1alpha1.HealthStatusMissing
+			resource.HealthMessage = ""
+		}
+		resources = append(resources, resource)
+	}
+
+	health := aggregateHealth(resources)
+	if health == app.Status.HealthStatus && equality.Semantic.DeepEqual(resources, app.Status.Resources) {
+		return nil
+	}
+	log.WithField("application", app.Name).Infof("Application is %s", health)
+
+	return c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.HealthStatus = health
+		status.Resources = resources
+	})
+}
+
+// newResourceStatuses returns the status of every compared resource,
+// and an index from the live and desired objects to their status
+func (c *Controller) newResourceStatuses(diffs []k8sutil.ResourceDiff) ([]v1alpha1.ResourceStatus, map[*unstructured.Unstructured]*v1alpha1.ResourceStatus) {
+	if len(diffs) == 0 {
+		return nil, nil
+	}
+
+	resources := make([]v1alpha1.ResourceStatus, len(diffs))
+	resourceByObj := make(map[*unstructured.Unstructured]*v1alpha1.ResourceStatus, len(diffs))
+
+	for i, d := range diffs {
+		resource := &resources[i]
+		switch {
+		case d.Desired == nil:
+			resource.Status = v1alpha1.SyncStatusOrphaned
+		case d.Live == nil:
+			resource.Status = v1alpha1.SyncStatusMissing
+		case d.Modified:
+			resource.Status = v1alpha1.SyncStatusOutOfSync
+		default:
+			resource.Status = v1alpha1.SyncStatusSynced
+		}
+
+		// The live resource has the actual namespace of the resource
+		if d.Live != nil {
+			resource.ResourceRef = newResourceRef(d.Live)
+			c.setResourceHealth(resource, d.Live)
+			resourceByObj[d.Live] = resource
+		} else {
+			resource.ResourceRef = newResourceRef(d.Desired)
+			resource.Health = v1alpha1.HealthStatusMissing
+		}
+		if d.Desired != nil {
+			resourceByObj[d.Desired] = resource
+		}
+	}
+
+	return resources, resourceByObj
+}
+
+// setResourceHealth assesses the health of a resource from its live object
+func (c *Controller) setResourceHealth(resource *v1alpha1.ResourceStatus, live *unstructured.Unstructured) {
+	health, err := c.healthChecker.GetResourceHealth(live)
+	if err != nil {
+		resource.Health = v1alpha1.HealthStatusDegraded
+		resource.HealthMessage = err.Error()
+		return
+	}
+	resource.Health = v1alpha1.HealthStatusCode(health.Status)
+	resource.HealthMessage = health.Message
+}
+
+// aggregateHealth combines the health of the resources of an application: Progressing until
+// every resource is ready, then Degraded if any resource is, Healthy otherwise.
+// Orphaned resources are no longer part of the application and are ignored.
+func aggregateHealth(resources []v1alpha1.ResourceStatus) v1alpha1.HealthStatusCode {
+	health := v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy)
+	for _, r := range resources {
+		if r.Status == v1alpha1.SyncStatusOrphaned {
+			continue
+		}
+		switch r.Health {
+		case v1alpha1.HealthStatusProgressing, v1alpha1.HealthStatusMissing:
+			return v1alpha1.HealthStatusProgressing
+		case v1alpha1.HealthStatusDegraded:
+			health = v1alpha1.HealthStatusDegraded
+		}
+	}
+	return health
+}
+
+// needsApply returns whether a desired resource is missing or different in the cluster
+func needsApply(diffs []k8sutil.ResourceDiff) bool {
+	for _, d := range diffs {
+		if d.Desired != nil && (d.Live == nil || d.Modified) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOrphans returns whether live resources are no longer defined in Git
+func hasOrphans(diffs []k8sutil.ResourceDiff) bool {
+	for _, d := range diffs {
+		if d.Desired == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func newResourceRef(obj *unstructured.Unstructured) v1alpha1.ResourceRef {
+	gvk := obj.GroupVersionKind()
+	return v1alpha1.ResourceRef{
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+// finalizeApp cleans up the resources of an application that is being deleted
+// following its deletion policy, then removes the finalizer.
+// It returns false if the resources are still being deleted.
+func (c *Controller) finalizeApp(ctx context.Context, app *v1alpha1.Application) (bool, error) {
+	if !slices.Contains(app.GetFinalizers(), common.FinalizerResources) {
+		return true, nil
+	}
+
+	remaining, err := c.deleteResources(ctx, app)
+	if err != nil {
+		return false, err
+	}
+	if remaining > 0 {
+		log.WithField("application", app.Name).Infof("Waiting for %d resources to be deleted", remaining)
+		return false, nil
+	}
+
+	err = c.removeFinalizer(ctx, app)
+	if err != nil {
+		return false, fmt.Errorf("error removing finalizer: %s", err)
+	}
+
+	return true, nil
+}
+
+// deleteResources deletes the resources of an application following its deletion policy.
+// It returns the number of resources that are still being deleted.
+func (c *Controller) deleteResources(ctx context.Context, app *v1alpha1.Application) (int, error) {
+	if app.Name == "" {
+		return 0, fmt.Errorf("application name is empty")
+	}
+
+	policy := app.Spec.DeletionPolicy
+	if policy == "" {
+		policy = v1alpha1.DeletionPolicyForeground
+	}
+
+	if policy != v1alpha1.DeletionPolicyOrphan {
+		// Get all resources of the application
+		resources, err := c.clusterCache.GetResources(app.Namespace, app.Name)
 		if err != nil {
-			return fmt.Errorf("error deleting resources: %s", err)
+			return 0, fmt.Errorf("error getting resources: %s", err)
+		}
+
+		if len(resources) > 0 {
+			log.WithField("application", app.Name).Info("Deleting resources")
+
+			propagationPolicy := metav1.DeletePropagationBackground
+			if policy == v1alpha1.DeletionPolicyForeground {
+				propagationPolicy = metav1.DeletePropagationForeground
+			}
+
+			// Dependents first
+			var deleteErr error
+			deleteErrs := make(map[v1alpha1.ResourceRef]error)
+			k8sutil.SortByUninstallOrder(resources)
+			for _, r := range resources {
+				err := c.k8sUtil.DeleteResource(ctx, r, r.GetNamespace(), metav1.DeleteOptions{
+					PropagationPolicy: &propagationPolicy,
+				})
+				if err != nil && !apierrors.IsNotFound(err) {
+					deleteErrs[newResourceRef(r)] = err
+					if deleteErr == nil {
+						deleteErr = fmt.Errorf("error deleting resources: %s", err)
+					}
+				}
+			}
+
+			// Foreground deletion is done once the resources are gone
+			waiting := policy == v1alpha1.DeletionPolicyForeground
+			if waiting || deleteErr != nil {
+				c.updateResourcesBeingDeleted(ctx, app, resources, deleteErrs)
+			}
+			if deleteErr != nil {
+				return 0, deleteErr
+			}
+			if waiting {
+				return len(resources), nil
+			}
 		}
+	} else {
+		log.WithField("application", app.Name).Info("Orphaning resources")
 	}
 
-	err = c.gitUtil.CleanUp(repoPath)
+	worktree := cache.NewObjectName(app.Namespace, app.Name).String()
+	c.appLocks.LockKey(worktree)
+	err := c.gitUtil.CleanUp(worktree)
+	c.appLocks.UnlockKey(worktree)
 	if err != nil {
-		return fmt.Errorf("error cleaning up repository: %s", err)
+		return 0, fmt.Errorf("error cleaning up repository: %s", err)
 	}
 	log.WithField("application", app.Name).Info("Resources deleted")
 
-	return nil
+	return 0, nil
+}
+
+// updateResourcesBeingDeleted replaces the resources in the status of an application
+// with the resources that are still being deleted, and the errors that happened deleting them
+func (c *Controller) updateResourcesBeingDeleted(
+	ctx context.Context,
+	app *v1alpha1.Application,
+	liveResources []*unstructured.Unstructured,
+	deleteErrs map[v1alpha1.ResourceRef]error,
+) {
+	previous := make(map[v1alpha1.ResourceRef]v1alpha1.ResourceStatus, len(app.Status.Resources))
+	for _, r := range app.Status.Resources {
+		previous[r.ResourceRef] = r
+	}
+
+	resources := make([]v1alpha1.ResourceStatus, 0, len(liveResources))
+	for _, r := range liveResources {
+		ref := newResourceRef(r)
+		resource, ok := previous[ref]
+		if !ok {
+			resource = v1alpha1.ResourceStatus{
+				ResourceRef: ref,
+				Status:      v1alpha1.SyncStatusOrphaned,
+			}
+		}
+		c.setResourceHealth(&resource, r)
+		resource.Message = ""
+		if err, ok := deleteErrs[ref]; ok {
+			resource.Message = err.Error()
+		}
+		resources = append(resources, resource)
+	}
+
+	err := c.updateAppStatus(ctx, app, func(status *v1alpha1.ApplicationStatus) {
+		status.Resources = resources
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.WithField("application", app.Name).Errorf("Error updating application status: %s", err)
+	}
+}
+
+// addFinalizer makes sure the application can't be removed before its resources are cleaned up
+func (c *Controller) addFinalizer(ctx context.Context, app *v1alpha1.Application) error {
+	if slices.Contains(app.GetFinalizers(), common.FinalizerResources) {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		queryApp, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if slices.Contains(queryApp.GetFinalizers(), common.FinalizerResources) {
+			return nil
+		}
+
+		queryApp.SetFinalizers(append(queryApp.GetFinalizers(), common.FinalizerResources))
+		_, err = c.appClientSet.ThongdepzaiV1alpha1().Applications(queryApp.Namespace).Update(ctx, queryApp, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) removeFinalizer(ctx context.Context, app *v1alpha1.Application) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		queryApp, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		finalizers := slices.DeleteFunc(queryApp.GetFinalizers(), func(f string) bool {
+			return f == common.FinalizerResources
+		})
+		queryApp.SetFinalizers(finalizers)
+		_, err = c.appClientSet.ThongdepzaiV1alpha1().Applications(queryApp.Namespace).Update(ctx, queryApp, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// handleResourceEvent requests a refresh of the application owning a resource changed in the
+// cluster. Its revision didn't move, so a poll would skip the comparison.
+func (c *Controller) handleResourceEvent(appNamespace, appName string, obj *unstructured.Unstructured) {
+	if appName == "" {
+		return
+	}
+
+	// The resources labelled without the namespace belong to the applications of their
+	// name in any namespace, until their next sync labels them again
+	var apps []*v1alpha1.Application
+	if appNamespace == "" {
+		all, err := c.appLister.List(labels.Everything())
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		for _, app := range all {
+			if app.Name == appName {
+				apps = append(apps, app)
+			}
+		}
+	} else {
+		app, err := c.appLister.Applications(appNamespace).Get(appName)
+		if apierrors.IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		apps = append(apps, app)
+	}
+
+	for _, app := range apps {
+		if app.DeletionTimestamp != nil {
+			continue
+		}
+		log.WithField("application", app.Name).Infof("%s %s changed in the cluster", obj.GetKind(), cache.NewObjectName(obj.GetNamespace(), obj.GetName()))
+		c.requestAppRefresh(app.Name, app.Namespace)
+	}
+}
+
+// HandleWebhook refreshes the applications whose revision may be moved by a push notified by
+// a Git provider. The refreshes are polls, so a replayed payload doesn't sync anything new
+func (c *Controller) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	event, err := c.webhookUtil.Parse(r)
+	if errors.Is(err, webhook.ErrIgnoredEvent) {
+		return
+	}
+	if errors.Is(err, webhook.ErrUnauthorized) {
+		log.Warnf("Rejecting webhook: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apps, err := c.appLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		http.Error(w, "failed to list applications", http.StatusInternalServerError)
+		return
+	}
+	for _, app := range apps {
+		if app.DeletionTimestamp != nil || !event.Affects(app.Spec.Repository, app.Spec.Revision) {
+			continue
+		}
+
+		log.WithField("application", app.Name).Infof("Push of %s to %s", strings.Join(event.Refs, ", "), app.Spec.Repository)
+		c.appRefreshQueue.Add(cache.NewObjectName(app.Namespace, app.Name).String())
+	}
+}
+
+// loadHealthChecks replaces the custom health checks with the ones in the ConfigMap,
+// a nil ConfigMap removes them
+func (c *Controller) loadHealthChecks(obj interface{}) {
+	var checks map[string]string
+	if obj != nil {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			log.Error("Error decoding object, invalid type")
+			return
+		}
+		checks = configMap.Data
+	}
+
+	log.Infof("Loading %d custom health checks", len(checks))
+	err := c.healthChecker.SetCustomHealthChecks(checks)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading custom health checks: %s", err))
+	}
+}
+
+// loadGPGKeys replaces the keyring with the armored public keys of the ConfigMap,
+// a nil ConfigMap empties it
+func (c *Controller) loadGPGKeys(obj interface{}) {
+	var keys map[string]string
+	if obj != nil {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			log.Error("Error decoding object, invalid type")
+			return
+		}
+		keys = configMap.Data
+	}
+
+	keyRing, err := git.NewKeyRing(keys)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading GPG keys: %s", err))
+	}
+	log.Infof("Loading %d GPG keys", len(keyRing))
+
+	c.gpgKeysMutex.Lock()
+	defer c.gpgKeysMutex.Unlock()
+	c.gpgKeys = keyRing
+}
+
+// loadWebhookSecrets replaces the secrets of the webhooks with the ones in the Secret,
+// a nil Secret removes them
+func (c *Controller) loadWebhookSecrets(obj interface{}) {
+	var secrets map[string][]byte
+	if obj != nil {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			log.Error("Error decoding object, invalid type")
+			return
+		}
+		secrets = secret.Data
+	}
+
+	log.Infof("Loading %d webhook secrets", len(secrets))
+	c.webhookUtil.SetSecrets(secrets)
 }
 
 func (c *Controller) handleAdd(obj interface{}) {
 	log.Debugf("Application added")
 
-	c.queue.AddRateLimited(obj)
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.AddRateLimited(key)
 }
 
 func (c *Controller) handleDelete(obj interface{}) {
 	log.Debugf("Application deleted")
 
-	c.queue.AddRateLimited(obj)
+	// obj can be a DeletedFinalStateUnknown tombstone
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.AddRateLimited(key)
 }
 
 func (c *Controller) handleUdate(old, new interface{}) {
@@ -411,34 +1647,44 @@ func (c *Controller) handleUdate(old, new interface{}) {
 		return
 	}
 
-	// If there are changes in fields other than spec, we don't need to reconcile
-	if !equality.Semantic.DeepEqual(oldApp.ObjectMeta, newApp.ObjectMeta) || !equality.Semantic.DeepEqual(oldApp.Status, newApp.Status) {
+	// The application is being deleted, let the main queue clean it up
+	if newApp.DeletionTimestamp != nil {
+		key, err := cache.MetaNamespaceKeyFunc(newApp)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	// A sync is requested
+	if newApp.Operation != nil && !equality.Semantic.DeepEqual(oldApp.Operation, newApp.Operation) {
+		c.requestAppRefresh(newApp.GetName(), newApp.GetNamespace())
 		return
 	}
 
-	// Compare old and new spec
+	// The other changes, e.g. of the status, and the resyncs are left to the polls
 	if equality.Semantic.DeepEqual(oldApp.Spec, newApp.Spec) {
 		log.Debugf("No changes in application spec: %s", newApp.Name)
+		return
 	}
 
-	jitter := c.jitter()
-	time.Sleep(jitter)
-
-	// TODO: use a cache instead of depending on Informer
 	c.requestAppRefresh(newApp.GetName(), newApp.GetNamespace())
 }
 
 // updateAppStatus to safely update the status of an application.
 // We need this instead of using UpdateStatus() since the obj can
 // be updated between the time we get and do the status modification.
-func (c *Controller) updateAppStatus(ctx context.Context, app *v1alpha1.Application, status *v1alpha1.ApplicationStatus) error {
+// mutate is applied on the latest status on every try.
+func (c *Controller) updateAppStatus(ctx context.Context, app *v1alpha1.Application, mutate func(status *v1alpha1.ApplicationStatus)) error {
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		queryApp, err := c.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
 
-		queryApp.Status = *status
+		mutate(&queryApp.Status)
 		_, err = c.appClientSet.ThongdepzaiV1alpha1().Applications(queryApp.Namespace).UpdateStatus(ctx, queryApp, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
@@ -453,11 +1699,74 @@ func (c *Controller) updateAppStatus(ctx context.Context, app *v1alpha1.Applicat
 	return nil
 }
 
+// requestAppRefresh compares the application with Git and the cluster, even if its revision didn't move
 func (c *Controller) requestAppRefresh(appName string, namespace string) {
 	key := namespace + "/" + appName
+
+	c.refreshMutex.Lock()
+	c.refreshRequested[key] = true
+	c.refreshMutex.Unlock()
+
 	c.appRefreshQueue.AddRateLimited(key)
 }
 
-func (c *Controller) jitter() time.Duration {
-	return time.Duration(rand.Int63n(int64(c.resyncPeriod)))
+// isRefreshRequested returns whether a refresh of the application was requested since
+// its last refresh, and clears it
+func (c *Controller) isRefreshRequested(key string) bool {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	requested := c.refreshRequested[key]
+	delete(c.refreshRequested, key)
+	return requested
+}
+
+// nextPoll returns when the repository of the application is checked next,
+// with a jitter spreading the polls of the applications
+func (c *Controller) nextPoll(app *v1alpha1.Application) time.Duration {
+	interval := c.pollInterval
+	if app.Spec.PollInterval != nil && app.Spec.PollInterval.Duration > 0 {
+		interval = app.Spec.PollInterval.Duration
+	}
+
+	return interval + c.jitter(interval/10)
+}
+
+func (c *Controller) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// isUpToDate returns whether the last comparison of the application was done successfully
+// with its current spec and the revision the remote resolves now. The changes made in the
+// cluster request their own refresh.
+func (c *Controller) isUpToDate(ctx context.Context, app *v1alpha1.Application) bool {
+	if app.Status.Sync.Revision == "" || app.Status.Sync.Status == v1alpha1.SyncStatusUnknown ||
+		app.Status.ObservedGeneration != app.Generation {
+		return false
+	}
+	for _, conditionType := range syncConditionTypes {
+		if meta.IsStatusConditionTrue(app.Status.Conditions, conditionType) {
+			return false
+		}
+	}
+
+	// A commit can't move, and the remote can't resolve the short SHAs anyway
+	if git.IsCommitSHA(app.Spec.Revision) {
+		return strings.HasPrefix(app.Status.Sync.Revision, app.Spec.Revision)
+	}
+
+	creds, err := c.getCredentials(ctx, app, app.Spec.Repository)
+	if err != nil {
+		return false
+	}
+	sha, err := c.gitUtil.LsRemote(app.Spec.Repository, app.Spec.Revision, creds)
+	if err != nil {
+		log.WithField("application", app.Name).Debugf("Error listing remote references, fetching: %s", err)
+		return false
+	}
+
+	return sha == app.Status.Sync.Revision
 }
@@ -3,23 +3,46 @@ package controller
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/apis/application/v1alpha1"
 	appclientset "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/clientset/versioned/fake"
 	appinformers "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/informers/externalversions"
+	applisters "github.com/minhthong582000/k8s-controller-pattern/gitops/pkg/listers/application/v1alpha1"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache"
+	clusterCacheMock "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache/mock"
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/git"
 	gitMock "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/git/mock"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm"
+	helmMock "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm/mock"
 	k8sUtil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
 	k8sUtilMock "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube/mock"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize"
+	kustomizeMock "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize/mock"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func newFakeApp(appString string) *v1alpha1.Application {
@@ -32,61 +55,441 @@ func newFakeApp(appString string) *v1alpha1.Application {
 	return &app
 }
 
-func newFakeController(gitClient git.GitClient, k8sUtil k8sUtil.K8s, apps ...runtime.Object) *Controller {
+func newFakeController(gitClient git.GitClient, kubeUtil k8sUtil.K8s, kustomizeUtil kustomize.Kustomize, helmUtil helm.Helm, clusterCache clustercache.ClusterCache, apps ...runtime.Object) *Controller {
 	kubeClientSet := fake.NewSimpleClientset()
 	appClientSet := appclientset.NewSimpleClientset(apps...)
 	appInformerFactory := appinformers.NewSharedInformerFactory(appClientSet, time.Second*30)
+	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClientSet, time.Second*30)
+	healthChecker, _ := k8sUtil.NewHealthChecker()
+	if clusterCache == nil {
+		clusterCache = clustercache.NewClusterCache(
+			kubeClientSet.Discovery(),
+			dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+			0,
+			common.LabelKeyAppInstance,
+			common.LabelKeyAppNamespace,
+		)
+	}
 
 	return NewController(
 		kubeClientSet,
 		appClientSet,
 		appInformerFactory.Thongdepzai().V1alpha1().Applications(),
 		gitClient,
-		k8sUtil,
-		30*time.Second,
+		kubeUtil,
+		kustomizeUtil,
+		helmUtil,
+		healthChecker,
+		kubeInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().Secrets(),
+		webhook.NewWebhook(),
+		kubeInformerFactory.Core().V1().Secrets(),
+		clusterCache,
+		3*time.Minute,
 	)
 }
 
+// testCommitInfo is the metadata of the commit randomsha
+var testCommitInfo = &git.CommitInfo{
+	Author:    "Jane Doe <jane@example.com>",
+	Committer: "Jane Doe <jane@example.com>",
+	Date:      time.Date(2024, 5, 13, 14, 0, 0, 0, time.UTC),
+	Subject:   "Update nginx",
+	Tags:      []string{"v1.0.0"},
+}
+
+// newMockClusterCache returns a synced cluster cache holding the live resources of the application
+func newMockClusterCache(ctrl *gomock.Controller, resources ...*unstructured.Unstructured) clustercache.ClusterCache {
+	mock := clusterCacheMock.NewMockClusterCache(ctrl)
+	mock.EXPECT().AddEventHandler(gomock.Any()).AnyTimes()
+	mock.EXPECT().Watch(gomock.Any()).AnyTimes()
+	mock.EXPECT().HasSynced().Return(true).AnyTimes()
+	mock.EXPECT().GetResources(gomock.Any(), gomock.Any()).Return(resources, nil).AnyTimes()
+	return mock
+}
+
 func Test_CreateResources(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	createResourcesTestCases := []struct {
-		name           string
-		app            string
-		mockGitClient  git.GitClient
-		mockk8sUtil    k8sUtil.K8s
-		expectedOut    string
-		expectedStatus v1alpha1.HealthStatusCode
-		expectedErr    string
+		name              string
+		app               string
+		mockGitClient     git.GitClient
+		mockk8sUtil       k8sUtil.K8s
+		mockClusterCache  clustercache.ClusterCache
+		mockKustomize     kustomize.Kustomize
+		mockHelm          helm.Helm
+		expectedOut       string
+		expectedStatus    v1alpha1.HealthStatusCode
+		expectedSync      v1alpha1.SyncStatus
+		expectedPruned    []v1alpha1.ResourceRef
+		expectedResources []v1alpha1.ResourceStatus
+		expectedMessage   string
+		expectedCond      string
+		expectedErr       string
+		expectedPhase     v1alpha1.OperationPhase
+		expectedResults   []v1alpha1.ResourceResult
+		expectedHistory   []string
+		// expectedAutomatedDisabled checks the automated sync is disabled by a rollback
+		expectedAutomatedDisabled bool
 	}{
 		{
-			name: "Should create resources successfully if the repository is valid",
+			name: "Should create resources successfully and wait for them to be ready if the repository is valid",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("", "nginx")
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{deployment}, nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Desired: deployment},
+				}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().CreateResource(gomock.Any(), deployment, gomock.Any()).Return(deployment, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusProgressing),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 1, Modified: 0, Removed: 0},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusSynced,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+				},
+			},
+			expectedPhase: v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+			},
+			expectedHistory: []string{"randomsha"},
+		},
+		{
+			name: "Should fetch the revision shallow and check out the path and the extra paths only",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  clone:
+    depth: 1
+    singleBranch: true
+    sparse: true
+    extraPaths:
+    - k8s-controller-pattern/base
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), &git.CloneOptions{Depth: 1, SingleBranch: true, Revision: "main"}).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), "main", gomock.Any(), []string{"k8s-controller-pattern/gitops", "k8s-controller-pattern/base"}).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("", "nginx")
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{deployment}, nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Desired: deployment},
+				}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().CreateResource(gomock.Any(), deployment, gomock.Any()).Return(deployment, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusProgressing),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 1, Modified: 0, Removed: 0},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusSynced,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+				},
+			},
+			expectedPhase: v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+			},
+			expectedHistory: []string{"randomsha"},
+		},
+		{
+			name: "Should record the error of the resources that failed to be created",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				liveDeployment := newFakeDeployment("default", "nginx")
+				deployment := newFakeDeployment("default", "nginx")
+				deployment.Object["spec"] = map[string]interface{}{"replicas": int64(2)}
+				configMap := &unstructured.Unstructured{}
+				configMap.SetAPIVersion("v1")
+				configMap.SetKind("ConfigMap")
+				configMap.SetNamespace("default")
+				configMap.SetName("nginx")
+
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{deployment, configMap}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: liveDeployment, Desired: deployment, Modified: true},
+					{Desired: configMap},
+				}, nil)
+				mock.EXPECT().CreateResource(gomock.Any(), configMap, "default").Return(configMap, nil)
+				mock.EXPECT().CreateResource(gomock.Any(), deployment, "default").Return(nil, fmt.Errorf("admission webhook denied the request"))
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:   v1alpha1.SyncStatusOutOfSync,
+				Revision: "randomsha",
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusOutOfSync,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+					Message:       "admission webhook denied the request",
+				},
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+			expectedCond:  v1alpha1.ApplicationConditionApplyError,
+			expectedErr:   "error creating resources: Deployment nginx: admission webhook denied the request",
+			expectedPhase: v1alpha1.OperationFailed,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+				{ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSyncFailed, Message: "admission webhook denied the request"},
+			},
+		},
+		{
+			name: "Should keep the resources that are no longer in the repository as orphaned if prune is disabled, ignoring their health",
 			app: `
 kind: Application
 apiVersion: thongdepzai.cloud/v1alpha1
 metadata:
   name: test-example-application-one
+  namespace: default
 spec:
   repository: https://github.com/minhthong582000/k8s-controller-pattern.git
   revision: main
   path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
 `,
 			mockGitClient: func() git.GitClient {
 				mock := gitMock.NewMockGitClient(ctrl)
-				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any()).Return(nil)
-				mock.EXPECT().Checkout(gomock.Any(), gomock.Any()).Return("randomsha", nil)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
 				return mock
 			}(),
 			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("default", "nginx")
 				mock := k8sUtilMock.NewMockK8s(ctrl)
 				mock.EXPECT().GenerateManifests(gomock.Any()).Return(nil, nil)
-				mock.EXPECT().GetResourceWithLabel(gomock.Any()).Return(nil, nil)
-				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any()).Return(true, nil)
 				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
-				mock.EXPECT().CreateResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: deployment},
+				}, nil)
 				return mock
 			}(),
-			expectedStatus: v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 0, Removed: 1},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusOrphaned,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+				},
+			},
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedHistory: []string{"randomsha"},
+		},
+		{
+			name: "Should only mark the application OutOfSync if the resources drifted and self heal is disabled",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+status:
+  revision: randomsha
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				liveConfigMap := newFakeConfigMap("default", "nginx")
+				configMap := newFakeConfigMap("default", "nginx")
+				configMap.Object["data"] = map[string]interface{}{"key": "value"}
+				service := newFakeService("default", "nginx")
+
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{configMap, service}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: liveConfigMap, Desired: configMap, Modified: true, Changes: []k8sUtil.FieldChange{{Path: "data.key", Type: k8sUtil.ChangeModified}}},
+					{Desired: service},
+				}, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeConfigMap("default", "nginx")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusProgressing),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusOutOfSync,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 1, Modified: 1, Removed: 0},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusOutOfSync,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "Service", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusMissing,
+					Health:      v1alpha1.HealthStatusMissing,
+				},
+			},
+			expectedMessage: "Resources drifted from Git: ConfigMap default/nginx (data.key); Service nginx was deleted",
+		},
+		{
+			name: "Should re-apply the resources that drifted if self heal is enabled",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated:
+      selfHeal: true
+status:
+  revision: randomsha
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				liveConfigMap := newFakeConfigMap("default", "nginx")
+				configMap := newFakeConfigMap("default", "nginx")
+				configMap.Object["data"] = map[string]interface{}{"key": "value"}
+
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{configMap}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: liveConfigMap, Desired: configMap, Modified: true, Changes: []k8sUtil.FieldChange{{Path: "data.key", Type: k8sUtil.ChangeModified}}},
+				}, nil)
+				mock.EXPECT().CreateResource(gomock.Any(), configMap, "default").Return(configMap, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeConfigMap("default", "nginx")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 1, Removed: 0},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+			expectedMessage: common.MessageResourceSynced,
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+			},
+			expectedHistory: []string{"randomsha"},
 		},
 		{
 			name: "Should create resources successfully even if there is no diff between the old and new resources",
@@ -99,142 +502,1872 @@ spec:
   repository: https://github.com/minhthong582000/k8s-controller-pattern.git
   revision: main
   path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+status:
+  conditions:
+    - type: FetchError
+      status: "True"
+      reason: FetchFailed
+      message: "error cloning repository: connection refused"
+      lastTransitionTime: "2024-06-01T00:00:00Z"
 `,
 			mockGitClient: func() git.GitClient {
 				mock := gitMock.NewMockGitClient(ctrl)
-				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any()).Return(nil)
-				mock.EXPECT().Checkout(gomock.Any(), gomock.Any()).Return("randomsha", nil)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
 				return mock
 			}(),
 			mockk8sUtil: func() k8sUtil.K8s {
 				mock := k8sUtilMock.NewMockK8s(ctrl)
 				mock.EXPECT().GenerateManifests(gomock.Any()).Return(nil, nil)
-				mock.EXPECT().GetResourceWithLabel(gomock.Any()).Return(nil, nil)
 				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
-				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any()).Return(false, nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
 				return mock
 			}(),
-			expectedStatus: v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			mockClusterCache: newMockClusterCache(ctrl),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 0, Removed: 0},
+			},
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedHistory: []string{"randomsha"},
 		},
 		{
-			name: "Should return error if the application has invalid repository",
+			name: "Should prune resources that are no longer in the repository if prune is enabled",
 			app: `
 kind: Application
 apiVersion: thongdepzai.cloud/v1alpha1
 metadata:
-  name: example-application
+  name: test-example-application-one
+  namespace: default
 spec:
-  repository: https://github.com/kubernetes/kubernetes-but-not-exist.git
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
   revision: main
   path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated:
+      prune: true
 `,
 			mockGitClient: func() git.GitClient {
 				mock := gitMock.NewMockGitClient(ctrl)
-				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any()).Return(
-					fmt.Errorf("failed to clone repository: authentication required"),
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := &unstructured.Unstructured{}
+				deployment.SetAPIVersion("apps/v1")
+				deployment.SetKind("Deployment")
+				deployment.SetNamespace("default")
+				deployment.SetName("nginx")
+				service := &unstructured.Unstructured{}
+				service.SetAPIVersion("v1")
+				service.SetKind("Service")
+				service.SetNamespace("default")
+				service.SetName("nginx")
+
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return(nil, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: service},
+					{Live: deployment},
+				}, nil)
+				gomock.InOrder(
+					mock.EXPECT().DeleteResource(gomock.Any(), deployment, "default", gomock.Any()).Return(nil),
+					mock.EXPECT().DeleteResource(gomock.Any(), service, "default", gomock.Any()).Return(nil),
 				)
 				return mock
 			}(),
-			expectedStatus: v1alpha1.HealthStatusCode(v1alpha1.HealthStatusProgressing),
-			expectedErr:    "error cloning repository: failed to clone repository: authentication required",
+			mockClusterCache: newMockClusterCache(ctrl, newFakeService("default", "nginx"), newFakeDeployment("default", "nginx")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 0, Removed: 2},
+			},
+			expectedPruned: []v1alpha1.ResourceRef{
+				{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+				{Version: "v1", Kind: "Service", Namespace: "default", Name: "nginx"},
+			},
+			expectedPhase: v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultPruned},
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "Service", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultPruned},
+			},
+			expectedHistory: []string{"randomsha"},
 		},
-	}
-
-	for _, tt := range createResourcesTestCases {
-		t.Run(tt.name, func(t *testing.T) {
-			ctx := context.Background()
-
-			app := newFakeApp(tt.app)
-			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, app)
-
-			err := controller.createResources(ctx, app)
-			if err != nil {
-				assert.Equal(t, tt.expectedErr, err.Error())
-			}
-
-			// Check the status of the application
-			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
-			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedStatus, queryApp.Status.HealthStatus)
-
-			// Check the last sync time
-			assert.NotNil(t, queryApp.Status.LastSyncAt)
-		})
-	}
-}
-
-func Test_DeleteResources(t *testing.T) {
-	ctrl := gomock.NewController(t)
-
-	testCases := []struct {
-		name           string
-		app            string
-		mockGitClient  git.GitClient
-		mockk8sUtil    k8sUtil.K8s
-		expectedOut    string
-		expectedStatus string
-		expectedErr    string
-	}{
 		{
-			name: "Should delete resources successfully if the application is valid",
+			name: "Should build the kustomization if the source type is Kustomize",
 			app: `
 kind: Application
 apiVersion: thongdepzai.cloud/v1alpha1
 metadata:
-  name: test-another-example-application-one
+  name: test-example-application-one
 spec:
   repository: https://github.com/minhthong582000/k8s-controller-pattern.git
   revision: main
   path: k8s-controller-pattern/gitops
+  sourceType: Kustomize
+  kustomize:
+    images:
+      - nginx:1.27
+    namePrefix: dev-
+  syncPolicy:
+    automated: {}
 `,
 			mockGitClient: func() git.GitClient {
 				mock := gitMock.NewMockGitClient(ctrl)
-				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
 				return mock
 			}(),
 			mockk8sUtil: func() k8sUtil.K8s {
 				mock := k8sUtilMock.NewMockK8s(ctrl)
-				mock.EXPECT().GetResourceWithLabel(gomock.Any()).Return(nil, nil)
-				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
 				return mock
 			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			mockKustomize: func() kustomize.Kustomize {
+				mock := kustomizeMock.NewMockKustomize(ctrl)
+				mock.EXPECT().Build(gomock.Any(), &kustomize.BuildOptions{
+					Images:     []string{"nginx:1.27"},
+					NamePrefix: "dev-",
+				}).Return(nil, nil)
+				return mock
+			}(),
+			expectedStatus: v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 0, Removed: 0},
+			},
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedHistory: []string{"randomsha"},
 		},
 		{
-			name: "Should delete resources successfully even if the application has invalid repository",
+			name: "Should render the chart if the source type is Helm",
 			app: `
 kind: Application
 apiVersion: thongdepzai.cloud/v1alpha1
 metadata:
-  name: test-another-example-application-two
+  name: test-example-application-one
+  namespace: default
 spec:
-  repository: https://github.com/kubernetes/kubernetes-but-not-exist.git
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
   revision: main
   path: k8s-controller-pattern/gitops
+  sourceType: Helm
+  helm:
+    valueFiles:
+      - values-production.yaml
+    values: |
+      replicaCount: 3
+  syncPolicy:
+    automated: {}
 `,
 			mockGitClient: func() git.GitClient {
 				mock := gitMock.NewMockGitClient(ctrl)
-				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
 				return mock
 			}(),
 			mockk8sUtil: func() k8sUtil.K8s {
 				mock := k8sUtilMock.NewMockK8s(ctrl)
-				mock.EXPECT().GetResourceWithLabel(gomock.Any()).Return(nil, nil)
-				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			mockHelm: func() helm.Helm {
+				mock := helmMock.NewMockHelm(ctrl)
+				mock.EXPECT().Template(gomock.Any(), &helm.TemplateOptions{
+					ReleaseName: "test-example-application-one",
+					Namespace:   "default",
+					ValueFiles:  []string{"values-production.yaml"},
+					RootPath:    "randompath",
+					Values:      "replicaCount: 3\n",
+				}).Return(nil, nil)
 				return mock
 			}(),
+			expectedStatus: v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 0, Removed: 0},
+			},
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedHistory: []string{"randomsha"},
 		},
-	}
+		{
+			name: "Should only mark the application OutOfSync with the target revision if it is synced manually",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				configMap := newFakeConfigMap("default", "nginx")
+				orphan := newFakeConfigMap("default", "orphan")
 
-	for _, tt := range testCases {
-		t.Run(tt.name, func(t *testing.T) {
-			app := newFakeApp(tt.app)
-			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, app)
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{configMap}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Desired: configMap},
+					{Live: orphan},
+				}, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeConfigMap("default", "orphan")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusProgressing),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusOutOfSync,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 1, Modified: 0, Removed: 1},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusMissing,
+					Health:      v1alpha1.HealthStatusMissing,
+				},
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "orphan"},
+					Status:      v1alpha1.SyncStatusOrphaned,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+			expectedMessage: "Revision randomsha is not synced, waiting for a sync operation",
+		},
+		{
+			name: "Should sync the application once if a sync operation is requested",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+operation:
+  sync:
+    prune: true
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				configMap := newFakeConfigMap("default", "nginx")
+				orphan := newFakeConfigMap("default", "orphan")
 
-			// Delete resources
-			err := controller.deleteResources(app)
-			if err != nil {
-				assert.Equal(t, tt.expectedErr, err.Error())
-			}
-		})
-	}
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{configMap}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Desired: configMap},
+					{Live: orphan},
+				}, nil)
+				mock.EXPECT().CreateResource(gomock.Any(), configMap, gomock.Any()).Return(configMap, nil)
+				mock.EXPECT().DeleteResource(gomock.Any(), orphan, "default", gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeConfigMap("default", "orphan")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "randomsha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 1, Modified: 0, Removed: 1},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+			expectedPruned: []v1alpha1.ResourceRef{
+				{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "orphan"},
+			},
+			expectedPhase: v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "orphan"}, Status: v1alpha1.ResourceResultPruned},
+			},
+			expectedHistory: []string{"randomsha"},
+		},
+		{
+			name: "Should roll back to the commit and source of an entry of the history and disable the automated sync",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated:
+      selfHeal: true
+operation:
+  sync:
+    historyID: 1
+status:
+  revision: randomsha
+  history:
+    - id: 0
+      revision: oldsha
+      deployedAt: "2024-06-01T00:00:00Z"
+      source:
+        repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+        revision: main
+        path: old/path
+    - id: 1
+      revision: previoussha
+      deployedAt: "2024-06-02T00:00:00Z"
+      source:
+        repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+        revision: main
+        path: previous/path
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch("https://github.com/minhthong582000/k8s-controller-pattern.git", gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), "previoussha", gomock.Any(), gomock.Any()).Return("randompath", "previoussha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "previoussha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				configMap := newFakeConfigMap("default", "nginx")
+
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Cond(func(p any) bool {
+					return strings.HasSuffix(p.(string), "previous/path")
+				})).Return([]*unstructured.Unstructured{configMap}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+					{Live: configMap, Desired: configMap, Modified: true},
+				}, nil)
+				mock.EXPECT().CreateResource(gomock.Any(), configMap, gomock.Any()).Return(configMap, nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeConfigMap("default", "nginx")),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:       v1alpha1.SyncStatusSynced,
+				Revision:     "previoussha",
+				RevisionInfo: newRevisionInfo(testCommitInfo),
+				Diff:         &v1alpha1.DiffSummary{Added: 0, Modified: 1, Removed: 0},
+			},
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+			expectedPhase: v1alpha1.OperationSucceeded,
+			expectedResults: []v1alpha1.ResourceResult{
+				{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+			},
+			expectedHistory:           []string{"oldsha", "previoussha", "previoussha"},
+			expectedAutomatedDisabled: true,
+		},
+		{
+			name: "Should fail the rollback if the entry of the history doesn't exist",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+operation:
+  sync:
+    historyID: 5
+`,
+			expectedSync: v1alpha1.SyncStatus{
+				Status: v1alpha1.SyncStatusUnknown,
+			},
+			expectedCond:              v1alpha1.ApplicationConditionFetchError,
+			expectedErr:               "history ID 5 not found",
+			expectedPhase:             v1alpha1.OperationFailed,
+			expectedAutomatedDisabled: true,
+		},
+		{
+			name: "Should return error if the ignored differences are invalid",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-example-application-one
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  ignoreDifferences:
+    - group: apps
+      kind: Deployment
+      jsonPointers:
+        - spec/replicas
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{newFakeDeployment("", "nginx")}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:   v1alpha1.SyncStatusUnknown,
+				Revision: "randomsha",
+			},
+			expectedCond: v1alpha1.ApplicationConditionDiffError,
+			expectedErr:  `error parsing ignoreDifferences: invalid JSON pointer "spec/replicas", must start with /`,
+		},
+		{
+			name: "Should record the key that signed the revision",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-signed-application
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  signatureKeys:
+    - "0x1A2B3C4D5E6F7A8B"
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				mock.EXPECT().VerifySignature(gomock.Any(), "main", "randomsha", gomock.Any()).Return(&git.Signature{
+					KeyID:        "1A2B3C4D5E6F7A8B",
+					PrimaryKeyID: "1A2B3C4D5E6F7A8B",
+					Signer:       "Jane Doe <jane@example.com>",
+				}, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{}, nil)
+				mock.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{}, nil)
+				mock.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+			expectedStatus:   v1alpha1.HealthStatusCode(v1alpha1.HealthStatusHealthy),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:         v1alpha1.SyncStatusSynced,
+				Revision:       "randomsha",
+				RevisionInfo:   newRevisionInfo(testCommitInfo),
+				SignatureKeyID: "1A2B3C4D5E6F7A8B",
+				Diff:           &v1alpha1.DiffSummary{},
+			},
+			expectedPhase:   v1alpha1.OperationSucceeded,
+			expectedHistory: []string{"randomsha"},
+		},
+		{
+			name: "Should block the sync if the revision is not signed by an allowed key",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-signed-application
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  signatureKeys:
+    - "0011223344556677"
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				mock.EXPECT().VerifySignature(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&git.Signature{
+					KeyID:        "1A2B3C4D5E6F7A8B",
+					PrimaryKeyID: "1A2B3C4D5E6F7A8B",
+					Signer:       "Jane Doe <jane@example.com>",
+				}, nil)
+				return mock
+			}(),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:   v1alpha1.SyncStatusUnknown,
+				Revision: "randomsha",
+			},
+			expectedCond: v1alpha1.ApplicationConditionSignatureError,
+			expectedErr:  "error verifying signature of revision randomsha: signed with key 1A2B3C4D5E6F7A8B of Jane Doe <jane@example.com> which is not allowed",
+		},
+		{
+			name: "Should block the sync if the revision is not signed",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-signed-application
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  signatureKeys:
+    - 1A2B3C4D5E6F7A8B
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				mock.EXPECT().VerifySignature(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("commit randomsha is %w", git.ErrUnsigned))
+				return mock
+			}(),
+			expectedSync: v1alpha1.SyncStatus{
+				Status:   v1alpha1.SyncStatusUnknown,
+				Revision: "randomsha",
+			},
+			expectedCond: v1alpha1.ApplicationConditionSignatureError,
+			expectedErr:  "error verifying signature of revision randomsha: commit randomsha is not signed",
+		},
+		{
+			name: "Should return error if the application has invalid repository",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: example-application
+spec:
+  repository: https://github.com/kubernetes/kubernetes-but-not-exist.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated: {}
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+					fmt.Errorf("failed to clone repository: authentication required"),
+				)
+				return mock
+			}(),
+			expectedSync: v1alpha1.SyncStatus{
+				Status: v1alpha1.SyncStatusUnknown,
+			},
+			expectedCond: v1alpha1.ApplicationConditionFetchError,
+			expectedErr:  "error cloning repository: failed to clone repository: authentication required",
+		},
+	}
+
+	for _, tt := range createResourcesTestCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			app := newFakeApp(tt.app)
+			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, tt.mockKustomize, tt.mockHelm, tt.mockClusterCache, app)
+
+			err := controller.createResources(ctx, app)
+			if err != nil {
+				assert.Equal(t, tt.expectedErr, err.Error())
+			}
+
+			// Check the status of the application
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, queryApp.Status.HealthStatus)
+			assert.Equal(t, tt.expectedSync, queryApp.Status.Sync)
+			assert.Equal(t, tt.expectedPruned, queryApp.Status.PrunedResources)
+			assert.Nil(t, queryApp.Operation)
+			if tt.expectedPhase != "" {
+				assert.Equal(t, tt.expectedPhase, queryApp.Status.OperationState.Phase)
+				assert.Equal(t, tt.expectedResults, queryApp.Status.OperationState.Resources)
+				assert.NotNil(t, queryApp.Status.OperationState.FinishedAt)
+			} else {
+				assert.Nil(t, queryApp.Status.OperationState)
+			}
+			var history []string
+			for _, h := range queryApp.Status.History {
+				history = append(history, h.Revision)
+			}
+			assert.Equal(t, tt.expectedHistory, history)
+			if tt.expectedAutomatedDisabled {
+				assert.Nil(t, queryApp.Spec.SyncPolicy.Automated)
+			}
+			if tt.expectedMessage != "" {
+				assert.Equal(t, tt.expectedMessage, queryApp.Status.Message)
+			}
+			if tt.expectedResources != nil {
+				assert.Equal(t, tt.expectedResources, queryApp.Status.Resources)
+			} else {
+				assert.Empty(t, queryApp.Status.Resources)
+			}
+			if tt.expectedCond != "" {
+				assert.Len(t, queryApp.Status.Conditions, 1)
+				assert.True(t, meta.IsStatusConditionTrue(queryApp.Status.Conditions, tt.expectedCond))
+			} else {
+				assert.Empty(t, queryApp.Status.Conditions)
+			}
+
+			// Check the last sync time
+			assert.NotNil(t, queryApp.Status.LastSyncAt)
+		})
+	}
+}
+
+func newFakeDeployment(namespace, name string) *unstructured.Unstructured {
+	deployment := &unstructured.Unstructured{}
+	deployment.SetAPIVersion("apps/v1")
+	deployment.SetKind("Deployment")
+	deployment.SetNamespace(namespace)
+	deployment.SetName(name)
+	return deployment
+}
+
+func newFakeConfigMap(namespace, name string) *unstructured.Unstructured {
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	configMap.SetNamespace(namespace)
+	configMap.SetName(name)
+	return configMap
+}
+
+func newFakeService(namespace, name string) *unstructured.Unstructured {
+	service := &unstructured.Unstructured{}
+	service.SetAPIVersion("v1")
+	service.SetKind("Service")
+	service.SetNamespace(namespace)
+	service.SetName(name)
+	return service
+}
+
+func Test_DeleteResources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	foreground := metav1.DeletePropagationForeground
+	background := metav1.DeletePropagationBackground
+
+	testCases := []struct {
+		name              string
+		app               string
+		mockGitClient     git.GitClient
+		mockk8sUtil       k8sUtil.K8s
+		mockClusterCache  clustercache.ClusterCache
+		expectedRemaining int
+		expectedResources []v1alpha1.ResourceStatus
+		expectedErr       string
+	}{
+		{
+			name: "Should delete resources successfully if the application is valid",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-one
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+		},
+		{
+			name: "Should delete resources successfully even if the application has invalid repository",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-two
+spec:
+  repository: https://github.com/kubernetes/kubernetes-but-not-exist.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl),
+		},
+		{
+			name: "Should wait for the resources to be gone with the Foreground deletion policy",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-three
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  deletionPolicy: Foreground
+`,
+			mockGitClient: gitMock.NewMockGitClient(ctrl),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("default", "nginx")
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), deployment, "default", metav1.DeleteOptions{
+					PropagationPolicy: &foreground,
+				}).Return(nil)
+				return mock
+			}(),
+			mockClusterCache:  newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedRemaining: 1,
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusOrphaned,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+				},
+			},
+		},
+		{
+			name: "Should record the error of the resources that failed to be deleted",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-six
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  deletionPolicy: Background
+status:
+  resources:
+    - group: apps
+      version: v1
+      kind: Deployment
+      namespace: default
+      name: nginx
+      status: Synced
+      health: Healthy
+`,
+			mockGitClient: gitMock.NewMockGitClient(ctrl),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("default", "nginx")
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), deployment, "default", gomock.Any()).Return(fmt.Errorf("forbidden"))
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef:   v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:        v1alpha1.SyncStatusSynced,
+					Health:        v1alpha1.HealthStatusProgressing,
+					HealthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated",
+					Message:       "forbidden",
+				},
+			},
+			expectedErr: "error deleting resources: forbidden",
+		},
+		{
+			name: "Should not wait for the resources with the Background deletion policy",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-four
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  deletionPolicy: Background
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				deployment := newFakeDeployment("default", "nginx")
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), deployment, "default", metav1.DeleteOptions{
+					PropagationPolicy: &background,
+				}).Return(nil)
+				return mock
+			}(),
+			mockClusterCache: newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+		},
+		{
+			name: "Should leave the resources in place with the Orphan deletion policy",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-another-example-application-five
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  deletionPolicy: Orphan
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockk8sUtil: k8sUtilMock.NewMockK8s(ctrl),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			app := newFakeApp(tt.app)
+			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, nil, nil, tt.mockClusterCache, app)
+
+			// Delete resources
+			remaining, err := controller.deleteResources(ctx, app)
+			if err != nil {
+				assert.Equal(t, tt.expectedErr, err.Error())
+			} else {
+				assert.Equal(t, tt.expectedRemaining, remaining)
+			}
+
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResources, queryApp.Status.Resources)
+		})
+	}
+}
+
+func Test_ProcessNextItem(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	testCases := []struct {
+		name               string
+		app                string
+		mockGitClient      git.GitClient
+		mockk8sUtil        k8sUtil.K8s
+		mockClusterCache   clustercache.ClusterCache
+		expectedFinalizers []string
+		expectedRefresh    bool
+	}{
+		{
+			name: "Should add the finalizer and request a refresh",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-finalizer-application
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient:      gitMock.NewMockGitClient(ctrl),
+			mockk8sUtil:        k8sUtilMock.NewMockK8s(ctrl),
+			expectedFinalizers: []string{common.FinalizerResources},
+			expectedRefresh:    true,
+		},
+		{
+			name: "Should remove the finalizer once the resources are deleted",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-finalizer-application
+  namespace: default
+  deletionTimestamp: "2024-06-01T00:00:00Z"
+  finalizers:
+    - thongdepzai.cloud/resources-finalizer
+    - example.com/another-finalizer
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  deletionPolicy: Background
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CleanUp(gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), "default", gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockClusterCache:   newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedFinalizers: []string{"example.com/another-finalizer"},
+		},
+		{
+			name: "Should keep the finalizer while the resources are being deleted",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-finalizer-application
+  namespace: default
+  deletionTimestamp: "2024-06-01T00:00:00Z"
+  finalizers:
+    - thongdepzai.cloud/resources-finalizer
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient: gitMock.NewMockGitClient(ctrl),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().DeleteResource(gomock.Any(), gomock.Any(), "default", gomock.Any()).Return(nil)
+				return mock
+			}(),
+			mockClusterCache:   newMockClusterCache(ctrl, newFakeDeployment("default", "nginx")),
+			expectedFinalizers: []string{common.FinalizerResources},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			app := newFakeApp(tt.app)
+			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, nil, nil, tt.mockClusterCache, app)
+			controller.handleAdd(app)
+
+			assert.True(t, controller.processNextItem())
+
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedFinalizers, queryApp.GetFinalizers())
+			assert.Equal(t, tt.expectedRefresh, controller.appRefreshQueue.NumRequeues(app.Namespace+"/"+app.Name) > 0)
+		})
+	}
+}
+
+func Test_HandleDelete(t *testing.T) {
+	app := newFakeApp(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-deleted-application
+  namespace: default
+`)
+	controller := newFakeController(nil, nil, nil, nil, nil)
+
+	// Tombstones must not cause a panic
+	controller.handleDelete(cache.DeletedFinalStateUnknown{
+		Key: "default/test-deleted-application",
+		Obj: app,
+	})
+
+	key, _ := controller.queue.Get()
+	assert.Equal(t, "default/test-deleted-application", key)
+}
+
+func Test_ProcessNextAppRefreshItem(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	testCases := []struct {
+		name          string
+		app           string
+		mockGitClient git.GitClient
+		mockk8sUtil   k8sUtil.K8s
+		expectedSync  v1alpha1.SyncStatusCode
+		expectedCond  string
+		expectedMsg   string
+	}{
+		{
+			name: "Should set the RenderError condition if the manifests are broken",
+			app: `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-broken-application
+  namespace: default
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+`,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+				mock.EXPECT().Checkout(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+				mock.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+				return mock
+			}(),
+			mockk8sUtil: func() k8sUtil.K8s {
+				mock := k8sUtilMock.NewMockK8s(ctrl)
+				mock.EXPECT().GenerateManifests(gomock.Any()).Return(
+					nil,
+					fmt.Errorf("error parsing deployment.yaml: document 1: Object 'Kind' is missing"),
+				)
+				return mock
+			}(),
+			expectedSync: v1alpha1.SyncStatusCode(v1alpha1.SyncStatusUnknown),
+			expectedCond: v1alpha1.ApplicationConditionRenderError,
+			expectedMsg:  "error generating manifests: error parsing deployment.yaml: document 1: Object 'Kind' is missing",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			app := newFakeApp(tt.app)
+			controller := newFakeController(tt.mockGitClient, tt.mockk8sUtil, nil, nil, nil, app)
+			controller.requestAppRefresh(app.GetName(), app.GetNamespace())
+
+			assert.True(t, controller.processNextAppRefreshItem())
+
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSync, queryApp.Status.Sync.Status)
+			assert.Equal(t, tt.expectedMsg, queryApp.Status.Message)
+
+			condition := meta.FindStatusCondition(queryApp.Status.Conditions, tt.expectedCond)
+			assert.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			assert.Equal(t, "RenderFailed", condition.Reason)
+			assert.Equal(t, tt.expectedMsg, condition.Message)
+		})
+	}
+}
+
+func Test_ProcessNextAppRefreshItem_Poll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	app := func(generation int) string {
+		return fmt.Sprintf(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-polled-application
+  namespace: default
+  generation: %d
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+status:
+  observedGeneration: 1
+  sync:
+    status: Synced
+    revision: randomsha
+`, generation)
+	}
+	pinned := func(revision string) string {
+		return fmt.Sprintf(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-polled-application
+  namespace: default
+  generation: 1
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: %s
+  path: k8s-controller-pattern/gitops
+status:
+  observedGeneration: 1
+  sync:
+    status: Synced
+    revision: 3f4c2a1b9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b
+`, revision)
+	}
+	// The comparison fails rendering the manifests, after the revision is checked out
+	compared := func(mock *gitMock.MockGitClient, sha string) {
+		mock.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+		mock.EXPECT().Checkout(gomock.Any(), "main", gomock.Any(), gomock.Any()).Return("randompath", sha, nil)
+		mock.EXPECT().CommitInfo(gomock.Any(), sha).Return(testCommitInfo, nil)
+	}
+
+	testCases := []struct {
+		name             string
+		app              string
+		requested        bool
+		mockGitClient    func() git.GitClient
+		expectedCompared bool
+		expectedRevision string
+	}{
+		{
+			name: "Should skip the comparison if the revision didn't move",
+			app:  app(1),
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().LsRemote("https://github.com/minhthong582000/k8s-controller-pattern.git", "main", gomock.Any()).Return("randomsha", nil)
+				return mock
+			},
+			expectedRevision: "randomsha",
+		},
+		{
+			name: "Should skip the comparison of a short SHA without listing the remote references",
+			app:  pinned("3f4c2a1"),
+			mockGitClient: func() git.GitClient {
+				return gitMock.NewMockGitClient(ctrl)
+			},
+			expectedRevision: "3f4c2a1b9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b",
+		},
+		{
+			name: "Should skip the comparison of a full SHA without listing the remote references",
+			app:  pinned("3f4c2a1b9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b"),
+			mockGitClient: func() git.GitClient {
+				return gitMock.NewMockGitClient(ctrl)
+			},
+			expectedRevision: "3f4c2a1b9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b",
+		},
+		{
+			name: "Should compare if the revision moved",
+			app:  app(1),
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().LsRemote(gomock.Any(), "main", gomock.Any()).Return("newsha", nil)
+				compared(mock, "newsha")
+				return mock
+			},
+			expectedCompared: true,
+			expectedRevision: "newsha",
+		},
+		{
+			name: "Should compare if the remote references can't be listed",
+			app:  app(1),
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				mock.EXPECT().LsRemote(gomock.Any(), "main", gomock.Any()).Return("", fmt.Errorf("short SHAs can't be resolved remotely"))
+				compared(mock, "randomsha")
+				return mock
+			},
+			expectedCompared: true,
+			expectedRevision: "randomsha",
+		},
+		{
+			name: "Should compare if the spec changed since the last comparison",
+			app:  app(2),
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				compared(mock, "randomsha")
+				return mock
+			},
+			expectedCompared: true,
+			expectedRevision: "randomsha",
+		},
+		{
+			name:      "Should compare if a refresh is requested",
+			app:       app(1),
+			requested: true,
+			mockGitClient: func() git.GitClient {
+				mock := gitMock.NewMockGitClient(ctrl)
+				compared(mock, "randomsha")
+				return mock
+			},
+			expectedCompared: true,
+			expectedRevision: "randomsha",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			app := newFakeApp(tt.app)
+			mockk8sUtil := k8sUtilMock.NewMockK8s(ctrl)
+			if tt.expectedCompared {
+				mockk8sUtil.EXPECT().GenerateManifests(gomock.Any()).Return(nil, fmt.Errorf("error parsing deployment.yaml"))
+			}
+			controller := newFakeController(tt.mockGitClient(), mockk8sUtil, nil, nil, nil, app)
+			if tt.requested {
+				controller.requestAppRefresh(app.GetName(), app.GetNamespace())
+			} else {
+				controller.appRefreshQueue.Add(cache.NewObjectName(app.Namespace, app.Name).String())
+			}
+
+			assert.True(t, controller.processNextAppRefreshItem())
+
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedRevision, queryApp.Status.Sync.Revision)
+			assert.Equal(t, tt.expectedCompared, meta.IsStatusConditionTrue(queryApp.Status.Conditions, v1alpha1.ApplicationConditionRenderError))
+		})
+	}
+}
+
+func Test_ProcessNextAppRefreshItem_RefreshHealth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	app := `
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-rolling-application
+  namespace: default
+  generation: 1
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+status:
+  observedGeneration: 1
+  healthStatus: Progressing
+  sync:
+    status: Synced
+    revision: randomsha
+  resources:
+  - group: apps
+    version: v1
+    kind: Deployment
+    namespace: default
+    name: nginx
+    status: Synced
+    health: Progressing
+    healthMessage: "Waiting for rollout to finish: 0 out of 1 new replicas have been updated"
+`
+	rolledOut := newFakeDeployment("default", "nginx")
+	rolledOut.Object["spec"] = map[string]interface{}{"replicas": int64(1)}
+	rolledOut.Object["status"] = map[string]interface{}{
+		"replicas":          int64(1),
+		"updatedReplicas":   int64(1),
+		"availableReplicas": int64(1),
+	}
+
+	testCases := []struct {
+		name              string
+		liveResources     []*unstructured.Unstructured
+		expectedHealth    v1alpha1.HealthStatusCode
+		expectedResources []v1alpha1.ResourceStatus
+	}{
+		{
+			name:           "Should reassess the health of the resources once they rolled out",
+			liveResources:  []*unstructured.Unstructured{rolledOut},
+			expectedHealth: v1alpha1.HealthStatusHealthy,
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusHealthy,
+				},
+			},
+		},
+		{
+			name:           "Should mark the resources gone from the cluster as missing",
+			expectedHealth: v1alpha1.HealthStatusProgressing,
+			expectedResources: []v1alpha1.ResourceStatus{
+				{
+					ResourceRef: v1alpha1.ResourceRef{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "nginx"},
+					Status:      v1alpha1.SyncStatusSynced,
+					Health:      v1alpha1.HealthStatusMissing,
+				},
+			},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			app := newFakeApp(app)
+			// The revision didn't move, nothing is compared
+			mockGitClient := gitMock.NewMockGitClient(ctrl)
+			mockGitClient.EXPECT().LsRemote(gomock.Any(), "main", gomock.Any()).Return("randomsha", nil)
+			controller := newFakeController(mockGitClient, nil, nil, nil, newMockClusterCache(ctrl, tt.liveResources...), app)
+			controller.appRefreshQueue.Add(cache.NewObjectName(app.Namespace, app.Name).String())
+
+			assert.True(t, controller.processNextAppRefreshItem())
+
+			queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedHealth, queryApp.Status.HealthStatus)
+			assert.Equal(t, tt.expectedResources, queryApp.Status.Resources)
+			assert.Equal(t, v1alpha1.SyncStatusCode(v1alpha1.SyncStatusSynced), queryApp.Status.Sync.Status)
+		})
+	}
+}
+
+func Test_NextPoll(t *testing.T) {
+	controller := newFakeController(nil, nil, nil, nil, nil)
+
+	app := &v1alpha1.Application{}
+	poll := controller.nextPoll(app)
+	assert.GreaterOrEqual(t, poll, 3*time.Minute)
+	assert.Less(t, poll, 3*time.Minute+18*time.Second)
+
+	app.Spec.PollInterval = &metav1.Duration{Duration: 30 * time.Second}
+	poll = controller.nextPoll(app)
+	assert.GreaterOrEqual(t, poll, 30*time.Second)
+	assert.Less(t, poll, 33*time.Second)
+}
+
+func Test_HandleUpdate(t *testing.T) {
+	app := newFakeApp(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-updated-application
+  namespace: default
+  resourceVersion: "1"
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+`)
+	statusChanged := app.DeepCopy()
+	statusChanged.ResourceVersion = "2"
+	statusChanged.Status.Sync.Revision = "randomsha"
+	specChanged := app.DeepCopy()
+	specChanged.ResourceVersion = "2"
+	specChanged.Spec.Revision = "v1.0.0"
+
+	testCases := []struct {
+		name              string
+		new               *v1alpha1.Application
+		expectedRequested bool
+	}{
+		{
+			name: "Should leave a resync to the polls",
+			new:  app,
+		},
+		{
+			name: "Should leave a change of the status to the polls",
+			new:  statusChanged,
+		},
+		{
+			name:              "Should request a refresh when the spec changes",
+			new:               specChanged,
+			expectedRequested: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newFakeController(nil, nil, nil, nil, nil)
+
+			// Returns without waiting
+			controller.handleUdate(app, tt.new)
+
+			assert.Equal(t, tt.expectedRequested, controller.isRefreshRequested("default/test-updated-application"))
+		})
+	}
+}
+
+func Test_HandleWebhook(t *testing.T) {
+	newApp := func(name, repository, revision string) *v1alpha1.Application {
+		return &v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1alpha1.ApplicationSpec{
+				ApplicationSource: v1alpha1.ApplicationSource{Repository: repository, Revision: revision},
+			},
+		}
+	}
+	payload, err := os.ReadFile("../../utils/webhook/testdata/github-push.json")
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("github-secret"))
+	mac.Write(payload)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	testCases := []struct {
+		name              string
+		method            string
+		signature         string
+		expectedStatus    int
+		expectedRefreshed []string
+	}{
+		{
+			name:              "Should refresh the applications of the pushed branch",
+			method:            http.MethodPost,
+			signature:         signature,
+			expectedStatus:    http.StatusOK,
+			expectedRefreshed: []string{"default/main", "default/main-ssh", "default/head"},
+		},
+		{
+			name:           "Should reject a payload with an invalid signature",
+			method:         http.MethodPost,
+			signature:      "sha256=invalid",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Should only accept POST",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newFakeController(nil, nil, nil, nil, nil)
+			controller.loadWebhookSecrets(&corev1.Secret{Data: map[string][]byte{webhook.SecretKeyGitHub: []byte("github-secret")}})
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, app := range []*v1alpha1.Application{
+				newApp("main", "https://github.com/org/repo.git", "main"),
+				newApp("main-ssh", "git@github.com:org/repo.git", "main"),
+				newApp("head", "https://github.com/org/repo", ""),
+				newApp("develop", "https://github.com/org/repo.git", "develop"),
+				newApp("other", "https://github.com/org/other.git", "main"),
+			} {
+				assert.NoError(t, indexer.Add(app))
+			}
+			controller.appLister = applisters.NewApplicationLister(indexer)
+
+			server := httptest.NewServer(http.HandlerFunc(controller.HandleWebhook))
+			defer server.Close()
+			req, err := http.NewRequest(tt.method, server.URL, bytes.NewReader(payload))
+			assert.NoError(t, err)
+			req.Header.Set("X-GitHub-Event", "push")
+			req.Header.Set("X-Hub-Signature-256", tt.signature)
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			var refreshed []string
+			for controller.appRefreshQueue.Len() > 0 {
+				key, _ := controller.appRefreshQueue.Get()
+				refreshed = append(refreshed, key.(string))
+				controller.appRefreshQueue.Done(key)
+			}
+			assert.ElementsMatch(t, tt.expectedRefreshed, refreshed)
+			// The refreshes are polls
+			for _, key := range refreshed {
+				assert.False(t, controller.isRefreshRequested(key))
+			}
+		})
+	}
+}
+
+func Test_AggregateHealth(t *testing.T) {
+	testCases := []struct {
+		name           string
+		health         []v1alpha1.HealthStatusCode
+		expectedHealth v1alpha1.HealthStatusCode
+	}{
+		{
+			name:           "Should be healthy without resources",
+			expectedHealth: v1alpha1.HealthStatusHealthy,
+		},
+		{
+			name:           "Should be progressing until every resource is ready",
+			health:         []v1alpha1.HealthStatusCode{v1alpha1.HealthStatusDegraded, v1alpha1.HealthStatusProgressing, v1alpha1.HealthStatusHealthy},
+			expectedHealth: v1alpha1.HealthStatusProgressing,
+		},
+		{
+			name:           "Should be progressing while a resource is missing",
+			health:         []v1alpha1.HealthStatusCode{v1alpha1.HealthStatusMissing, v1alpha1.HealthStatusHealthy},
+			expectedHealth: v1alpha1.HealthStatusProgressing,
+		},
+		{
+			name:           "Should be degraded if a resource is degraded",
+			health:         []v1alpha1.HealthStatusCode{v1alpha1.HealthStatusHealthy, v1alpha1.HealthStatusDegraded},
+			expectedHealth: v1alpha1.HealthStatusDegraded,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var resources []v1alpha1.ResourceStatus
+			for _, h := range tt.health {
+				resources = append(resources, v1alpha1.ResourceStatus{
+					Status: v1alpha1.SyncStatusSynced,
+					Health: h,
+				})
+			}
+			assert.Equal(t, tt.expectedHealth, aggregateHealth(resources))
+		})
+	}
+}
+
+func Test_AddHistory(t *testing.T) {
+	source := &v1alpha1.ApplicationSource{Repository: "https://github.com/minhthong582000/k8s-controller-pattern.git", Revision: "main"}
+
+	var history []v1alpha1.RevisionHistory
+	for i := 0; i < maxHistory+2; i++ {
+		history = addHistory(history, fmt.Sprintf("sha%d", i), metav1.Now(), source)
+	}
+
+	assert.Len(t, history, maxHistory)
+	assert.Equal(t, int64(2), history[0].ID)
+	assert.Equal(t, "sha2", history[0].Revision)
+	assert.Equal(t, int64(maxHistory+1), history[maxHistory-1].ID)
+	assert.Equal(t, *source, history[maxHistory-1].Source)
+}
+
+func Test_EventDiff(t *testing.T) {
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"}}
+	newConfigMap := func(value string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "nginx", "namespace": "default"},
+			"data":       map[string]interface{}{"key": value},
+		}}
+	}
+
+	testCases := []struct {
+		name              string
+		value             string
+		expectedTruncated bool
+	}{
+		{
+			name:  "Should include the whole diff if it is short",
+			value: "value",
+		},
+		{
+			name:              "Should truncate the diff at the end of a line if it is too long",
+			value:             strings.Repeat("a", 2*maxEventDiffLength),
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := []k8sUtil.ResourceDiff{
+				{Desired: newConfigMap(tt.value)},
+				{Live: newConfigMap("old"), Desired: newConfigMap("new"), Modified: true},
+			}
+
+			message := eventDiff(app, diffs)
+			assert.True(t, strings.HasPrefix(message, "1 added, 1 modified, 0 removed\n"))
+			assert.Equal(t, tt.expectedTruncated, strings.Contains(message, "gitops diff nginx -n default"))
+			assert.Equal(t, !tt.expectedTruncated, strings.Contains(message, "+  key: new"))
+			assert.LessOrEqual(t, len(message), 2*maxEventDiffLength)
+		})
+	}
+}
+
+func Test_LoadHealthChecks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	checks := map[string]string{
+		"cert-manager.io_Certificate": `{"status": "Healthy", "message": ""}`,
+	}
+	mockHealthChecker := k8sUtilMock.NewMockHealthChecker(ctrl)
+	gomock.InOrder(
+		mockHealthChecker.EXPECT().SetCustomHealthChecks(checks).Return(nil),
+		mockHealthChecker.EXPECT().SetCustomHealthChecks(nil).Return(nil),
+	)
+
+	controller := newFakeController(nil, nil, nil, nil, nil)
+	controller.healthChecker = mockHealthChecker
+
+	// Loaded when the ConfigMap is created or updated, removed when it is deleted
+	controller.loadHealthChecks(&corev1.ConfigMap{Data: checks})
+	controller.loadHealthChecks(nil)
+}
+
+func Test_LoadHealthChecks_Informer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ctx := context.Background()
+
+	checks := map[string]string{
+		"cert-manager.io_Certificate": `{"status": "Healthy", "message": ""}`,
+	}
+	updatedChecks := map[string]string{
+		"cert-manager.io_Certificate": `{"status": "Progressing", "message": ""}`,
+	}
+	loaded := make(chan map[string]string, 3)
+	mockHealthChecker := k8sUtilMock.NewMockHealthChecker(ctrl)
+	mockHealthChecker.EXPECT().SetCustomHealthChecks(gomock.Any()).DoAndReturn(func(checks map[string]string) error {
+		loaded <- checks
+		return nil
+	}).Times(3)
+
+	kubeClientSet := fake.NewSimpleClientset()
+	appClientSet := appclientset.NewSimpleClientset()
+	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClientSet, 0)
+	healthChecksInformerFactory := informers.NewSharedInformerFactory(kubeClientSet, 0)
+	NewController(
+		kubeClientSet,
+		appClientSet,
+		appinformers.NewSharedInformerFactory(appClientSet, 0).Thongdepzai().V1alpha1().Applications(),
+		nil,
+		nil,
+		nil,
+		nil,
+		mockHealthChecker,
+		healthChecksInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().Secrets(),
+		webhook.NewWebhook(),
+		kubeInformerFactory.Core().V1().Secrets(),
+		newMockClusterCache(ctrl),
+		3*time.Minute,
+	)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	healthChecksInformerFactory.Start(stopCh)
+	nextLoaded := func() map[string]string {
+		select {
+		case checks := <-loaded:
+			return checks
+		case <-time.After(5 * time.Second):
+			t.Fatal("the health checks were not reloaded")
+			return nil
+		}
+	}
+
+	// The checks are loaded when the ConfigMap is created or updated, removed when it is deleted
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gitops-health-checks", Namespace: "default"},
+		Data:       checks,
+	}
+	_, err := kubeClientSet.CoreV1().ConfigMaps("default").Create(ctx, configMap, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, checks, nextLoaded())
+
+	configMap.Data = updatedChecks
+	_, err = kubeClientSet.CoreV1().ConfigMaps("default").Update(ctx, configMap, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, updatedChecks, nextLoaded())
+
+	err = kubeClientSet.CoreV1().ConfigMaps("default").Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, nextLoaded())
+}
+
+func Test_GetCredentials(t *testing.T) {
+	repository := "https://github.com/org/repo.git"
+	labelled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "org-creds",
+			Namespace: "default",
+			Labels:    map[string]string{common.LabelKeySecretType: common.SecretTypeRepoCreds},
+		},
+		Data: map[string][]byte{
+			git.SecretKeyURL:      []byte("https://github.com/org"),
+			git.SecretKeyUsername: []byte("org"),
+		},
+	}
+	explicit := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-creds", Namespace: "default"},
+		Data:       map[string][]byte{git.SecretKeyUsername: []byte("app")},
+	}
+
+	testCases := []struct {
+		name             string
+		credentialsName  string
+		repository       string
+		expectedUsername string
+		expectedNil      bool
+		expectedErr      bool
+	}{
+		{
+			name:             "Should use the Secret of the application",
+			credentialsName:  "app-creds",
+			repository:       repository,
+			expectedUsername: "app",
+		},
+		{
+			name:            "Should return error if the Secret of the application doesn't exist",
+			credentialsName: "unexisted-creds",
+			repository:      repository,
+			expectedErr:     true,
+		},
+		{
+			name:             "Should use the labelled Secret matching the repository",
+			repository:       repository,
+			expectedUsername: "org",
+		},
+		{
+			name:        "Should return nil for public repositories",
+			repository:  "https://github.com/public/repo.git",
+			expectedNil: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newFakeController(nil, nil, nil, nil, nil)
+			_, err := controller.clientSet.CoreV1().Secrets("default").Create(context.Background(), explicit, metav1.CreateOptions{})
+			assert.NoError(t, err)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			assert.NoError(t, indexer.Add(labelled))
+			controller.credentialsLister = corelisters.NewSecretLister(indexer)
+
+			app := &v1alpha1.Application{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-app", Namespace: "default"},
+				Spec:       v1alpha1.ApplicationSpec{CredentialsSecret: tt.credentialsName},
+			}
+			creds, err := controller.getCredentials(context.Background(), app, tt.repository)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.expectedNil {
+				assert.Nil(t, creds)
+				return
+			}
+			assert.Equal(t, tt.expectedUsername, creds.Username)
+		})
+	}
+}
+
+func Test_HandleResourceEvent(t *testing.T) {
+	app := newFakeApp(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-drift-application
+  namespace: default
+`)
+	otherApp := newFakeApp(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-other-application
+  namespace: default
+`)
+	controller := newFakeController(nil, nil, nil, nil, nil, app, otherApp)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(app))
+	assert.NoError(t, indexer.Add(otherApp))
+	controller.appLister = applisters.NewApplicationLister(indexer)
+
+	configMap := newFakeConfigMap("default", "nginx")
+
+	// Resources without application are ignored
+	controller.handleResourceEvent("default", "", configMap)
+	controller.handleResourceEvent("", "", configMap)
+	assert.Empty(t, controller.refreshRequested)
+
+	// The applications of the same name in other namespaces are not refreshed
+	controller.handleResourceEvent("production", app.Name, configMap)
+	assert.Empty(t, controller.refreshRequested)
+
+	// Only the owning application is refreshed, even if its revision didn't move
+	controller.handleResourceEvent(app.Namespace, app.Name, configMap)
+	key, _ := controller.appRefreshQueue.Get()
+	assert.Equal(t, "default/test-drift-application", key)
+	assert.True(t, controller.isRefreshRequested("default/test-drift-application"))
+	assert.False(t, controller.isRefreshRequested("default/test-other-application"))
+	controller.appRefreshQueue.Done(key)
+
+	// The resources labelled without the namespace refresh the applications of their name
+	controller.handleResourceEvent("", app.Name, configMap)
+	key, _ = controller.appRefreshQueue.Get()
+	assert.Equal(t, "default/test-drift-application", key)
+	assert.True(t, controller.isRefreshRequested("default/test-drift-application"))
+	assert.False(t, controller.isRefreshRequested("default/test-other-application"))
+}
+
+func Test_ProcessNextAppRefreshItem_ResourceEvent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ctx := context.Background()
+
+	app := newFakeApp(`
+kind: Application
+apiVersion: thongdepzai.cloud/v1alpha1
+metadata:
+  name: test-drift-application
+  namespace: default
+  generation: 1
+spec:
+  repository: https://github.com/minhthong582000/k8s-controller-pattern.git
+  revision: main
+  path: k8s-controller-pattern/gitops
+  syncPolicy:
+    automated:
+      selfHeal: true
+status:
+  observedGeneration: 1
+  revision: randomsha
+  sync:
+    status: Synced
+    revision: randomsha
+`)
+	// The revision didn't move, the remote references are not even listed
+	mockGitClient := gitMock.NewMockGitClient(ctrl)
+	mockGitClient.EXPECT().CloneOrFetch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockGitClient.EXPECT().Checkout(gomock.Any(), "main", gomock.Any(), gomock.Any()).Return("randompath", "randomsha", nil)
+	mockGitClient.EXPECT().CommitInfo(gomock.Any(), "randomsha").Return(testCommitInfo, nil)
+
+	liveConfigMap := newFakeConfigMap("default", "nginx")
+	configMap := newFakeConfigMap("default", "nginx")
+	configMap.Object["data"] = map[string]interface{}{"key": "value"}
+	mockk8sUtil := k8sUtilMock.NewMockK8s(ctrl)
+	mockk8sUtil.EXPECT().GenerateManifests(gomock.Any()).Return([]*unstructured.Unstructured{configMap}, nil)
+	mockk8sUtil.EXPECT().SetLabelsForResources(gomock.Any(), gomock.Any()).Return(nil)
+	mockk8sUtil.EXPECT().DiffResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]k8sUtil.ResourceDiff{
+		{Live: liveConfigMap, Desired: configMap, Modified: true, Changes: []k8sUtil.FieldChange{{Path: "data.key", Type: k8sUtil.ChangeModified}}},
+	}, nil)
+	mockk8sUtil.EXPECT().CreateResource(gomock.Any(), configMap, "default").Return(configMap, nil)
+
+	controller := newFakeController(mockGitClient, mockk8sUtil, nil, nil, newMockClusterCache(ctrl, liveConfigMap), app)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(app))
+	controller.appLister = applisters.NewApplicationLister(indexer)
+
+	controller.handleResourceEvent(app.Namespace, app.Name, liveConfigMap)
+	assert.True(t, controller.processNextAppRefreshItem())
+
+	queryApp, err := controller.appClientSet.ThongdepzaiV1alpha1().Applications(app.Namespace).Get(ctx, app.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, v1alpha1.SyncStatusCode(v1alpha1.SyncStatusSynced), queryApp.Status.Sync.Status)
+	assert.Equal(t, v1alpha1.OperationPhase(v1alpha1.OperationSucceeded), queryApp.Status.OperationState.Phase)
+	assert.Equal(t, []v1alpha1.ResourceResult{
+		{ResourceRef: v1alpha1.ResourceRef{Version: "v1", Kind: "ConfigMap", Namespace: "default", Name: "nginx"}, Status: v1alpha1.ResourceResultSynced},
+	}, queryApp.Status.OperationState.Resources)
 }
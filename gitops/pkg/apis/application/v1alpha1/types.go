@@ -7,26 +7,390 @@ import (
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:subresource:status
-// +kubebuilder:printcolumn:name="HealthStatus",type=string,JSONPath=`.status.healthStatus`
+// +kubebuilder:printcolumn:name="Sync",type=string,JSONPath=`.status.sync.status`
+// +kubebuilder:printcolumn:name="Health",type=string,JSONPath=`.status.healthStatus`
+// +kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.sync.revision`
 // +kubebuilder:printcolumn:name="LastSync",type=string,JSONPath=`.status.lastSyncAt`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`
 type Application struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec   ApplicationSpec   `json:"spec,omitempty"`
 	Status ApplicationStatus `json:"status,omitempty"`
+
+	// Operation requests a sync, it is removed by the controller when the sync starts
+	// +optional
+	Operation *Operation `json:"operation,omitempty"`
+}
+
+// Operation is a sync requested by a user, e.g. with
+// kubectl patch application <name> --type merge -p '{"operation": {"sync": {}}}'
+type Operation struct {
+	// +optional
+	Sync *SyncOperation `json:"sync,omitempty"`
+}
+
+// SyncOperation applies the revision of the spec once
+type SyncOperation struct {
+	// Prune deletes the resources that are no longer defined in Git
+	// +optional
+	Prune bool `json:"prune,omitempty"`
+
+	// HistoryID rolls back to the revision and source of an entry of the history.
+	// The automated sync is disabled so the rollback is not reverted
+	// +optional
+	HistoryID *int64 `json:"historyID,omitempty"`
 }
 
 type ApplicationSpec struct {
+	ApplicationSource `json:",inline"`
+
+	// CredentialsSecret is the name of a Secret in the namespace of the application holding
+	// the credentials of the repository. Defaults to the labelled repository credentials
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+
+	// PollInterval is how often the repository is checked for new commits, e.g. 1m.
+	// Defaults to the poll interval of the controller
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+
+	// Clone limits the history and the references fetched from the repository and the
+	// files checked out of it, e.g. for large monorepos
+	// +optional
+	Clone *CloneOptions `json:"clone,omitempty"`
+
+	// SignatureKeys are the IDs or fingerprints of the GPG keys allowed to sign the revision.
+	// When set, the commit or the annotated tag of the revision must be signed by one of
+	// them, with a key of the keyring of the controller
+	// +optional
+	SignatureKeys []string `json:"signatureKeys,omitempty"`
+
+	// +optional
+	SyncPolicy *SyncPolicy `json:"syncPolicy,omitempty"`
+
+	// DeletionPolicy is how the resources are deleted with the application.
+	// Foreground waits for the resources and their dependents to be deleted,
+	// Background deletes them asynchronously and Orphan leaves them in place.
+	// Defaults to Foreground
+	// +optional
+	// +kubebuilder:validation:Enum=Foreground;Background;Orphan
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// IgnoreDifferences are the fields owned by others, e.g. the replicas scaled by
+	// an HPA. They are not compared and not applied
+	// +optional
+	IgnoreDifferences []ResourceIgnoreDifferences `json:"ignoreDifferences,omitempty"`
+}
+
+// ResourceIgnoreDifferences selects fields of the resources matching the group, kind,
+// name and namespace. Empty name and namespace match all the resources of the kind
+type ResourceIgnoreDifferences struct {
+	// +optional
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// JSONPointers are RFC 6901 pointers, e.g. /spec/replicas
+	// +optional
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+	// JSONPaths are JSONPath expressions of fields, indexes and wildcards,
+	// e.g. .webhooks[*].clientConfig.caBundle
+	// +optional
+	JSONPaths []string `json:"jsonPaths,omitempty"`
+}
+
+// ApplicationSource is where the manifests of an application come from and how they are rendered
+type ApplicationSource struct {
 	Repository string `json:"repository,omitempty"`
-	Revision   string `json:"revision,omitempty"`
-	Path       string `json:"path,omitempty"`
+	// Revision is a commit SHA, a tag, a branch or a semver constraint over the tags,
+	// e.g. v1.2.* or >=1.0.0 <2.0.0. Defaults to HEAD.
+	Revision string `json:"revision,omitempty"`
+	Path     string `json:"path,omitempty"`
+
+	// SourceType is how the manifests are rendered from Path.
+	// Detected automatically when empty: Helm if Path contains a Chart.yaml,
+	// Kustomize if it contains a kustomization file, Directory otherwise
+	// +optional
+	// +kubebuilder:validation:Enum=Directory;Kustomize;Helm
+	SourceType SourceType `json:"sourceType,omitempty"`
+
+	// +optional
+	Kustomize *KustomizeSource `json:"kustomize,omitempty"`
+
+	// +optional
+	Helm *HelmSource `json:"helm,omitempty"`
+}
+
+type DeletionPolicy string
+
+const (
+	DeletionPolicyForeground = "Foreground"
+	DeletionPolicyBackground = "Background"
+	DeletionPolicyOrphan     = "Orphan"
+)
+
+type SourceType string
+
+const (
+	SourceTypeDirectory = "Directory"
+	SourceTypeKustomize = "Kustomize"
+	SourceTypeHelm      = "Helm"
+)
+
+// KustomizeSource holds the overrides applied when building a kustomization
+type KustomizeSource struct {
+	// Images overrides the images, e.g. nginx:1.27 or nginx=my-registry/nginx:1.27
+	// +optional
+	Images []string `json:"images,omitempty"`
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// +optional
+	NameSuffix string `json:"nameSuffix,omitempty"`
+	// CommonLabels are added to all resources and selectors
+	// +optional
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+	// Namespace overrides the namespace of all namespaced resources
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// HelmSource holds the options used to render a Helm chart
+type HelmSource struct {
+	// ReleaseName defaults to the application name
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+	// ValueFiles are values files relative to the chart directory, in the repository
+	// +optional
+	ValueFiles []string `json:"valueFiles,omitempty"`
+	// Values is a YAML document overriding the values from ValueFiles
+	// +optional
+	Values string `json:"values,omitempty"`
+}
+
+// CloneOptions limit what is fetched and checked out of the repository of an application
+type CloneOptions struct {
+	// Depth is the number of commits fetched from the head of each reference. The history
+	// is deepened when an older commit SHA is requested. Defaults to the full history
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Depth int32 `json:"depth,omitempty"`
+
+	// SingleBranch only fetches the branch or the tag of the revision, or the tags for a
+	// semver constraint, instead of all of them
+	// +optional
+	SingleBranch bool `json:"singleBranch,omitempty"`
+
+	// Sparse only checks out Path and ExtraPaths instead of the whole tree
+	// +optional
+	Sparse bool `json:"sparse,omitempty"`
+
+	// ExtraPaths are the files and directories checked out with Path when Sparse is set,
+	// relative to the root of the repository, e.g. the bases of a kustomization
+	// +optional
+	ExtraPaths []string `json:"extraPaths,omitempty"`
+}
+
+// SyncPolicy controls how the controller syncs an application
+type SyncPolicy struct {
+	// Automated syncs the changes of Git automatically. Otherwise the application
+	// is only compared with Git, marked OutOfSync and synced with an Operation
+	// +optional
+	Automated *SyncPolicyAutomated `json:"automated,omitempty"`
+}
+
+// SyncPolicyAutomated controls the automated syncs
+type SyncPolicyAutomated struct {
+	// Prune deletes the resources that are no longer defined in Git
+	// after a successful sync
+	// +optional
+	Prune bool `json:"prune,omitempty"`
+
+	// SelfHeal re-applies the resources changed or deleted in the cluster.
+	// Otherwise the application is only marked OutOfSync
+	// +optional
+	SelfHeal bool `json:"selfHeal,omitempty"`
 }
 
 type ApplicationStatus struct {
 	HealthStatus HealthStatusCode `json:"healthStatus,omitempty"`
-	Revision     string           `json:"revision,omitempty"`
-	LastSyncAt   metav1.Time      `json:"lastSyncAt,omitempty"`
+	Sync         SyncStatus       `json:"sync,omitempty"`
+	// Revision is the commit SHA last synced successfully
+	Revision   string      `json:"revision,omitempty"`
+	LastSyncAt metav1.Time `json:"lastSyncAt,omitempty"`
+
+	// Message is a human readable summary of the last sync
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last processed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the errors that happened during the last sync
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// PrunedResources are the resources deleted during the last sync
+	// +optional
+	PrunedResources []ResourceRef `json:"prunedResources,omitempty"`
+
+	// Resources are the resources managed by the application
+	// +optional
+	Resources []ResourceStatus `json:"resources,omitempty"`
+
+	// OperationState is the state of the last sync
+	// +optional
+	OperationState *OperationState `json:"operationState,omitempty"`
+
+	// History are the last successful syncs, the most recent last
+	// +optional
+	History []RevisionHistory `json:"history,omitempty"`
+}
+
+type OperationPhase string
+
+const (
+	OperationRunning   = "Running"
+	OperationSucceeded = "Succeeded"
+	OperationFailed    = "Failed"
+)
+
+// OperationState is the state of a sync
+type OperationState struct {
+	Phase OperationPhase `json:"phase"`
+	// +optional
+	Message   string      `json:"message,omitempty"`
+	StartedAt metav1.Time `json:"startedAt"`
+	// +optional
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+	// Revision is the commit synced
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Resources are the results of the sync of each resource
+	// +optional
+	Resources []ResourceResult `json:"resources,omitempty"`
+}
+
+type ResourceResultCode string
+
+const (
+	ResourceResultSynced      = "Synced"
+	ResourceResultSyncFailed  = "SyncFailed"
+	ResourceResultPruned      = "Pruned"
+	ResourceResultPruneFailed = "PruneFailed"
+)
+
+// ResourceResult is the result of the sync of a resource
+type ResourceResult struct {
+	ResourceRef `json:",inline"`
+	Status      ResourceResultCode `json:"status"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RevisionHistory is a successful sync
+type RevisionHistory struct {
+	// ID identifies the entry for rollbacks, it increases with every sync
+	ID         int64       `json:"id"`
+	Revision   string      `json:"revision"`
+	DeployedAt metav1.Time `json:"deployedAt"`
+	// Source is the source spec synced
+	Source ApplicationSource `json:"source"`
+}
+
+// SyncStatus is the result of the comparison between Git and the cluster
+type SyncStatus struct {
+	Status SyncStatusCode `json:"status,omitempty"`
+	// Revision is the commit SHA the cluster was compared to
+	Revision string `json:"revision,omitempty"`
+	// RevisionInfo is the metadata of the commit of Revision
+	// +optional
+	RevisionInfo *RevisionInfo `json:"revisionInfo,omitempty"`
+	// SignatureKeyID is the ID of the GPG key whose signature of the revision was verified
+	// +optional
+	SignatureKeyID string `json:"signatureKeyID,omitempty"`
+	// Diff counts the resources that differed, before they were synced
+	// +optional
+	Diff *DiffSummary `json:"diff,omitempty"`
+}
+
+// RevisionInfo is the metadata of a commit
+type RevisionInfo struct {
+	// Author and Committer are formatted as Name <email>
+	Author    string `json:"author,omitempty"`
+	Committer string `json:"committer,omitempty"`
+	// Date is when the commit was authored
+	Date metav1.Time `json:"date,omitempty"`
+	// Subject is the first line of the commit message
+	Subject string `json:"subject,omitempty"`
+	// Tags are the tags pointing at the commit
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+}
+
+// DiffSummary counts the resources that differ between Git and the cluster
+type DiffSummary struct {
+	// Added are the resources missing in the cluster
+	Added int `json:"added"`
+	// Modified are the resources whose fields differ from Git
+	Modified int `json:"modified"`
+	// Removed are the resources no longer defined in Git
+	Removed int `json:"removed"`
+}
+
+type SyncStatusCode string
+
+const (
+	SyncStatusSynced    = "Synced"
+	SyncStatusOutOfSync = "OutOfSync"
+	SyncStatusUnknown   = "Unknown"
+)
+
+// Sync status of a single resource, in addition to Synced and OutOfSync
+const (
+	// SyncStatusMissing is a resource defined in Git that doesn't exist in the cluster
+	SyncStatusMissing = "Missing"
+	// SyncStatusOrphaned is a resource of the application that is no longer defined in Git
+	SyncStatusOrphaned = "Orphaned"
+)
+
+// Condition types, each one is set when the matching stage of the sync fails
+const (
+	ApplicationConditionFetchError     = "FetchError"
+	ApplicationConditionSignatureError = "SignatureError"
+	ApplicationConditionRenderError    = "RenderError"
+	ApplicationConditionDiffError      = "DiffError"
+	ApplicationConditionApplyError     = "ApplyError"
+)
+
+// ResourceRef identifies a Kubernetes resource managed by an application
+type ResourceRef struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// ResourceStatus is the state of a resource managed by an application
+type ResourceStatus struct {
+	ResourceRef `json:",inline"`
+	// Status is Synced, OutOfSync, Missing or Orphaned
+	Status SyncStatusCode `json:"status,omitempty"`
+	// +optional
+	Health HealthStatusCode `json:"health,omitempty"`
+	// HealthMessage explains why the resource is not healthy
+	// +optional
+	HealthMessage string `json:"healthMessage,omitempty"`
+	// Message is the last error that happened on the resource
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 type HealthStatusCode string
@@ -35,6 +399,7 @@ const (
 	HealthStatusProgressing = "Progressing"
 	HealthStatusHealthy     = "Healthy"
 	HealthStatusDegraded    = "Degraded"
+	HealthStatusMissing     = "Missing"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
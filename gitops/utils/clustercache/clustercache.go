@@ -0,0 +1,389 @@
+package clustercache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	k8sutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// appIndex indexes the resources by the namespace and the name of the application owning them
+const appIndex = "app"
+
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// ResourceEventHandler is called with the namespace and the name of the application
+// owning a resource when the resource is changed or deleted. The namespace is empty
+// for the resources labelled before the namespace label was added.
+type ResourceEventHandler func(appNamespace, appName string, obj *unstructured.Unstructured)
+
+type ClusterCache interface {
+	// Watch starts watching the resources of the given kinds, the kinds already
+	// watched or that can't be watched are skipped
+	Watch(gvks []schema.GroupVersionKind)
+	AddEventHandler(handler ResourceEventHandler)
+	// GetResources returns the live resources owned by an application
+	GetResources(appNamespace, appName string) ([]*unstructured.Unstructured, error)
+	// HasSynced returns whether the resources of all the watched kinds are listed
+	HasSynced() bool
+	Run(stopCh <-chan struct{})
+}
+
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+}
+
+type clusterCache struct {
+	discoveryClient discovery.DiscoveryInterface
+	dynClientSet    dynamic.Interface
+	resyncPeriod    time.Duration
+	// labelKey and namespaceLabelKey are the labels of the name and the namespace of
+	// the application owning a resource
+	labelKey          string
+	namespaceLabelKey string
+
+	// discoveryCh requests the API resources to be discovered again
+	discoveryCh chan struct{}
+
+	lock       sync.RWMutex
+	handlers   []ResourceEventHandler
+	informers  map[schema.GroupKind]*resourceInformer
+	discovered bool
+	stopCh     <-chan struct{}
+}
+
+// NewClusterCache watches the resources labelled with labelKey in all the API groups,
+// the values of labelKey and namespaceLabelKey are the name and the namespace of the
+// application owning the resource
+func NewClusterCache(
+	discoveryClient discovery.DiscoveryInterface,
+	dynClientSet dynamic.Interface,
+	resyncPeriod time.Duration,
+	labelKey string,
+	namespaceLabelKey string,
+) *clusterCache {
+	return &clusterCache{
+		discoveryClient:   discoveryClient,
+		dynClientSet:      dynClientSet,
+		resyncPeriod:      resyncPeriod,
+		labelKey:          labelKey,
+		namespaceLabelKey: namespaceLabelKey,
+		discoveryCh:       make(chan struct{}, 1),
+		informers:         map[schema.GroupKind]*resourceInformer{},
+	}
+}
+
+func (c *clusterCache) Watch(gvks []schema.GroupVersionKind) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, gvk := range gvks {
+		if _, ok := c.informers[gvk.GroupKind()]; ok {
+			continue
+		}
+
+		apiResource, err := k8sutil.ServerResourceForGroupVersionKind(c.discoveryClient, gvk, "watch")
+		if err != nil {
+			log.Warnf("Can't watch %s: %s", gvk.String(), err)
+			continue
+		}
+		c.watch(gvk.GroupKind(), gvk.GroupVersion().WithResource(apiResource.Name))
+	}
+}
+
+func (c *clusterCache) AddEventHandler(handler ResourceEventHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.handlers = append(c.handlers, handler)
+}
+
+func (c *clusterCache) GetResources(appNamespace, appName string) ([]*unstructured.Unstructured, error) {
+	if appName == "" {
+		return nil, fmt.Errorf("application name is empty")
+	}
+	if !c.HasSynced() {
+		return nil, fmt.Errorf("cluster cache is not synced yet")
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	// The resources labelled before the namespace label was added match the applications of
+	// their name in every namespace, until they are labelled again by their next sync
+	keys := []string{cache.NewObjectName(appNamespace, appName).String(), appName}
+	var resources []*unstructured.Unstructured
+	for _, r := range c.informers {
+		for _, key := range keys {
+			objs, err := r.informer.GetIndexer().ByIndex(appIndex, key)
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range objs {
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					resources = append(resources, u.DeepCopy())
+				}
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+func (c *clusterCache) HasSynced() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if !c.discovered {
+		return false
+	}
+	for _, r := range c.informers {
+		if !r.informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *clusterCache) Run(stopCh <-chan struct{}) {
+	c.lock.Lock()
+	c.stopCh = stopCh
+	// Start the kinds watched before the cache is running
+	for _, r := range c.informers {
+		go r.informer.Run(r.stopCh)
+	}
+	c.lock.Unlock()
+
+	// New kinds are discovered when CRDs are changed
+	crdInformer := dynamicinformer.NewFilteredDynamicInformer(c.dynClientSet, crdGVR, metav1.NamespaceAll, 0, cache.Indexers{}, nil).Informer()
+	_, err := crdInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.requestDiscovery() },
+		UpdateFunc: func(interface{}, interface{}) { c.requestDiscovery() },
+		DeleteFunc: func(interface{}) { c.requestDiscovery() },
+	})
+	if err != nil {
+		log.Warnf("Can't watch CustomResourceDefinitions, new kinds won't be discovered: %s", err)
+	} else {
+		go crdInformer.Run(stopCh)
+	}
+
+	c.requestDiscovery()
+	for {
+		select {
+		case <-c.discoveryCh:
+			c.discover()
+		case <-stopCh:
+			c.lock.Lock()
+			for groupKind := range c.informers {
+				c.unwatch(groupKind)
+			}
+			c.lock.Unlock()
+			return
+		}
+	}
+}
+
+// requestDiscovery doesn't block, the requests made during a discovery are merged
+func (c *clusterCache) requestDiscovery() {
+	select {
+	case c.discoveryCh <- struct{}{}:
+	default:
+	}
+}
+
+// discover watches the preferred version of all the kinds that can be listed and watched,
+// and stops watching the kinds that were removed. A cached discovery is refreshed first.
+func (c *clusterCache) discover() {
+	if cached, ok := c.discoveryClient.(discovery.CachedDiscoveryInterface); ok {
+		cached.Invalidate()
+	}
+	resourceLists, err := discovery.ServerPreferredResources(c.discoveryClient)
+	partial := discovery.IsGroupDiscoveryFailedError(err)
+	if err != nil {
+		if !partial {
+			log.Errorf("Error discovering API resources: %s", err)
+			return
+		}
+		// The groups that failed are discovered again with the next CRD change
+		log.Warnf("Error discovering some API resources: %s", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	found := map[schema.GroupKind]bool{}
+	resourceLists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "watch"}}, resourceLists)
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			log.Warnf("Error parsing GroupVersion %s: %s", resourceList.GroupVersion, err)
+			continue
+		}
+
+		for _, r := range resourceList.APIResources {
+			// Subresources, e.g. deployments/scale
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			groupKind := gv.WithKind(r.Kind).GroupKind()
+			found[groupKind] = true
+			if _, ok := c.informers[groupKind]; !ok {
+				c.watch(groupKind, gv.WithResource(r.Name))
+			}
+		}
+	}
+
+	if !partial {
+		for groupKind := range c.informers {
+			if !found[groupKind] {
+				log.Infof("Stop watching %s, it was removed", groupKind.String())
+				c.unwatch(groupKind)
+			}
+		}
+	}
+	c.discovered = true
+}
+
+// watch must be called with the lock held
+func (c *clusterCache) watch(groupKind schema.GroupKind, gvr schema.GroupVersionResource) {
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		c.dynClientSet,
+		gvr,
+		metav1.NamespaceAll,
+		c.resyncPeriod,
+		cache.Indexers{appIndex: c.appIndexFunc},
+		func(opts *metav1.ListOptions) {
+			opts.LabelSelector = c.labelKey
+		},
+	).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.handleUpdate,
+		DeleteFunc: c.handleDelete,
+	})
+	if err != nil {
+		log.Warnf("Can't watch %s: %s", groupKind.String(), err)
+		return
+	}
+
+	r := &resourceInformer{informer: informer, stopCh: make(chan struct{})}
+	// Kinds that can't be listed, e.g. forbidden or removed, are skipped so they don't
+	// prevent the cache from syncing. The other errors, e.g. timeouts, are retried
+	err = informer.SetWatchErrorHandler(func(reflector *cache.Reflector, err error) {
+		if informer.HasSynced() || !isPermanent(err) {
+			cache.DefaultWatchErrorHandler(reflector, err)
+			return
+		}
+
+		log.Warnf("Skipping %s, it can't be listed: %s", groupKind.String(), err)
+		c.lock.Lock()
+		if c.informers[groupKind] == r {
+			c.unwatch(groupKind)
+		}
+		c.lock.Unlock()
+	})
+	if err != nil {
+		log.Warnf("Can't watch %s: %s", groupKind.String(), err)
+		return
+	}
+
+	log.Debugf("Watching %s", gvr.String())
+	c.informers[groupKind] = r
+	if c.stopCh != nil {
+		go informer.Run(r.stopCh)
+	}
+}
+
+// isPermanent returns whether the error of a list won't go away by retrying it
+func isPermanent(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err)
+}
+
+// unwatch must be called with the lock held
+func (c *clusterCache) unwatch(groupKind schema.GroupKind) {
+	close(c.informers[groupKind].stopCh)
+	delete(c.informers, groupKind)
+}
+
+func (c *clusterCache) appIndexFunc(obj interface{}) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	labels := u.GetLabels()
+	appNamespace, appName := labels[c.namespaceLabelKey], labels[c.labelKey]
+	if appName == "" {
+		return nil, nil
+	}
+	// Without the namespace label, the key is the name of the application alone
+	return []string{cache.NewObjectName(appNamespace, appName).String()}, nil
+}
+
+func (c *clusterCache) handleUpdate(old, new interface{}) {
+	oldObj, oldOk := old.(*unstructured.Unstructured)
+	newObj, newOk := new.(*unstructured.Unstructured)
+	if !oldOk || !newOk {
+		log.Error("Error decoding object, invalid type")
+		return
+	}
+
+	// Resyncs and changes of the status are not drift
+	if oldObj.GetResourceVersion() == newObj.GetResourceVersion() || !changed(oldObj, newObj) {
+		return
+	}
+
+	log.Debugf("%s %s/%s changed", newObj.GetKind(), newObj.GetNamespace(), newObj.GetName())
+	c.notify(newObj)
+}
+
+func (c *clusterCache) handleDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	deleted, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		log.Error("Error decoding object, invalid type")
+		return
+	}
+
+	log.Debugf("%s %s/%s deleted", deleted.GetKind(), deleted.GetNamespace(), deleted.GetName())
+	c.notify(deleted)
+}
+
+func (c *clusterCache) notify(obj *unstructured.Unstructured) {
+	c.lock.RLock()
+	handlers := c.handlers
+	c.lock.RUnlock()
+
+	labels := obj.GetLabels()
+	for _, handler := range handlers {
+		handler(labels[c.namespaceLabelKey], labels[c.labelKey], obj)
+	}
+}
+
+// changed returns whether a resource changed, ignoring its status
+// and the metadata maintained by the API server
+func changed(old *unstructured.Unstructured, new *unstructured.Unstructured) bool {
+	return !equality.Semantic.DeepEqual(withoutStatus(old), withoutStatus(new))
+}
+
+func withoutStatus(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	delete(obj.Object, "status")
+	for _, field := range []string{"resourceVersion", "generation", "managedFields"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	return obj.Object
+}
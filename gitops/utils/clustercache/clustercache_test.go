@@ -0,0 +1,235 @@
+package clustercache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const (
+	labelKey          = "thongdepzai.cloud/app-instance"
+	namespaceLabelKey = "thongdepzai.cloud/app-namespace"
+)
+
+var (
+	configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretGVR    = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+)
+
+func newConfigMap(name string, data string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{labelKey: "example-application", namespaceLabelKey: "default"})
+	obj.Object["data"] = map[string]interface{}{"key": data}
+	return obj
+}
+
+func newFakeClusterCache(objs ...runtime.Object) (*clusterCache, *dynamicfake.FakeDynamicClient) {
+	discoveryClient := &fakediscovery.FakeDiscovery{
+		Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+						{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+						{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+					},
+				},
+			},
+		},
+	}
+	dynClientSet := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			configMapGVR: "ConfigMapList",
+			secretGVR:    "SecretList",
+			crdGVR:       "CustomResourceDefinitionList",
+		},
+		objs...,
+	)
+
+	return NewClusterCache(discoveryClient, dynClientSet, 0, labelKey, namespaceLabelKey), dynClientSet
+}
+
+func runClusterCache(t *testing.T, clusterCache *clusterCache) chan struct{} {
+	stopCh := make(chan struct{})
+	go clusterCache.Run(stopCh)
+	assert.Eventually(t, clusterCache.HasSynced, 5*time.Second, 10*time.Millisecond)
+	return stopCh
+}
+
+func Test_GetResources(t *testing.T) {
+	configMap := newConfigMap("nginx", "value")
+	otherConfigMap := newConfigMap("redis", "value")
+	otherConfigMap.SetLabels(map[string]string{labelKey: "other-application", namespaceLabelKey: "default"})
+	// An application with the same name in another namespace
+	otherNamespaceConfigMap := newConfigMap("postgres", "value")
+	otherNamespaceConfigMap.SetLabels(map[string]string{labelKey: "example-application", namespaceLabelKey: "production"})
+	// A resource labelled before the namespace label was added
+	legacyConfigMap := newConfigMap("mysql", "value")
+	legacyConfigMap.SetLabels(map[string]string{labelKey: "example-application"})
+	clusterCache, dynClientSet := newFakeClusterCache(configMap, otherConfigMap, otherNamespaceConfigMap, legacyConfigMap)
+
+	// Secrets can't be listed
+	dynClientSet.PrependReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", fmt.Errorf("forbidden"))
+	})
+
+	_, err := clusterCache.GetResources("default", "example-application")
+	assert.EqualError(t, err, "cluster cache is not synced yet")
+
+	stopCh := runClusterCache(t, clusterCache)
+	defer close(stopCh)
+
+	clusterCache.lock.RLock()
+	assert.Len(t, clusterCache.informers, 1)
+	assert.Contains(t, clusterCache.informers, schema.GroupKind{Kind: "ConfigMap"})
+	clusterCache.lock.RUnlock()
+
+	resources, err := clusterCache.GetResources("default", "example-application")
+	assert.NoError(t, err)
+	assert.Equal(t, []*unstructured.Unstructured{configMap, legacyConfigMap}, resources)
+
+	// The resources without the namespace label match the application in every namespace
+	resources, err = clusterCache.GetResources("production", "example-application")
+	assert.NoError(t, err)
+	assert.Equal(t, []*unstructured.Unstructured{otherNamespaceConfigMap, legacyConfigMap}, resources)
+
+	resources, err = clusterCache.GetResources("default", "other-application")
+	assert.NoError(t, err)
+	assert.Equal(t, []*unstructured.Unstructured{otherConfigMap}, resources)
+
+	_, err = clusterCache.GetResources("default", "")
+	assert.EqualError(t, err, "application name is empty")
+}
+
+func Test_GetResources_ListRetried(t *testing.T) {
+	configMap := newConfigMap("nginx", "value")
+	clusterCache, dynClientSet := newFakeClusterCache(configMap)
+
+	// The first list of the ConfigMaps fails with a transient error
+	var lock sync.Mutex
+	failed := false
+	dynClientSet.PrependReactor("list", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failed {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, apierrors.NewInternalError(fmt.Errorf("etcd timeout"))
+	})
+
+	stopCh := runClusterCache(t, clusterCache)
+	defer close(stopCh)
+
+	// The kind is still watched once the list is retried
+	resources, err := clusterCache.GetResources("default", "example-application")
+	assert.NoError(t, err)
+	assert.Equal(t, []*unstructured.Unstructured{configMap}, resources)
+}
+
+func Test_Watch(t *testing.T) {
+	ctx := context.Background()
+	configMap := newConfigMap("nginx", "value")
+	clusterCache, dynClientSet := newFakeClusterCache(configMap)
+
+	var lock sync.Mutex
+	var events []string
+	clusterCache.AddEventHandler(func(appNamespace, appName string, obj *unstructured.Unstructured) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, appNamespace+"/"+appName+"/"+obj.GetName())
+	})
+	receivedEvents := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, events...)
+	}
+
+	clusterCache.Watch([]schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		// Kinds that can't be watched are skipped
+		{Group: "example.com", Version: "v1", Kind: "Database"},
+	})
+	assert.Len(t, clusterCache.informers, 1)
+
+	stopCh := runClusterCache(t, clusterCache)
+	defer close(stopCh)
+
+	// A change of the status is not drift
+	withStatus := configMap.DeepCopy()
+	withStatus.SetResourceVersion("2")
+	withStatus.Object["status"] = map[string]interface{}{"observed": true}
+	_, err := dynClientSet.Resource(configMapGVR).Namespace("default").Update(ctx, withStatus, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	modified := newConfigMap("nginx", "modified")
+	modified.SetResourceVersion("3")
+	_, err = dynClientSet.Resource(configMapGVR).Namespace("default").Update(ctx, modified, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"default/example-application/nginx"}, receivedEvents())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = dynClientSet.Resource(configMapGVR).Namespace("default").Delete(ctx, "nginx", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(receivedEvents()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_Discover_Cached(t *testing.T) {
+	fake := &clienttesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+				},
+			},
+		},
+	}
+	dynClientSet := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			configMapGVR: "ConfigMapList",
+			secretGVR:    "SecretList",
+		},
+	)
+	clusterCache := NewClusterCache(memory.NewMemCacheClient(&fakediscovery.FakeDiscovery{Fake: fake}), dynClientSet, 0, labelKey, namespaceLabelKey)
+
+	clusterCache.discover()
+	assert.Len(t, clusterCache.informers, 1)
+
+	// The kinds added since the last discovery are found, the cached discovery is refreshed
+	fake.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+				{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+			},
+		},
+	}
+	clusterCache.discover()
+	assert.Len(t, clusterCache.informers, 2)
+	assert.Contains(t, clusterCache.informers, schema.GroupKind{Kind: "Secret"})
+}
@@ -0,0 +1,7 @@
+package mock
+
+import (
+	_ "go.uber.org/mock/mockgen/model"
+)
+
+//go:generate mockgen -destination=mock_clustercache.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache ClusterCache
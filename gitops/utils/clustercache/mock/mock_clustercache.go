@@ -0,0 +1,107 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache (interfaces: ClusterCache)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock_clustercache.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache ClusterCache
+//
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+
+	clustercache "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/clustercache"
+	gomock "go.uber.org/mock/gomock"
+	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// MockClusterCache is a mock of ClusterCache interface.
+type MockClusterCache struct {
+	ctrl     *gomock.Controller
+	recorder *MockClusterCacheMockRecorder
+}
+
+// MockClusterCacheMockRecorder is the mock recorder for MockClusterCache.
+type MockClusterCacheMockRecorder struct {
+	mock *MockClusterCache
+}
+
+// NewMockClusterCache creates a new mock instance.
+func NewMockClusterCache(ctrl *gomock.Controller) *MockClusterCache {
+	mock := &MockClusterCache{ctrl: ctrl}
+	mock.recorder = &MockClusterCacheMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClusterCache) EXPECT() *MockClusterCacheMockRecorder {
+	return m.recorder
+}
+
+// AddEventHandler mocks base method.
+func (m *MockClusterCache) AddEventHandler(arg0 clustercache.ResourceEventHandler) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AddEventHandler", arg0)
+}
+
+// AddEventHandler indicates an expected call of AddEventHandler.
+func (mr *MockClusterCacheMockRecorder) AddEventHandler(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddEventHandler", reflect.TypeOf((*MockClusterCache)(nil).AddEventHandler), arg0)
+}
+
+// GetResources mocks base method.
+func (m *MockClusterCache) GetResources(arg0, arg1 string) ([]*unstructured.Unstructured, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetResources", arg0, arg1)
+	ret0, _ := ret[0].([]*unstructured.Unstructured)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetResources indicates an expected call of GetResources.
+func (mr *MockClusterCacheMockRecorder) GetResources(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResources", reflect.TypeOf((*MockClusterCache)(nil).GetResources), arg0, arg1)
+}
+
+// HasSynced mocks base method.
+func (m *MockClusterCache) HasSynced() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasSynced")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasSynced indicates an expected call of HasSynced.
+func (mr *MockClusterCacheMockRecorder) HasSynced() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasSynced", reflect.TypeOf((*MockClusterCache)(nil).HasSynced))
+}
+
+// Run mocks base method.
+func (m *MockClusterCache) Run(arg0 <-chan struct{}) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Run", arg0)
+}
+
+// Run indicates an expected call of Run.
+func (mr *MockClusterCacheMockRecorder) Run(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockClusterCache)(nil).Run), arg0)
+}
+
+// Watch mocks base method.
+func (m *MockClusterCache) Watch(arg0 []schema.GroupVersionKind) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Watch", arg0)
+}
+
+// Watch indicates an expected call of Watch.
+func (mr *MockClusterCacheMockRecorder) Watch(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Watch", reflect.TypeOf((*MockClusterCache)(nil).Watch), arg0)
+}
@@ -0,0 +1,215 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Keys of the data of the Secrets holding credentials
+const (
+	SecretKeyURL           = "url"
+	SecretKeyUsername      = "username"
+	SecretKeyPassword      = "password"
+	SecretKeyToken         = "token"
+	SecretKeySSHPrivateKey = "sshPrivateKey"
+	SecretKeyKnownHosts    = "knownHosts"
+	SecretKeyCABundle      = "caBundle"
+	SecretKeyTLSClientCert = "tlsClientCert"
+	SecretKeyTLSClientKey  = "tlsClientKey"
+	SecretKeyInsecure      = "insecure"
+	SecretKeyProxy         = "proxy"
+	SecretKeyNoProxy       = "noProxy"
+)
+
+// Credentials authenticate to a repository over HTTPS with a username and a password or
+// a token, or over SSH with a private key. They also hold the TLS and proxy settings of
+// the HTTPS repositories
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+
+	SSHPrivateKey []byte
+	// KnownHosts are the known_hosts entries the SSH host keys are checked against.
+	// Defaults to the known_hosts files of the controller
+	KnownHosts []byte
+
+	// CABundle are the PEM certificates trusted in addition to the system ones
+	CABundle      []byte
+	TLSClientCert []byte
+	TLSClientKey  []byte
+	// Insecure skips the verification of the server certificate
+	Insecure bool
+
+	// Proxy is the URL of the HTTP or HTTPS proxy. Defaults to the proxy of the environment
+	Proxy string
+	// NoProxy are the comma-separated hosts, domains and CIDRs reached without the proxy
+	NoProxy string
+}
+
+// NewCredentialsFromSecret reads the credentials of a Secret
+func NewCredentialsFromSecret(secret *corev1.Secret) *Credentials {
+	return &Credentials{
+		Username:      string(secret.Data[SecretKeyUsername]),
+		Password:      string(secret.Data[SecretKeyPassword]),
+		Token:         string(secret.Data[SecretKeyToken]),
+		SSHPrivateKey: secret.Data[SecretKeySSHPrivateKey],
+		KnownHosts:    secret.Data[SecretKeyKnownHosts],
+		CABundle:      secret.Data[SecretKeyCABundle],
+		TLSClientCert: secret.Data[SecretKeyTLSClientCert],
+		TLSClientKey:  secret.Data[SecretKeyTLSClientKey],
+		Insecure:      string(secret.Data[SecretKeyInsecure]) == "true",
+		Proxy:         string(secret.Data[SecretKeyProxy]),
+		NoProxy:       string(secret.Data[SecretKeyNoProxy]),
+	}
+}
+
+// FindCredentials returns the credentials of the repository Secret with the URL of the repository,
+// or else of the credential template Secret with the longest URL prefix of it.
+// It returns nil if no Secret matches.
+func FindCredentials(url string, secrets []*corev1.Secret) *Credentials {
+	var template *corev1.Secret
+	var templateURL string
+	for _, secret := range secrets {
+		secretURL := string(secret.Data[SecretKeyURL])
+		if secretURL == "" {
+			continue
+		}
+
+		switch secret.Labels[common.LabelKeySecretType] {
+		case common.SecretTypeRepository:
+			if normalizeURL(secretURL) == normalizeURL(url) {
+				return NewCredentialsFromSecret(secret)
+			}
+		case common.SecretTypeRepoCreds:
+			if hasPathPrefix(url, secretURL) && len(secretURL) > len(templateURL) {
+				template, templateURL = secret, secretURL
+			}
+		}
+	}
+
+	if template == nil {
+		return nil
+	}
+	return NewCredentialsFromSecret(template)
+}
+
+// hasPathPrefix returns whether the URL is under the prefix, i.e. the prefix ends at a
+// path boundary of the URL and https://github.com/org/repo doesn't match https://github.com/org/repo-evil
+func hasPathPrefix(url, prefix string) bool {
+	if !strings.HasPrefix(url, prefix) {
+		return false
+	}
+	if strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, ":") {
+		return true
+	}
+
+	rest := strings.TrimPrefix(url, prefix)
+	return rest == "" || rest == ".git" || strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, ".git/")
+}
+
+// normalizeURL removes the differences between the URLs of the same repository
+func normalizeURL(url string) string {
+	url = strings.ToLower(strings.TrimSuffix(url, "/"))
+	return strings.TrimSuffix(url, ".git")
+}
+
+// authMethod returns the authentication to the repository, nil without credentials
+func (c *Credentials) authMethod(url string) (transport.AuthMethod, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %w", err)
+	}
+
+	if endpoint.Protocol == "ssh" {
+		if len(c.SSHPrivateKey) == 0 {
+			return nil, nil
+		}
+		user := endpoint.User
+		if user == "" {
+			user = c.Username
+		}
+		if user == "" {
+			user = gitssh.DefaultUsername
+		}
+		auth, err := gitssh.NewPublicKeys(user, c.SSHPrivateKey, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+		}
+		if len(c.KnownHosts) > 0 {
+			auth.HostKeyCallback, err = knownHostsCallback(c.KnownHosts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return auth, nil
+	}
+
+	if c.Token != "" {
+		// The intended use of a GitHub personal access token is in replace of your password
+		// because access tokens can easily be revoked.
+		// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
+		username := c.Username
+		if username == "" {
+			username = "github" // yes, this can be anything except an empty string
+		}
+		return &http.BasicAuth{Username: username, Password: c.Token}, nil
+	}
+	if c.Username != "" || c.Password != "" {
+		return &http.BasicAuth{Username: c.Username, Password: c.Password}, nil
+	}
+
+	return nil, nil
+}
+
+// connect returns the context and the authentication of the requests to the repository,
+// done releases the connections once they are sent
+func (c *Credentials) connect(url string) (context.Context, transport.AuthMethod, func(), error) {
+	auth, err := c.authMethod(url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	httpTransport, err := c.httpTransport()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if httpTransport == nil {
+		return context.Background(), auth, func() {}, nil
+	}
+
+	return withTransport(context.Background(), httpTransport), auth, httpTransport.CloseIdleConnections, nil
+}
+
+// knownHostsCallback checks the host keys against the known_hosts entries, they are read
+// from a temporary file as the known_hosts parser only reads files
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	file, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := file.Write(knownHosts); err != nil {
+		return nil, fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+	callback, err := gitssh.NewKnownHostsCallback(file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+	}
+
+	return callback, nil
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newCredentialsSecret(name, secretType, url string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{common.LabelKeySecretType: secretType},
+		},
+		Data: map[string][]byte{
+			SecretKeyURL:      []byte(url),
+			SecretKeyUsername: []byte(name),
+		},
+	}
+}
+
+func TestFindCredentials(t *testing.T) {
+	secrets := []*corev1.Secret{
+		newCredentialsSecret("org", common.SecretTypeRepoCreds, "https://github.com/org"),
+		newCredentialsSecret("org-team", common.SecretTypeRepoCreds, "https://github.com/org/team"),
+		newCredentialsSecret("repository", common.SecretTypeRepoCreds, "https://github.com/org/repository"),
+		newCredentialsSecret("repo", common.SecretTypeRepository, "https://github.com/org/repo.git"),
+		newCredentialsSecret("other", "other", "https://github.com/org/other"),
+	}
+
+	testCases := []struct {
+		name             string
+		url              string
+		expectedUsername string
+	}{
+		{
+			name:             "Should match the repository",
+			url:              "https://github.com/org/repo",
+			expectedUsername: "repo",
+		},
+		{
+			name:             "Should match the template with the longest prefix",
+			url:              "https://github.com/org/team/repo.git",
+			expectedUsername: "org-team",
+		},
+		{
+			name:             "Should match the template of the same repository",
+			url:              "https://github.com/org/repository.git",
+			expectedUsername: "repository",
+		},
+		{
+			name:             "Should only match the templates ending at a path boundary",
+			url:              "https://github.com/org/repository-evil.git",
+			expectedUsername: "org",
+		},
+		{
+			name: "Should only match the templates ending at a path boundary of the organization",
+			url:  "https://github.com/organization/repo.git",
+		},
+		{
+			name:             "Should match the template of the organization",
+			url:              "https://github.com/org/app.git",
+			expectedUsername: "org",
+		},
+		{
+			name:             "Should ignore the Secrets of other types",
+			url:              "https://github.com/org/other",
+			expectedUsername: "org",
+		},
+		{
+			name: "Should return nil if no Secret matches",
+			url:  "https://gitlab.com/org/repo.git",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := FindCredentials(tt.url, secrets)
+			if tt.expectedUsername == "" {
+				assert.Nil(t, creds)
+				return
+			}
+			assert.Equal(t, tt.expectedUsername, creds.Username)
+		})
+	}
+}
+
+func TestCredentials_AuthMethod(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	assert.NoError(t, err)
+	privateKey := pem.EncodeToMemory(block)
+
+	testCases := []struct {
+		name        string
+		creds       *Credentials
+		url         string
+		expected    interface{}
+		expectedSSH string
+		expectedErr bool
+	}{
+		{
+			name:     "Should not authenticate without credentials",
+			creds:    nil,
+			url:      "https://github.com/org/repo.git",
+			expected: nil,
+		},
+		{
+			name:     "Should authenticate with a username and a password",
+			creds:    &Credentials{Username: "user", Password: "password"},
+			url:      "https://github.com/org/repo.git",
+			expected: &http.BasicAuth{Username: "user", Password: "password"},
+		},
+		{
+			name:     "Should authenticate with a token",
+			creds:    &Credentials{Token: "token"},
+			url:      "https://github.com/org/repo.git",
+			expected: &http.BasicAuth{Username: "github", Password: "token"},
+		},
+		{
+			name:        "Should authenticate with the SSH key as the user of the URL",
+			creds:       &Credentials{SSHPrivateKey: privateKey},
+			url:         "git@github.com:org/repo.git",
+			expectedSSH: "git",
+		},
+		{
+			name:        "Should check the known hosts",
+			creds:       &Credentials{Username: "deploy", SSHPrivateKey: privateKey, KnownHosts: []byte("github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n")},
+			url:         "ssh://github.com/org/repo.git",
+			expectedSSH: "deploy",
+		},
+		{
+			name:        "Should return error if the known hosts are invalid",
+			creds:       &Credentials{SSHPrivateKey: privateKey, KnownHosts: []byte("github.com ssh-ed25519 invalid\n")},
+			url:         "git@github.com:org/repo.git",
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if the SSH key is invalid",
+			creds:       &Credentials{SSHPrivateKey: []byte("invalid")},
+			url:         "git@github.com:org/repo.git",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := tt.creds.authMethod(tt.url)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			if tt.expectedSSH != "" {
+				publicKeys, ok := auth.(*gitssh.PublicKeys)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedSSH, publicKeys.User)
+				assert.Equal(t, len(tt.creds.KnownHosts) > 0, publicKeys.HostKeyCallback != nil)
+				return
+			}
+			if tt.expected == nil {
+				assert.Nil(t, auth)
+				return
+			}
+			assert.Equal(t, tt.expected, auth)
+		})
+	}
+}
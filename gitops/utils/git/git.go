@@ -1,111 +1,781 @@
 package git
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// shaRegexp matches full and short commit SHAs
+	shaRegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+	// unsafePathRegexp matches the characters of URLs replaced in the paths of the caches
+	unsafePathRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
 )
 
 type GitClient interface {
-	CloneOrFetch(url, path string) error
-	Checkout(path, revision string) (string, error)
-	CleanUp(path string) error
+	// CloneOrFetch updates the bare cache of the repository, cloning it the first time.
+	// creds is nil for public repositories and opts nil to fetch the full history of all
+	// the references. Concurrent calls for a repository with the same options share a
+	// single fetch
+	CloneOrFetch(url string, creds *Credentials, opts *CloneOptions) error
+	// Checkout extracts the tree of the revision from the cache of the repository into
+	// the worktree, e.g. <namespace>/<name> of an application. Only the files and the
+	// directories of paths are extracted, the whole tree if it is empty. It returns the
+	// directory of the tree and the commit SHA, the trees of the other revisions are removed
+	Checkout(url, revision, worktree string, paths []string) (string, string, error)
+	// LsRemote resolves the revision with the references of the remote, without fetching
+	// it. It is cheaper than CloneOrFetch to know whether a revision moved
+	LsRemote(url, revision string, creds *Credentials) (string, error)
+	// CommitInfo returns the metadata of the commit SHA checked out from the cache of the repository
+	CommitInfo(url, sha string) (*CommitInfo, error)
+	// VerifySignature verifies the OpenPGP signature of the commit SHA checked out for the
+	// revision, or of its annotated tag, with the keyring
+	VerifySignature(url, revision, sha string, keyRing openpgp.EntityList) (*Signature, error)
+	// CleanUp removes the trees of the worktree
+	CleanUp(worktree string) error
+}
+
+const (
+	repositoriesDir = "repositories"
+	worktreesDir    = "worktrees"
+
+	// peeledSuffix ends the references of the commits of annotated tags listed by remotes
+	peeledSuffix = "^{}"
+
+	// maxDeepenDepth is the depth from which a missing commit is searched in the full history
+	maxDeepenDepth = 1024
+	// infiniteDepth fetches the full history of a shallow cache, like git fetch --unshallow
+	infiniteDepth = 1<<31 - 1
+)
+
+var (
+	// allRefSpecs fetch the branches into their remote-tracking references, the moved
+	// tags and HEAD as the default branch of the remote
+	allRefSpecs = []config.RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/tags/*:refs/tags/*",
+		"+HEAD:refs/remotes/origin/HEAD",
+	}
+	headRefSpecs = []config.RefSpec{"+HEAD:refs/remotes/origin/HEAD"}
+	tagsRefSpecs = []config.RefSpec{"+refs/tags/*:refs/tags/*"}
+)
+
+// CloneOptions limit the history and the references fetched into the cache of a repository
+type CloneOptions struct {
+	// Depth is the number of commits fetched from the head of each reference, 0 fetches
+	// the full history. The history is deepened until it holds a commit SHA revision
+	Depth int
+	// SingleBranch only fetches the tag or the branch of the revision, all the tags for a
+	// semver constraint or HEAD for a commit SHA
+	SingleBranch bool
+	// Revision is the revision checked out after the fetch
+	Revision string
+}
+
+// CommitInfo is the metadata of a commit
+type CommitInfo struct {
+	// Author and Committer are formatted as Name <email>
+	Author    string
+	Committer string
+	// Date is when the commit was authored
+	Date time.Time
+	// Subject is the first line of the message
+	Subject string
+	// Tags are the tags pointing at the commit, sorted
+	Tags []string
 }
 
 type gitClient struct {
-	token string
+	// root is the workspace holding the caches of the repositories and the worktrees
+	root string
+
+	mutex sync.Mutex
+	// repoLocks are held for writing while a repository is fetched and for reading
+	// while its trees are extracted
+	repoLocks map[string]*sync.RWMutex
+	fetches   singleflight.Group
 }
 
-func NewGitClient(token string) *gitClient {
+func NewGitClient(root string) *gitClient {
 	return &gitClient{
-		token: token,
+		root:      root,
+		repoLocks: make(map[string]*sync.RWMutex),
+	}
+}
+
+// IsCommitSHA returns whether the revision is a full or short commit SHA
+func IsCommitSHA(revision string) bool {
+	return shaRegexp.MatchString(revision)
+}
+
+// repositoryPath returns the path of the cache of the repository, with or without
+// the .git suffix. The hash of the URL avoids the collisions of the replaced characters
+func (g *gitClient) repositoryPath(url string) string {
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	name := strings.Trim(unsafePathRegexp.ReplaceAllString(url, "_"), "_")
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(g.root, repositoriesDir, fmt.Sprintf("%s-%x", name, hash[:4]))
+}
+
+func (g *gitClient) repoLock(repoPath string) *sync.RWMutex {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	lock, ok := g.repoLocks[repoPath]
+	if !ok {
+		lock = &sync.RWMutex{}
+		g.repoLocks[repoPath] = lock
+	}
+	return lock
+}
+
+func (g *gitClient) CloneOrFetch(url string, creds *Credentials, opts *CloneOptions) error {
+	repoPath := g.repositoryPath(url)
+	// The fetches with other options may not fetch the same references
+	key := repoPath
+	if opts != nil {
+		key = fmt.Sprintf("%s?depth=%d&singleBranch=%t&revision=%s", repoPath, opts.Depth, opts.SingleBranch, opts.Revision)
+	}
+	_, err, _ := g.fetches.Do(key, func() (interface{}, error) {
+		lock := g.repoLock(repoPath)
+		lock.Lock()
+		defer lock.Unlock()
+
+		return nil, cloneOrFetch(url, repoPath, creds, opts)
+	})
+
+	return err
+}
+
+func cloneOrFetch(url, path string, creds *Credentials, opts *CloneOptions) error {
+	if opts == nil {
+		opts = &CloneOptions{}
+	}
+	ctx, auth, done, err := creds.connect(url)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// Need to clone the repository, it is fetched with the options like the next times
+	r, err := git.PlainOpen(path)
+	cloning := errors.Is(err, git.ErrRepositoryNotExists)
+	if cloning {
+		r, err = initRepository(path, url)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Fetch the latest changes
+	err = fetch(ctx, r, auth, opts)
+	if err != nil && cloning {
+		// The next call clones the repository again
+		os.RemoveAll(path)
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch repository: %w", err)
+	}
+
+	return nil
+}
+
+// initRepository creates an empty bare repository with the URL as its origin
+func initRepository(path, url string) (*git.Repository, error) {
+	r, err := git.PlainInit(path, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// fetch updates the references of the options with their history up to the depth, then
+// deepens it until it holds the commit of a SHA revision
+func fetch(ctx context.Context, r *git.Repository, auth transport.AuthMethod, opts *CloneOptions) error {
+	refSpecs := allRefSpecs
+	if opts.SingleBranch {
+		var err error
+		refSpecs, err = singleBranchRefSpecs(ctx, r, auth, opts.Revision)
+		if err != nil {
+			return err
+		}
 	}
+
+	// The history fetched shallow for other applications is completed
+	depth := opts.Depth
+	if depth == 0 && isShallow(r) {
+		depth = infiniteDepth
+	}
+	if err := fetchRefSpecs(ctx, r, auth, refSpecs, depth); err != nil {
+		return err
+	}
+	if opts.Depth == 0 || !shaRegexp.MatchString(opts.Revision) {
+		return nil
+	}
+
+	for depth := opts.Depth * 2; isShallow(r); depth *= 2 {
+		if _, err := resolveRevision(r, opts.Revision); err == nil {
+			return nil
+		}
+		if depth > maxDeepenDepth {
+			depth = infiniteDepth
+		}
+		log.Debugf("Deepening the history to %d commits to find commit %s", depth, opts.Revision)
+		if err := fetchRefSpecs(ctx, r, auth, refSpecs, depth); err != nil {
+			return err
+		}
+		// The commit is not in the history of the references
+		if depth == infiniteDepth {
+			return nil
+		}
+	}
+
+	return nil
 }
 
-func (g *gitClient) CloneOrFetch(url, path string) error {
-	// Need to clone the repository
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		var authOption *http.BasicAuth
+func fetchRefSpecs(ctx context.Context, r *git.Repository, auth transport.AuthMethod, refSpecs []config.RefSpec, depth int) error {
+	err := r.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: refSpecs,
+		Depth:    depth,
+		Tags:     git.NoTags,
+		Force:    true,
+		Auth:     auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	if depth == 0 {
+		return nil
+	}
 
-		if g.token == "" {
-			authOption = nil
-		} else {
-			// The intended use of a GitHub personal access token is in replace of your password
-			// because access tokens can easily be revoked.
-			// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
-			authOption = &http.BasicAuth{
-				Username: "github", // yes, this can be anything except an empty string
-				Password: g.token,
+	return pruneShallows(r)
+}
+
+// singleBranchRefSpecs returns the refspecs of the tag or the branch of the revision, of all
+// the tags for a semver constraint, or of HEAD for a commit SHA
+func singleBranchRefSpecs(ctx context.Context, r *git.Repository, auth transport.AuthMethod, revision string) ([]config.RefSpec, error) {
+	if revision == "" || revision == "HEAD" {
+		return headRefSpecs, nil
+	}
+
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote references: %w", err)
+	}
+	names := make(map[plumbing.ReferenceName]bool, len(refs))
+	for _, ref := range refs {
+		names[ref.Name()] = true
+	}
+
+	tag := plumbing.NewTagReferenceName(revision)
+	if names[tag] {
+		return []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", tag, tag))}, nil
+	}
+	branch := plumbing.NewBranchReferenceName(revision)
+	if names[branch] {
+		remoteBranch := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, revision)
+		return []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", branch, remoteBranch))}, nil
+	}
+	if shaRegexp.MatchString(revision) {
+		return headRefSpecs, nil
+	}
+	if _, err := semver.NewConstraint(revision); err == nil {
+		return tagsRefSpecs, nil
+	}
+	return nil, fmt.Errorf("no tag or branch matches revision %s", revision)
+}
+
+func isShallow(r *git.Repository) bool {
+	shallows, err := r.Storer.Shallow()
+	return err == nil && len(shallows) > 0
+}
+
+// pruneShallows removes the commits whose parents were fetched from the shallow commits,
+// go-git doesn't remove the commits the remote unshallows
+func pruneShallows(r *git.Repository) error {
+	shallows, err := r.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	var kept []plumbing.Hash
+	for _, hash := range shallows {
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			kept = append(kept, hash)
+			continue
+		}
+		for _, parent := range commit.ParentHashes {
+			if _, err := r.Storer.EncodedObject(plumbing.CommitObject, parent); err != nil {
+				kept = append(kept, hash)
+				break
 			}
 		}
+	}
+	if len(kept) == len(shallows) {
+		return nil
+	}
+
+	return r.Storer.SetShallow(kept)
+}
+
+// Checkout resolves the revision as a commit SHA, a tag, a branch, HEAD or a semver
+// constraint over the tags, e.g. v1.2.* or >=1.4.0 <2.0.0, in this order. The trees of
+// a worktree must not be checked out concurrently.
+func (g *gitClient) Checkout(url, revision, worktree string, paths []string) (string, string, error) {
+	repoPath := g.repositoryPath(url)
+	lock := g.repoLock(repoPath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	hash, err := resolveRevision(r, revision)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve revision %s: %w", revision, err)
+	}
 
-		_, err := git.PlainClone(path, false, &git.CloneOptions{
-			Auth: authOption,
-			URL:  url,
-		})
+	// The sparse trees of the paths are named after them too
+	paths = sparsePaths(paths)
+	treeName := hash.String()
+	if len(paths) > 0 {
+		pathsHash := sha256.Sum256([]byte(strings.Join(paths, "\x00")))
+		treeName = fmt.Sprintf("%s-%x", treeName, pathsHash[:4])
+	}
+	dir := filepath.Join(g.root, worktreesDir, worktree)
+	treePath := filepath.Join(dir, treeName)
+	if _, err := os.Stat(treePath); os.IsNotExist(err) {
+		// Extracted aside so that an interrupted extraction is never used
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", "", fmt.Errorf("failed to create worktree: %w", err)
+		}
+		tmpPath, err := os.MkdirTemp(dir, ".tmp-")
 		if err != nil {
-			return fmt.Errorf("failed to clone repository: %w", err)
+			return "", "", fmt.Errorf("failed to create worktree: %w", err)
+		}
+		if err := extractTree(r, hash, paths, tmpPath); err != nil {
+			os.RemoveAll(tmpPath)
+			return "", "", fmt.Errorf("failed to checkout revision: %w", err)
+		}
+		if err := os.Rename(tmpPath, treePath); err != nil {
+			os.RemoveAll(tmpPath)
+			return "", "", fmt.Errorf("failed to checkout revision: %w", err)
 		}
 	}
 
-	// Fetch the latest changes
-	r, err := git.PlainOpen(path)
+	// Remove the trees of the other revisions
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return "", "", fmt.Errorf("failed to read worktree: %w", err)
 	}
-	err = r.Fetch(&git.FetchOptions{
-		Force: true,
+	for _, entry := range entries {
+		if entry.Name() == treeName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return "", "", fmt.Errorf("failed to clean up worktree: %w", err)
+		}
+	}
+
+	return treePath, hash.String(), nil
+}
+
+func (g *gitClient) CommitInfo(url, sha string) (*CommitInfo, error) {
+	repoPath := g.repositoryPath(url)
+	lock := g.repoLock(repoPath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	commit, err := r.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", sha, err)
+	}
+
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	info := &CommitInfo{
+		Author:    commit.Author.String(),
+		Committer: commit.Committer.String(),
+		Date:      commit.Author.When,
+		Subject:   strings.TrimSpace(subject),
+	}
+
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		target := ref.Hash()
+		// Annotated tags point at a tag object
+		if tag, err := r.TagObject(target); err == nil {
+			tagCommit, err := tag.Commit()
+			if err != nil {
+				return nil
+			}
+			target = tagCommit.Hash
+		}
+		if target == commit.Hash {
+			info.Tags = append(info.Tags, ref.Name().Short())
+		}
+		return nil
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return fmt.Errorf("failed to fetch repository: %w", err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
 	}
+	sort.Strings(info.Tags)
 
-	return nil
+	return info, nil
 }
 
-func (g *gitClient) Checkout(path, revision string) (string, error) {
-	r, err := git.PlainOpen(path)
+// sparsePaths cleans and sorts the paths of a sparse checkout, nil if one of them is the root
+func sparsePaths(paths []string) []string {
+	var cleaned []string
+	for _, p := range paths {
+		p = path.Clean("/" + p)[1:]
+		if p == "" {
+			return nil
+		}
+		cleaned = append(cleaned, p)
+	}
+	sort.Strings(cleaned)
+
+	return cleaned
+}
+
+// inPaths returns whether the file is one of the paths or in one of their directories
+func inPaths(name string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// extractTree writes the files of the tree of the commit under the paths into dir.
+// The symlinks must point inside dir, so the sources never read files outside of the checkout
+func extractTree(r *git.Repository, hash plumbing.Hash, paths []string, dir string) error {
+	commit, err := r.CommitObject(hash)
 	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
+		return err
 	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if !inPaths(f.Name, paths) {
+			return nil
+		}
+		target := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+
+		if f.Mode == filemode.Symlink {
+			link, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			link = filepath.FromSlash(link)
+			if filepath.IsAbs(link) || !isWithin(dir, filepath.Join(filepath.Dir(target), link)) {
+				return fmt.Errorf("symlink %s is outside of the repository", f.Name)
+			}
+			return os.Symlink(link, target)
+		}
+
+		perm := os.FileMode(0o644)
+		if f.Mode == filemode.Executable {
+			perm = 0o755
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, reader); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkSymlinks(dir)
+}
 
-	w, err := r.Worktree()
+// checkSymlinks makes sure the symlinks of dir resolve inside of it. Each link is checked
+// when it is extracted, but the links through other links are only resolved once they all are.
+func checkSymlinks(dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
 	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
+		return err
 	}
 
-	err = w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.ReferenceName(plumbing.NewBranchReferenceName(revision)),
-		Force:  true,
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		resolved, err := filepath.EvalSymlinks(p)
+		if os.IsNotExist(err) {
+			// Dangling, e.g. to a file out of the sparse checkout
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resolved, err = filepath.Abs(resolved)
+		if err != nil {
+			return err
+		}
+		if !isWithin(root, resolved) {
+			rel, _ := filepath.Rel(dir, p)
+			return fmt.Errorf("symlink %s is outside of the repository", filepath.ToSlash(rel))
+		}
+		return nil
 	})
+}
+
+// isWithin returns whether the path is the directory or in it
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func resolveRevision(r *git.Repository, revision string) (plumbing.Hash, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	// Full or short SHA
+	if shaRegexp.MatchString(revision) {
+		if hash, err := r.ResolveRevision(plumbing.Revision(revision)); err == nil {
+			return *hash, nil
+		}
+	}
+
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName(revision),
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, revision),
+	} {
+		if _, err := r.Reference(name, true); err != nil {
+			continue
+		}
+		// Annotated tags are resolved to their commit
+		hash, err := r.ResolveRevision(plumbing.Revision(name))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return *hash, nil
+	}
+
+	constraint, err := semver.NewConstraint(revision)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("no commit, tag or branch matches")
+	}
+	return resolveConstraint(r, constraint)
+}
+
+// resolveConstraint resolves the greatest tag matching the constraint
+func resolveConstraint(r *git.Repository, constraint *semver.Constraints) (plumbing.Hash, error) {
+	tag, err := constraintTag(r, constraint)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(plumbing.NewTagReferenceName(tag)))
 	if err != nil {
-		return "", fmt.Errorf("failed to checkout revision: %w", err)
+		return plumbing.ZeroHash, err
 	}
+	return *hash, nil
+}
 
-	// Git pull
-	err = w.Pull(&git.PullOptions{
-		Force: true,
+// constraintTag returns the name of the greatest tag matching the constraint
+func constraintTag(r *git.Repository, constraint *semver.Constraints) (string, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().Short())
+		return nil
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return "", fmt.Errorf("failed to pull: %w", err)
+	if err != nil {
+		return "", err
+	}
+	tag, ok := latestTag(names, constraint)
+	if !ok {
+		return "", fmt.Errorf("no tag matches the constraint")
+	}
+	return tag, nil
+}
+
+// latestTag returns the tag of the greatest version matching the constraint
+func latestTag(tags []string, constraint *semver.Constraints) (string, bool) {
+	var latest *semver.Version
+	var latestTag string
+	for _, tag := range tags {
+		version, err := semver.NewVersion(tag)
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		if latest == nil || version.GreaterThan(latest) {
+			latest, latestTag = version, tag
+		}
 	}
 
-	// Find current HEAD
-	ref, err := r.Head()
+	return latestTag, latest != nil
+}
+
+// LsRemote resolves the revision like Checkout with the references advertised by the
+// remote, without fetching it. Short SHAs can't be resolved this way.
+func (g *gitClient) LsRemote(url, revision string, creds *Credentials) (string, error) {
+	ctx, auth, done, err := creds.connect(url)
 	if err != nil {
-		return "", fmt.Errorf("failed to get HEAD: %w", err)
+		return "", err
+	}
+	defer done()
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:          auth,
+		PeelingOption: git.AppendPeeled,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote references: %w", err)
+	}
+
+	hash, err := resolveRemoteRevision(refs, revision)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve revision %s: %w", revision, err)
+	}
+	return hash.String(), nil
+}
+
+func resolveRemoteRevision(refs []*plumbing.Reference, revision string) (plumbing.Hash, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	if len(revision) == 40 && shaRegexp.MatchString(revision) {
+		return plumbing.NewHash(revision), nil
+	}
+	if shaRegexp.MatchString(revision) {
+		return plumbing.ZeroHash, fmt.Errorf("short SHAs can't be resolved remotely")
 	}
 
-	return ref.Hash().String(), nil
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference, len(refs))
+	var tags []string
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+		if ref.Name().IsTag() && !strings.HasSuffix(ref.Name().String(), peeledSuffix) {
+			tags = append(tags, ref.Name().Short())
+		}
+	}
+	// Annotated tags are resolved to their commit with their peeled reference
+	lookup := func(name plumbing.ReferenceName) (plumbing.Hash, bool) {
+		if peeled, ok := byName[name+peeledSuffix]; ok {
+			return peeled.Hash(), true
+		}
+		ref, ok := byName[name]
+		if ok && ref.Type() == plumbing.SymbolicReference {
+			ref, ok = byName[ref.Target()]
+		}
+		if !ok {
+			return plumbing.ZeroHash, false
+		}
+		return ref.Hash(), true
+	}
+
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewTagReferenceName(revision),
+		plumbing.NewBranchReferenceName(revision),
+		plumbing.ReferenceName(revision),
+	} {
+		if hash, ok := lookup(name); ok {
+			return hash, nil
+		}
+	}
+
+	constraint, err := semver.NewConstraint(revision)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("no tag or branch matches")
+	}
+	tag, ok := latestTag(tags, constraint)
+	if !ok {
+		return plumbing.ZeroHash, fmt.Errorf("no tag matches the constraint")
+	}
+	hash, _ := lookup(plumbing.NewTagReferenceName(tag))
+	return hash, nil
 }
 
-func (g *gitClient) CleanUp(path string) error {
-	err := os.RemoveAll(path)
+func (g *gitClient) CleanUp(worktree string) error {
+	err := os.RemoveAll(filepath.Join(g.root, worktreesDir, worktree))
 	if err != nil {
-		return fmt.Errorf("failed to clean up repository: %w", err)
+		return fmt.Errorf("failed to clean up worktree: %w", err)
 	}
 
 	return nil
@@ -1,36 +1,37 @@
 package git
 
 import (
+	"fmt"
 	"os"
 	"path"
-	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	testCases = []struct {
 		name        string
-		gitClient   *gitClient
+		creds       *Credentials
 		url         string
 		expectedOut string
 		expectedErr string
 	}{
 		{
-			name: "Clone repository",
-			gitClient: &gitClient{
-				token: "",
-			},
+			name:        "Clone repository",
+			creds:       nil,
 			url:         "https://github.com/minhthong582000/k8s-controller-pattern.git",
 			expectedOut: "",
 			expectedErr: "",
 		},
 		{
-			name: "Clone unexisted repository",
-			gitClient: &gitClient{
-				token: "",
-			},
+			name:        "Clone unexisted repository",
+			creds:       nil,
 			url:         "https://github.com/minhthong582000/unexisted-repository.git",
 			expectedOut: "",
 			expectedErr: "failed to clone repository: authentication required",
@@ -41,26 +42,583 @@ var (
 func TestGitClient_CloneOrFetch_CleanUp(t *testing.T) {
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			path := path.Join(os.TempDir(), strings.Replace(tt.url, "/", "_", -1))
-
-			g := NewGitClient(tt.gitClient.token)
-			err := g.CloneOrFetch(tt.url, path)
+			g := NewGitClient(t.TempDir())
+			err := g.CloneOrFetch(tt.url, tt.creds, nil)
 			if err != nil {
 				assert.Equal(t, tt.expectedErr, err.Error())
 			}
 
 			// Call CloneOrFetch again to see if it fetches the latest changes
-			err = g.CloneOrFetch(tt.url, path)
+			err = g.CloneOrFetch(tt.url, tt.creds, nil)
 			if err != nil {
 				assert.Equal(t, tt.expectedErr, err.Error())
 				return
 			}
-			assert.DirExists(t, path)
+			assert.DirExists(t, g.repositoryPath(tt.url))
+
+			treePath, _, err := g.Checkout(tt.url, "HEAD", "default/app", nil)
+			assert.NoError(t, err)
+			assert.DirExists(t, treePath)
 
 			// Clean up
-			err = g.CleanUp(path)
+			err = g.CleanUp("default/app")
+			assert.NoError(t, err)
+			assert.NoDirExists(t, treePath)
+		})
+	}
+}
+
+func TestGitClient_Checkout(t *testing.T) {
+	origin, commits := newTaggedTestRepository(t)
+	contents := make(map[string]string)
+	for i, content := range []string{"v1.0.0", "v1.2.0", "v1.2.5", "v2.0.0"} {
+		contents[commits[i]] = content
+	}
+
+	var testCases = []struct {
+		name        string
+		revision    string
+		expectedSHA string
+		expectedErr bool
+	}{
+		{
+			name:        "Should check out the head of a branch",
+			revision:    "master",
+			expectedSHA: commits[3],
+		},
+		{
+			name:        "Should check out the head of the default branch",
+			revision:    "HEAD",
+			expectedSHA: commits[3],
+		},
+		{
+			name:        "Should check out a commit",
+			revision:    commits[0],
+			expectedSHA: commits[0],
+		},
+		{
+			name:        "Should check out a commit from its short SHA",
+			revision:    commits[1][:7],
+			expectedSHA: commits[1],
+		},
+		{
+			name:        "Should check out a tag before a branch with the same name",
+			revision:    "v1.0.0",
+			expectedSHA: commits[0],
+		},
+		{
+			name:        "Should check out the commit of an annotated tag",
+			revision:    "v1.2.0",
+			expectedSHA: commits[1],
+		},
+		{
+			name:        "Should check out the greatest tag matching a wildcard",
+			revision:    "v1.2.*",
+			expectedSHA: commits[2],
+		},
+		{
+			name:        "Should check out the greatest tag matching a range",
+			revision:    ">=1.0.0 <2.0.0",
+			expectedSHA: commits[2],
+		},
+		{
+			name:        "Should return error if no tag matches the constraint",
+			revision:    "^3.0.0",
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if the branch doesn't exist",
+			revision:    "unexisted-branch",
+			expectedErr: true,
+		},
+	}
+
+	root := t.TempDir()
+	g := NewGitClient(root)
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			treePath, sha, err := g.Checkout(origin, tt.revision, "default/app", nil)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSHA, sha)
+			assert.Equal(t, path.Join(root, worktreesDir, "default/app", sha), treePath)
+
+			// The tree of the commit is extracted
+			content, err := os.ReadFile(path.Join(treePath, "file.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, contents[sha], string(content))
+		})
+	}
+}
+
+func TestGitClient_CloneOrFetch_Shallow(t *testing.T) {
+	origin, commits := newTestRepository(t, "1", "2", "3", "4", "5", "6", "7", "8")
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v1.0.0", plumbing.NewHash(commits[2]), nil)
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v1.1.0", plumbing.NewHash(commits[4]), nil)
+	assert.NoError(t, err)
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), plumbing.NewHash(commits[5])))
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name            string
+		fetches         []*CloneOptions
+		expectedFound   []string
+		expectedMissing []string
+		expectedShallow bool
+	}{
+		{
+			name:            "Should fetch the last commits of the references",
+			fetches:         []*CloneOptions{{Depth: 2, Revision: "master"}},
+			expectedFound:   []string{"master", "develop", "v1.0.0", commits[6], commits[3], commits[1]},
+			expectedMissing: []string{commits[0]},
+			expectedShallow: true,
+		},
+		{
+			name:            "Should deepen the history until it holds the commit",
+			fetches:         []*CloneOptions{{Depth: 1, Revision: commits[3]}},
+			expectedFound:   []string{commits[3]},
+			expectedMissing: []string{commits[0]},
+			expectedShallow: true,
+		},
+		{
+			name:            "Should fetch the full history of a commit outside of the history of the references",
+			fetches:         []*CloneOptions{{Depth: 1, Revision: "0000000000000000000000000000000000000000"}},
+			expectedFound:   []string{commits[0]},
+			expectedShallow: false,
+		},
+		{
+			name:            "Should only fetch the branch of the revision",
+			fetches:         []*CloneOptions{{Depth: 1, SingleBranch: true, Revision: "develop"}},
+			expectedFound:   []string{"develop"},
+			expectedMissing: []string{"master", "HEAD", "v1.0.0"},
+			expectedShallow: true,
+		},
+		{
+			name:            "Should only fetch the tag of the revision",
+			fetches:         []*CloneOptions{{SingleBranch: true, Revision: "v1.0.0"}},
+			expectedFound:   []string{"v1.0.0", commits[0]},
+			expectedMissing: []string{"master", "v1.1.0", commits[7]},
+		},
+		{
+			name:            "Should only fetch the tags of a semver constraint",
+			fetches:         []*CloneOptions{{SingleBranch: true, Revision: "^1.0.0"}},
+			expectedFound:   []string{"^1.0.0", "v1.0.0"},
+			expectedMissing: []string{"master", commits[7]},
+		},
+		{
+			name:            "Should only fetch HEAD for a commit SHA",
+			fetches:         []*CloneOptions{{Depth: 1, SingleBranch: true, Revision: commits[5]}},
+			expectedFound:   []string{commits[5], "HEAD"},
+			expectedMissing: []string{"master", commits[2]},
+			expectedShallow: true,
+		},
+		{
+			name:            "Should complete the history fetched shallow with a full fetch",
+			fetches:         []*CloneOptions{{Depth: 1, Revision: "master"}, nil},
+			expectedFound:   []string{commits[0], "develop"},
+			expectedShallow: false,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGitClient(t.TempDir())
+			for _, opts := range tt.fetches {
+				assert.NoError(t, g.CloneOrFetch(origin, nil, opts))
+			}
+
+			for _, revision := range tt.expectedFound {
+				_, _, err := g.Checkout(origin, revision, "default/app", nil)
+				assert.NoError(t, err, revision)
+			}
+			for _, revision := range tt.expectedMissing {
+				_, _, err := g.Checkout(origin, revision, "default/app", nil)
+				assert.Error(t, err, revision)
+			}
+			r, err := git.PlainOpen(g.repositoryPath(origin))
 			assert.NoError(t, err)
-			assert.NoDirExists(t, path)
+			assert.Equal(t, tt.expectedShallow, isShallow(r))
 		})
 	}
 }
+
+func TestGitClient_Checkout_Sparse(t *testing.T) {
+	origin, _ := newTestRepository(t, "content")
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	for _, file := range []string{"apps/app/deployment.yaml", "apps/application/deployment.yaml", "base/kustomization.yaml", "base/service.yaml"} {
+		assert.NoError(t, os.MkdirAll(path.Join(origin, path.Dir(file)), 0o755))
+		assert.NoError(t, os.WriteFile(path.Join(origin, file), []byte(file), 0o644))
+		_, err = w.Add(file)
+		assert.NoError(t, err)
+	}
+	_, err = w.Commit("files", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	g := NewGitClient(t.TempDir())
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+
+	// Only the paths are checked out
+	sparsePath, sha, err := g.Checkout(origin, "HEAD", "default/app", []string{"./apps/app/", "/base/kustomization.yaml"})
+	assert.NoError(t, err)
+	assert.FileExists(t, path.Join(sparsePath, "apps/app/deployment.yaml"))
+	assert.FileExists(t, path.Join(sparsePath, "base/kustomization.yaml"))
+	assert.NoFileExists(t, path.Join(sparsePath, "apps/application/deployment.yaml"))
+	assert.NoFileExists(t, path.Join(sparsePath, "base/service.yaml"))
+	assert.NoFileExists(t, path.Join(sparsePath, "file.txt"))
+
+	// The whole tree replaces the sparse tree of the same commit
+	treePath, _, err := g.Checkout(origin, "HEAD", "default/app", []string{"apps/app", "."})
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(g.root, worktreesDir, "default/app", sha), treePath)
+	assert.FileExists(t, path.Join(treePath, "base/service.yaml"))
+	assert.NoDirExists(t, sparsePath)
+}
+
+func TestGitClient_CommitInfo(t *testing.T) {
+	origin, commits := newTaggedTestRepository(t)
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	_, err = r.CreateTag("latest", plumbing.NewHash(commits[3]), nil)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		sha             string
+		expectedSubject string
+		expectedTags    []string
+		expectedErr     bool
+	}{
+		{
+			name:            "Should list the tags of the commit, sorted",
+			sha:             commits[3],
+			expectedSubject: "v2.0.0",
+			expectedTags:    []string{"latest", "v2.0.0"},
+		},
+		{
+			name:            "Should list the annotated tags of the commit",
+			sha:             commits[1],
+			expectedSubject: "v1.2.0",
+			expectedTags:    []string{"v1.2.0"},
+		},
+		{
+			name:        "Should return error if the commit doesn't exist",
+			sha:         "0000000000000000000000000000000000000001",
+			expectedErr: true,
+		},
+	}
+
+	g := NewGitClient(t.TempDir())
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := g.CommitInfo(origin, tt.sha)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "test <test@example.com>", info.Author)
+			assert.Equal(t, "test <test@example.com>", info.Committer)
+			assert.False(t, info.Date.IsZero())
+			assert.Equal(t, tt.expectedSubject, info.Subject)
+			assert.Equal(t, tt.expectedTags, info.Tags)
+		})
+	}
+}
+
+func TestGitClient_LsRemote(t *testing.T) {
+	origin, commits := newTaggedTestRepository(t)
+
+	var testCases = []struct {
+		name        string
+		revision    string
+		expectedSHA string
+		expectedErr bool
+	}{
+		{
+			name:        "Should resolve the head of a branch",
+			revision:    "master",
+			expectedSHA: commits[3],
+		},
+		{
+			name:        "Should resolve the head of the default branch",
+			revision:    "",
+			expectedSHA: commits[3],
+		},
+		{
+			name:        "Should resolve a commit",
+			revision:    commits[0],
+			expectedSHA: commits[0],
+		},
+		{
+			name:        "Should resolve a tag before a branch with the same name",
+			revision:    "v1.0.0",
+			expectedSHA: commits[0],
+		},
+		{
+			name:        "Should resolve the commit of an annotated tag",
+			revision:    "v1.2.0",
+			expectedSHA: commits[1],
+		},
+		{
+			name:        "Should resolve the greatest tag matching a range",
+			revision:    ">=1.0.0 <2.0.0",
+			expectedSHA: commits[2],
+		},
+		{
+			name:        "Should resolve the commit of the greatest annotated tag matching a constraint",
+			revision:    "~1.2.0, <1.2.5",
+			expectedSHA: commits[1],
+		},
+		{
+			name:        "Should return error for a short SHA",
+			revision:    commits[1][:7],
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if no tag matches the constraint",
+			revision:    "^3.0.0",
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if the branch doesn't exist",
+			revision:    "unexisted-branch",
+			expectedErr: true,
+		},
+	}
+
+	g := NewGitClient(t.TempDir())
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			sha, err := g.LsRemote(origin, tt.revision, nil)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSHA, sha)
+		})
+	}
+
+	// Nothing is fetched
+	assert.NoDirExists(t, g.repositoryPath(origin))
+}
+
+func TestGitClient_Checkout_NoPull(t *testing.T) {
+	origin, commits := newTestRepository(t, "first")
+	g := NewGitClient(t.TempDir())
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+
+	// The new commit is only checked out after a fetch
+	_, newCommits := addTestCommits(t, origin, "second")
+	oldTreePath, sha, err := g.Checkout(origin, "master", "default/app", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, commits[0], sha)
+
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+	treePath, sha, err := g.Checkout(origin, "master", "default/app", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, newCommits[0], sha)
+
+	content, err := os.ReadFile(path.Join(treePath, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	// The tree of the previous revision is removed
+	assert.NoDirExists(t, oldTreePath)
+}
+
+func TestGitClient_Checkout_Files(t *testing.T) {
+	origin, _ := newTestRepository(t, "content")
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(path.Join(origin, "bin"), 0o755))
+	assert.NoError(t, os.WriteFile(path.Join(origin, "bin", "script.sh"), []byte("#!/bin/sh\n"), 0o755))
+	assert.NoError(t, os.Symlink("../file.txt", path.Join(origin, "bin", "link.txt")))
+	_, err = w.Add("bin")
+	assert.NoError(t, err)
+	_, err = w.Commit("files", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	g := NewGitClient(t.TempDir())
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+	treePath, _, err := g.Checkout(origin, "HEAD", "default/app", nil)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path.Join(treePath, "bin", "script.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+	link, err := os.Readlink(path.Join(treePath, "bin", "link.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../file.txt", link)
+	content, err := os.ReadFile(path.Join(treePath, "bin", "link.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestGitClient_Checkout_Symlinks(t *testing.T) {
+	testCases := []struct {
+		name        string
+		links       map[string]string
+		expectedErr string
+	}{
+		{
+			name:  "Should extract the symlinks inside of the repository",
+			links: map[string]string{"manifests/link.txt": "../file.txt"},
+		},
+		{
+			name:        "Should reject the absolute symlinks",
+			links:       map[string]string{"manifests/token": "/var/run/secrets/kubernetes.io/serviceaccount/token"},
+			expectedErr: "failed to checkout revision: symlink manifests/token is outside of the repository",
+		},
+		{
+			name:        "Should reject the symlinks escaping the repository",
+			links:       map[string]string{"manifests/other.yaml": "../../other-app/secret.yaml"},
+			expectedErr: "failed to checkout revision: symlink manifests/other.yaml is outside of the repository",
+		},
+		{
+			name: "Should reject the symlinks escaping the repository through other symlinks",
+			links: map[string]string{
+				"manifests/root":   "..",
+				"manifests/parent": "root/..",
+			},
+			expectedErr: "failed to checkout revision: symlink manifests/parent is outside of the repository",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			origin, _ := newTestRepository(t, "content")
+			r, err := git.PlainOpen(origin)
+			assert.NoError(t, err)
+			w, err := r.Worktree()
+			assert.NoError(t, err)
+			assert.NoError(t, os.MkdirAll(path.Join(origin, "manifests"), 0o755))
+			for name, link := range tt.links {
+				assert.NoError(t, os.Symlink(link, path.Join(origin, name)))
+			}
+			_, err = w.Add("manifests")
+			assert.NoError(t, err)
+			_, err = w.Commit("links", &git.CommitOptions{
+				Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+			})
+			assert.NoError(t, err)
+
+			g := NewGitClient(t.TempDir())
+			assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+			_, _, err = g.Checkout(origin, "HEAD", "default/app", nil)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGitClient_Concurrent(t *testing.T) {
+	origin, commits := newTestRepository(t, "first")
+	g := NewGitClient(t.TempDir())
+
+	// The applications of a repository share its cache
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+			treePath, sha, err := g.Checkout(origin, "master", fmt.Sprintf("default/app-%d", i), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, commits[0], sha)
+			assert.FileExists(t, path.Join(treePath, "file.txt"))
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(path.Join(g.root, repositoriesDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestGitClient_RepositoryPath(t *testing.T) {
+	g := NewGitClient("/workspace")
+
+	assert.Equal(t, g.repositoryPath("https://github.com/org/repo.git"), g.repositoryPath("https://github.com/org/repo"))
+	assert.Equal(t, g.repositoryPath("https://github.com/org/repo"), g.repositoryPath("https://github.com/org/repo/"))
+	assert.NotEqual(t, g.repositoryPath("https://github.com/org/a_b"), g.repositoryPath("https://github.com/org/a/b"))
+	assert.Regexp(t, `^/workspace/repositories/https_github.com_org_repo-[0-9a-f]{8}$`, g.repositoryPath("https://github.com/org/repo.git"))
+}
+
+// newTaggedTestRepository creates a repository with the commits v1.0.0, v1.2.0 (annotated),
+// v1.2.5 and v2.0.0 tagged, and a branch v1.0.0 at the last commit
+func newTaggedTestRepository(t *testing.T) (string, []string) {
+	origin, commits := newTestRepository(t, "v1.0.0", "v1.2.0", "v1.2.5", "v2.0.0")
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v1.0.0", plumbing.NewHash(commits[0]), nil)
+	assert.NoError(t, err)
+	// Annotated tag
+	_, err = r.CreateTag("v1.2.0", plumbing.NewHash(commits[1]), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		Message: "v1.2.0",
+	})
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v1.2.5", plumbing.NewHash(commits[2]), nil)
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v2.0.0", plumbing.NewHash(commits[3]), nil)
+	assert.NoError(t, err)
+	// A branch with the name of a tag
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1.0.0"), plumbing.NewHash(commits[3])))
+	assert.NoError(t, err)
+
+	return origin, commits
+}
+
+// newTestRepository creates a repository with a commit on master for each content
+// of file.txt, and returns its path and the commits
+func newTestRepository(t *testing.T, contents ...string) (string, []string) {
+	repoPath := t.TempDir()
+	_, err := git.PlainInit(repoPath, false)
+	assert.NoError(t, err)
+
+	return addTestCommits(t, repoPath, contents...)
+}
+
+func addTestCommits(t *testing.T, repoPath string, contents ...string) (string, []string) {
+	r, err := git.PlainOpen(repoPath)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	var commits []string
+	for _, content := range contents {
+		assert.NoError(t, os.WriteFile(path.Join(repoPath, "file.txt"), []byte(content), 0o644))
+		_, err = w.Add("file.txt")
+		assert.NoError(t, err)
+		hash, err := w.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		assert.NoError(t, err)
+		commits = append(commits, hash.String())
+	}
+
+	return repoPath, commits
+}
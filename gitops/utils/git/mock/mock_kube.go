@@ -12,6 +12,8 @@ package mock
 import (
 	reflect "reflect"
 
+	openpgp "github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/git"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -39,18 +41,19 @@ func (m *MockGitClient) EXPECT() *MockGitClientMockRecorder {
 }
 
 // Checkout mocks base method.
-func (m *MockGitClient) Checkout(arg0, arg1 string) (string, error) {
+func (m *MockGitClient) Checkout(arg0, arg1, arg2 string, arg3 []string) (string, string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Checkout", arg0, arg1)
+	ret := m.ctrl.Call(m, "Checkout", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // Checkout indicates an expected call of Checkout.
-func (mr *MockGitClientMockRecorder) Checkout(arg0, arg1 any) *gomock.Call {
+func (mr *MockGitClientMockRecorder) Checkout(arg0, arg1, arg2, arg3 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Checkout", reflect.TypeOf((*MockGitClient)(nil).Checkout), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Checkout", reflect.TypeOf((*MockGitClient)(nil).Checkout), arg0, arg1, arg2, arg3)
 }
 
 // CleanUp mocks base method.
@@ -68,15 +71,60 @@ func (mr *MockGitClientMockRecorder) CleanUp(arg0 any) *gomock.Call {
 }
 
 // CloneOrFetch mocks base method.
-func (m *MockGitClient) CloneOrFetch(arg0, arg1 string) error {
+func (m *MockGitClient) CloneOrFetch(arg0 string, arg1 *git.Credentials, arg2 *git.CloneOptions) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CloneOrFetch", arg0, arg1)
+	ret := m.ctrl.Call(m, "CloneOrFetch", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CloneOrFetch indicates an expected call of CloneOrFetch.
-func (mr *MockGitClientMockRecorder) CloneOrFetch(arg0, arg1 any) *gomock.Call {
+func (mr *MockGitClientMockRecorder) CloneOrFetch(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloneOrFetch", reflect.TypeOf((*MockGitClient)(nil).CloneOrFetch), arg0, arg1, arg2)
+}
+
+// CommitInfo mocks base method.
+func (m *MockGitClient) CommitInfo(arg0, arg1 string) (*git.CommitInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitInfo", arg0, arg1)
+	ret0, _ := ret[0].(*git.CommitInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitInfo indicates an expected call of CommitInfo.
+func (mr *MockGitClientMockRecorder) CommitInfo(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitInfo", reflect.TypeOf((*MockGitClient)(nil).CommitInfo), arg0, arg1)
+}
+
+// LsRemote mocks base method.
+func (m *MockGitClient) LsRemote(arg0, arg1 string, arg2 *git.Credentials) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LsRemote", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LsRemote indicates an expected call of LsRemote.
+func (mr *MockGitClientMockRecorder) LsRemote(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LsRemote", reflect.TypeOf((*MockGitClient)(nil).LsRemote), arg0, arg1, arg2)
+}
+
+// VerifySignature mocks base method.
+func (m *MockGitClient) VerifySignature(arg0, arg1, arg2 string, arg3 openpgp.EntityList) (*git.Signature, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifySignature", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*git.Signature)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifySignature indicates an expected call of VerifySignature.
+func (mr *MockGitClientMockRecorder) VerifySignature(arg0, arg1, arg2, arg3 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloneOrFetch", reflect.TypeOf((*MockGitClient)(nil).CloneOrFetch), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifySignature", reflect.TypeOf((*MockGitClient)(nil).VerifySignature), arg0, arg1, arg2, arg3)
 }
@@ -0,0 +1,184 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrUnsigned is returned if neither the commit nor the annotated tag of a revision is signed
+var ErrUnsigned = errors.New("not signed")
+
+// Signature is a verified OpenPGP signature of a commit or an annotated tag
+type Signature struct {
+	// KeyID is the long ID of the key that made the signature, e.g. a signing subkey
+	KeyID string
+	// PrimaryKeyID is the long ID of the primary key of KeyID
+	PrimaryKeyID string
+	// Signer is the identity of the primary key, e.g. Jane Doe <jane@example.com>
+	Signer string
+}
+
+// SignedBy returns whether the signature was made by one of the keys, given as long or short
+// IDs or as fingerprints. The subkeys of a primary key are allowed with it.
+func (s *Signature) SignedBy(keyIDs []string) bool {
+	for _, keyID := range keyIDs {
+		keyID = strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(keyID, "0x"), " ", ""))
+		if len(keyID) < 8 {
+			continue
+		}
+		// The long ID is the end of the fingerprint
+		if len(keyID) > 16 {
+			keyID = keyID[len(keyID)-16:]
+		}
+		if strings.HasSuffix(s.KeyID, keyID) || strings.HasSuffix(s.PrimaryKeyID, keyID) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewKeyRing reads the armored public keys, e.g. the data of a ConfigMap. The keys that
+// can't be read are left out of the keyring and returned as an error.
+func NewKeyRing(armoredKeys map[string]string) (openpgp.EntityList, error) {
+	names := make([]string, 0, len(armoredKeys))
+	for name := range armoredKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var keyRing openpgp.EntityList
+	var errs []error
+	for _, name := range names {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKeys[name]))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read key %s: %w", name, err))
+			continue
+		}
+		keyRing = append(keyRing, entities...)
+	}
+
+	return keyRing, errors.Join(errs...)
+}
+
+// VerifySignature verifies the signature of the annotated tag of the revision if it is signed
+// and points to the commit, or else the signature of the commit
+func (g *gitClient) VerifySignature(url, revision, sha string, keyRing openpgp.EntityList) (*Signature, error) {
+	repoPath := g.repositoryPath(url)
+	lock := g.repoLock(repoPath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	commit, err := r.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", sha, err)
+	}
+
+	if tag := signedTag(r, revision, commit.Hash); tag != nil {
+		encoded := &plumbing.MemoryObject{}
+		if err := tag.EncodeWithoutSignature(encoded); err != nil {
+			return nil, err
+		}
+		signature, err := verifySignature(keyRing, encoded, tag.PGPSignature)
+		if err != nil {
+			return nil, fmt.Errorf("tag %s is %w", tag.Name, err)
+		}
+		return signature, nil
+	}
+
+	if commit.PGPSignature == "" {
+		return nil, fmt.Errorf("commit %s is %w", sha, ErrUnsigned)
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return nil, err
+	}
+	signature, err := verifySignature(keyRing, encoded, commit.PGPSignature)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s is %w", sha, err)
+	}
+	return signature, nil
+}
+
+// signedTag returns the signed annotated tag the revision resolves to, nil if the revision
+// is not a tag, a lightweight or unsigned tag, or a tag of another commit
+func signedTag(r *git.Repository, revision string, hash plumbing.Hash) *object.Tag {
+	name := revision
+	if _, err := r.Reference(plumbing.NewTagReferenceName(name), true); err != nil {
+		constraint, err := semver.NewConstraint(revision)
+		if err != nil {
+			return nil
+		}
+		if name, err = constraintTag(r, constraint); err != nil {
+			return nil
+		}
+	}
+
+	ref, err := r.Reference(plumbing.NewTagReferenceName(name), true)
+	if err != nil {
+		return nil
+	}
+	tag, err := r.TagObject(ref.Hash())
+	if err != nil || tag.PGPSignature == "" || tag.Target != hash {
+		return nil
+	}
+	return tag
+}
+
+// verifySignature checks the armored detached signature of the object with the keyring
+func verifySignature(keyRing openpgp.EntityList, encoded plumbing.EncodedObject, armoredSignature string) (*Signature, error) {
+	keyID := issuerKeyID(armoredSignature)
+
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	entity, err := openpgp.CheckArmoredDetachedSignature(keyRing, reader, strings.NewReader(armoredSignature), nil)
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		return nil, fmt.Errorf("signed with key %s which is not in the keyring", keyID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signed with key %s but the signature is invalid: %w", keyID, err)
+	}
+
+	signature := &Signature{
+		KeyID:        keyID,
+		PrimaryKeyID: entity.PrimaryKey.KeyIdString(),
+	}
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		signature.Signer = identity.Name
+	}
+	return signature, nil
+}
+
+// issuerKeyID returns the long ID of the key that made the signature, unknown if it can't be read
+func issuerKeyID(armoredSignature string) string {
+	block, err := armor.Decode(strings.NewReader(armoredSignature))
+	if err != nil {
+		return "unknown"
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return "unknown"
+	}
+	signature, ok := p.(*packet.Signature)
+	if !ok || signature.IssuerKeyId == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%016X", *signature.IssuerKeyId)
+}
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKey(t *testing.T, name string) (*openpgp.Entity, string) {
+	key, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	assert.NoError(t, err)
+
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, key.Serialize(w))
+	assert.NoError(t, w.Close())
+
+	return key, armored.String()
+}
+
+func addSignedTestCommit(t *testing.T, repoPath, content string, key *openpgp.Entity) string {
+	r, err := git.PlainOpen(repoPath)
+	assert.NoError(t, err)
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(path.Join(repoPath, "file.txt"), []byte(content), 0o644))
+	_, err = w.Add("file.txt")
+	assert.NoError(t, err)
+	hash, err := w.Commit(content, &git.CommitOptions{
+		Author:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		SignKey: key,
+	})
+	assert.NoError(t, err)
+
+	return hash.String()
+}
+
+func TestGitClient_VerifySignature(t *testing.T) {
+	trusted, trustedArmored := newTestKey(t, "trusted")
+	untrusted, _ := newTestKey(t, "untrusted")
+	keyRing, err := NewKeyRing(map[string]string{"trusted.asc": trustedArmored})
+	assert.NoError(t, err)
+
+	origin, commits := newTestRepository(t, "unsigned")
+	signed := addSignedTestCommit(t, origin, "signed", trusted)
+	addSignedTestCommit(t, origin, "untrusted", untrusted)
+	r, err := git.PlainOpen(origin)
+	assert.NoError(t, err)
+	// A signed annotated tag and a lightweight tag of the unsigned commit
+	_, err = r.CreateTag("v1.0.0", plumbing.NewHash(commits[0]), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		Message: "v1.0.0",
+		SignKey: trusted,
+	})
+	assert.NoError(t, err)
+	_, err = r.CreateTag("v1.1.0", plumbing.NewHash(commits[0]), nil)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		revision      string
+		expectedKeyID string
+		expectedErr   string
+	}{
+		{
+			name:          "Should verify the signature of the commit",
+			revision:      signed,
+			expectedKeyID: trusted.PrimaryKey.KeyIdString(),
+		},
+		{
+			name:          "Should verify the signature of the annotated tag",
+			revision:      "v1.0.0",
+			expectedKeyID: trusted.PrimaryKey.KeyIdString(),
+		},
+		{
+			name:          "Should verify the signature of the tag matching the constraint",
+			revision:      "~1.0.0",
+			expectedKeyID: trusted.PrimaryKey.KeyIdString(),
+		},
+		{
+			name:        "Should return error if neither the tag nor the commit is signed",
+			revision:    "v1.1.0",
+			expectedErr: "commit " + commits[0] + " is not signed",
+		},
+		{
+			name:        "Should return error if the key is not in the keyring",
+			revision:    "master",
+			expectedErr: "signed with key " + untrusted.PrimaryKey.KeyIdString() + " which is not in the keyring",
+		},
+	}
+
+	g := NewGitClient(t.TempDir())
+	assert.NoError(t, g.CloneOrFetch(origin, nil, nil))
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, sha, err := g.Checkout(origin, tt.revision, "default/app", nil)
+			assert.NoError(t, err)
+
+			signature, err := g.VerifySignature(origin, tt.revision, sha, keyRing)
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedKeyID, signature.KeyID)
+			assert.Equal(t, tt.expectedKeyID, signature.PrimaryKeyID)
+			assert.Equal(t, "trusted <trusted@example.com>", signature.Signer)
+		})
+	}
+}
+
+func TestNewKeyRing(t *testing.T) {
+	_, armored := newTestKey(t, "trusted")
+
+	keyRing, err := NewKeyRing(map[string]string{"trusted.asc": armored, "invalid.asc": "invalid"})
+	assert.ErrorContains(t, err, "failed to read key invalid.asc")
+	assert.Len(t, keyRing, 1)
+}
+
+func TestSignature_SignedBy(t *testing.T) {
+	signature := &Signature{KeyID: "1A2B3C4D5E6F7A8B", PrimaryKeyID: "0011223344556677"}
+
+	testCases := []struct {
+		name     string
+		keyIDs   []string
+		expected bool
+	}{
+		{name: "Should match the long ID of the key", keyIDs: []string{"1a2b3c4d5e6f7a8b"}, expected: true},
+		{name: "Should match the long ID of the primary key", keyIDs: []string{"0x0011223344556677"}, expected: true},
+		{name: "Should match the short ID", keyIDs: []string{"5E6F7A8B"}, expected: true},
+		{name: "Should match the fingerprint", keyIDs: []string{"AAAA BBBB CCCC DDDD EEEE FFFF 0011 2233 4455 6677"}, expected: true},
+		{name: "Should not match other keys", keyIDs: []string{"FFFFFFFFFFFFFFFF", "7A8B"}, expected: false},
+		{name: "Should not match without keys", expected: false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, signature.SignedBy(tt.keyIDs))
+		})
+	}
+}
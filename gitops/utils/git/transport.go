@@ -0,0 +1,88 @@
+package git
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"golang.org/x/net/http/httpproxy"
+)
+
+type transportKey struct{}
+
+func init() {
+	// go-git can't set client certificates per repository, so the HTTP requests are
+	// sent with the transport of the repository set in their context
+	c := githttp.NewClient(&http.Client{Transport: &contextTransport{}})
+	client.InstallProtocol("http", c)
+	client.InstallProtocol("https", c)
+}
+
+// contextTransport sends the requests with the transport of their context,
+// or else the default transport
+type contextTransport struct{}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := req.Context().Value(transportKey{}).(http.RoundTripper); ok {
+		return transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// withTransport returns a context whose HTTP requests are sent with the transport
+func withTransport(ctx context.Context, transport http.RoundTripper) context.Context {
+	return context.WithValue(ctx, transportKey{}, transport)
+}
+
+// httpTransport returns the transport with the TLS and proxy settings of the repository,
+// nil if it has none
+func (c *Credentials) httpTransport() (*http.Transport, error) {
+	if c == nil || (len(c.CABundle) == 0 && len(c.TLSClientCert) == 0 && len(c.TLSClientKey) == 0 && !c.Insecure && c.Proxy == "") {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if len(c.CABundle) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(c.CABundle) {
+			return nil, fmt.Errorf("failed to parse CA bundle, no PEM certificate found")
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+
+	if len(c.TLSClientCert) > 0 || len(c.TLSClientKey) > 0 {
+		cert, err := tls.X509KeyPair(c.TLSClientCert, c.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse TLS client certificate: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.Proxy != "" {
+		if _, err := url.Parse(c.Proxy); err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  c.Proxy,
+			HTTPSProxy: c.Proxy,
+			NoProxy:    c.NoProxy,
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+
+	return transport, nil
+}
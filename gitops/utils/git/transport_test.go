@@ -0,0 +1,169 @@
+package git
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCertificate returns a self-signed client certificate and its key, PEM encoded
+func newTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestCredentials_HTTPTransport(t *testing.T) {
+	clientCert, clientKey := newTestCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCert)
+
+	// The server tells whether it received a client certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client-Certificate", "true")
+		}
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := []struct {
+		name        string
+		creds       *Credentials
+		expectedNil bool
+		expectedErr bool
+		expectedTLS bool
+	}{
+		{
+			name:        "Should use the default transport without settings",
+			creds:       &Credentials{Username: "user", Password: "password"},
+			expectedNil: true,
+		},
+		{
+			name:        "Should trust the CA bundle",
+			creds:       &Credentials{CABundle: serverCA},
+			expectedTLS: true,
+		},
+		{
+			name:        "Should send the client certificate",
+			creds:       &Credentials{CABundle: serverCA, TLSClientCert: clientCert, TLSClientKey: clientKey},
+			expectedTLS: true,
+		},
+		{
+			name:        "Should skip the verification of the server certificate",
+			creds:       &Credentials{Insecure: true},
+			expectedTLS: true,
+		},
+		{
+			name:        "Should reject the unknown server certificate",
+			creds:       &Credentials{Proxy: "http://proxy.example.com:3128", NoProxy: "127.0.0.1"},
+			expectedTLS: false,
+		},
+		{
+			name:        "Should return error if the CA bundle is invalid",
+			creds:       &Credentials{CABundle: []byte("invalid")},
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if the client certificate is invalid",
+			creds:       &Credentials{TLSClientCert: clientCert},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := tt.creds.httpTransport()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.expectedNil {
+				assert.Nil(t, transport)
+				return
+			}
+
+			// The requests are sent with the transport of their context
+			req, err := http.NewRequestWithContext(withTransport(context.Background(), transport), http.MethodGet, server.URL, nil)
+			assert.NoError(t, err)
+			res, err := (&contextTransport{}).RoundTrip(req)
+			if !tt.expectedTLS {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, len(tt.creds.TLSClientCert) > 0, res.Header.Get("X-Client-Certificate") == "true")
+		})
+	}
+}
+
+func TestCredentials_HTTPTransport_Proxy(t *testing.T) {
+	creds := &Credentials{Proxy: "http://proxy.example.com:3128", NoProxy: "internal.example.com,10.0.0.0/8"}
+	transport, err := creds.httpTransport()
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		url           string
+		expectedProxy string
+	}{
+		{url: "https://github.com/org/repo.git", expectedProxy: "http://proxy.example.com:3128"},
+		{url: "http://github.com/org/repo.git", expectedProxy: "http://proxy.example.com:3128"},
+		{url: "https://git.internal.example.com/org/repo.git"},
+		{url: "https://10.1.2.3/org/repo.git"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			assert.NoError(t, err)
+			proxy, err := transport.Proxy(req)
+			assert.NoError(t, err)
+			if tt.expectedProxy == "" {
+				assert.Nil(t, proxy)
+				return
+			}
+			assert.Equal(t, tt.expectedProxy, proxy.String())
+		})
+	}
+}
+
+func TestGitClient_CloneOrFetch_InvalidTLS(t *testing.T) {
+	g := NewGitClient(t.TempDir())
+	err := g.CloneOrFetch("https://github.com/org/repo.git", &Credentials{CABundle: []byte("invalid")}, nil)
+	assert.ErrorContains(t, err, "failed to parse CA bundle")
+	assert.NoDirExists(t, g.repositoryPath("https://github.com/org/repo.git"))
+}
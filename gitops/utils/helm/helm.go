@@ -0,0 +1,161 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	k8sutil "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+)
+
+type Helm interface {
+	Template(chartPath string, opts *TemplateOptions) ([]*unstructured.Unstructured, error)
+}
+
+type TemplateOptions struct {
+	ReleaseName string
+	Namespace   string
+	// ValueFiles are paths relative to the chart directory
+	ValueFiles []string
+	// RootPath is the checkout of the repository holding the value files, those outside of
+	// it are rejected. Defaults to the chart directory
+	RootPath string
+	// Values is a YAML document overriding the values from ValueFiles
+	Values string
+}
+
+type helm struct {
+	discoveryClient discovery.DiscoveryInterface
+}
+
+func NewHelm(discoveryClient discovery.DiscoveryInterface) *helm {
+	return &helm{
+		discoveryClient: discoveryClient,
+	}
+}
+
+// IsChart returns whether the directory contains a Helm chart
+func IsChart(path string) bool {
+	_, err := os.Stat(filepath.Join(path, chartutil.ChartfileName))
+	return err == nil
+}
+
+// Template renders a chart the same way `helm template --include-crds` does.
+// Hooks are not rendered since the controller does not run them.
+func (h *helm) Template(chartPath string, opts *TemplateOptions) ([]*unstructured.Unstructured, error) {
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	vals, err := mergeValues(chartPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	install := action.NewInstall(&action.Configuration{
+		Log: log.Debugf,
+	})
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+	install.ReleaseName = opts.ReleaseName
+	install.Namespace = opts.Namespace
+
+	// Fill .Capabilities from the target cluster
+	if h.discoveryClient != nil {
+		serverVersion, err := h.discoveryClient.ServerVersion()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server version: %w", err)
+		}
+		install.KubeVersion, err = chartutil.ParseKubeVersion(serverVersion.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse server version: %w", err)
+		}
+
+		install.APIVersions, err = action.GetVersionSet(h.discoveryClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get api versions: %w", err)
+		}
+	}
+
+	release, err := install.Run(chart, vals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render chart: %w", err)
+	}
+
+	objs, err := k8sutil.DecodeManifests([]byte(release.Manifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rendered chart: %w", err)
+	}
+
+	return objs, nil
+}
+
+func mergeValues(chartPath string, opts *TemplateOptions) (map[string]interface{}, error) {
+	valueOpts := &values.Options{}
+	rootPath := opts.RootPath
+	if rootPath == "" {
+		rootPath = chartPath
+	}
+	for _, f := range opts.ValueFiles {
+		valueFile, err := resolveValueFile(rootPath, chartPath, f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values files: %w", err)
+		}
+		valueOpts.ValueFiles = append(valueOpts.ValueFiles, valueFile)
+	}
+	vals, err := valueOpts.MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values files: %w", err)
+	}
+
+	if opts.Values == "" {
+		return vals, nil
+	}
+
+	inlineVals := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(opts.Values), &inlineVals); err != nil {
+		return nil, fmt.Errorf("failed to parse values: %w", err)
+	}
+
+	return chartutil.CoalesceTables(inlineVals, vals), nil
+}
+
+// resolveValueFile returns the path of the values file relative to the chart, with its
+// symlinks resolved. It must be in the root directory.
+func resolveValueFile(rootPath, chartPath, file string) (string, error) {
+	root, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return "", err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	valueFile, err := filepath.EvalSymlinks(filepath.Join(chartPath, file))
+	if err != nil {
+		return "", err
+	}
+	valueFile, err = filepath.Abs(valueFile)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, valueFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("values file %s is outside of the repository", file)
+	}
+	return valueFile, nil
+}
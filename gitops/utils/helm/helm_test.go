@@ -0,0 +1,153 @@
+package helm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newFakeDiscovery() discovery.DiscoveryInterface {
+	disco := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	disco.FakedServerVersion = &version.Info{
+		Major:      "1",
+		Minor:      "30",
+		GitVersion: "v1.30.2",
+	}
+	disco.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "networking.k8s.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "ingresses", Kind: "Ingress", Namespaced: true},
+			},
+		},
+	}
+	return disco
+}
+
+func Test_IsChart(t *testing.T) {
+	assert.True(t, IsChart(filepath.Join(".", "testdata", "nginx")))
+	assert.False(t, IsChart(filepath.Join(".", "testdata")))
+}
+
+func Test_Template(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		chartPath   string
+		opts        *TemplateOptions
+		validate    func(t *testing.T, objs []*unstructured.Unstructured)
+		expectedErr string
+	}{
+		{
+			name:      "Should render the chart with its default values and CRDs",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				Namespace:   "default",
+			},
+			validate: func(t *testing.T, objs []*unstructured.Unstructured) {
+				assert.Len(t, objs, 2)
+				assert.Equal(t, "CustomResourceDefinition", objs[0].GetKind())
+				assert.Equal(t, "Deployment", objs[1].GetKind())
+				assert.Equal(t, "my-nginx", objs[1].GetName())
+				assert.Equal(t, "default", objs[1].GetNamespace())
+				assert.Equal(t, "v1.30.2", objs[1].GetAnnotations()["kube-version"])
+
+				replicas, _, _ := unstructured.NestedInt64(objs[1].Object, "spec", "replicas")
+				assert.Equal(t, int64(1), replicas)
+			},
+		},
+		{
+			name:      "Should render the chart with the values files and inline values",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				Namespace:   "production",
+				ValueFiles:  []string{"values/production.yaml"},
+				Values:      "image:\n  tag: \"1.27\"\n",
+			},
+			validate: func(t *testing.T, objs []*unstructured.Unstructured) {
+				assert.Len(t, objs, 3)
+				assert.Equal(t, "Deployment", objs[1].GetKind())
+
+				replicas, _, _ := unstructured.NestedInt64(objs[1].Object, "spec", "replicas")
+				assert.Equal(t, int64(3), replicas)
+				containers, _, _ := unstructured.NestedSlice(objs[1].Object, "spec", "template", "spec", "containers")
+				assert.Equal(t, "nginx:1.27", containers[0].(map[string]interface{})["image"])
+
+				// The Ingress API version is picked from the cluster capabilities
+				assert.Equal(t, "Ingress", objs[2].GetKind())
+				assert.Equal(t, "networking.k8s.io/v1", objs[2].GetAPIVersion())
+			},
+		},
+		{
+			name:      "Should return error when a values file does not exist",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				ValueFiles:  []string{"values/unknown.yaml"},
+			},
+			expectedErr: "failed to read values files",
+		},
+		{
+			name:      "Should read a values file outside of the chart in the repository",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				ValueFiles:  []string{"../values-shared.yaml"},
+				RootPath:    filepath.Join(".", "testdata"),
+			},
+			validate: func(t *testing.T, objs []*unstructured.Unstructured) {
+				replicas, _, _ := unstructured.NestedInt64(objs[1].Object, "spec", "replicas")
+				assert.Equal(t, int64(2), replicas)
+			},
+		},
+		{
+			name:      "Should return error when a values file is outside of the repository",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				ValueFiles:  []string{"../../helm.go"},
+				RootPath:    filepath.Join(".", "testdata"),
+			},
+			expectedErr: "values file ../../helm.go is outside of the repository",
+		},
+		{
+			name:      "Should return error when a values file is outside of the chart by default",
+			chartPath: filepath.Join(".", "testdata", "nginx"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+				ValueFiles:  []string{"../../helm.go"},
+			},
+			expectedErr: "values file ../../helm.go is outside of the repository",
+		},
+		{
+			name:      "Should return error when the path is not a chart",
+			chartPath: filepath.Join(".", "testdata"),
+			opts: &TemplateOptions{
+				ReleaseName: "my-nginx",
+			},
+			expectedErr: "failed to load chart",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHelm(newFakeDiscovery())
+			objs, err := h.Template(tt.chartPath, tt.opts)
+			if err != nil {
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			assert.Empty(t, tt.expectedErr)
+
+			tt.validate(t, objs)
+		})
+	}
+}
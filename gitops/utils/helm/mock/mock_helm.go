@@ -0,0 +1,56 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm (interfaces: Helm)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock_helm.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm Helm
+//
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+
+	helm "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/helm"
+	gomock "go.uber.org/mock/gomock"
+	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MockHelm is a mock of Helm interface.
+type MockHelm struct {
+	ctrl     *gomock.Controller
+	recorder *MockHelmMockRecorder
+}
+
+// MockHelmMockRecorder is the mock recorder for MockHelm.
+type MockHelmMockRecorder struct {
+	mock *MockHelm
+}
+
+// NewMockHelm creates a new mock instance.
+func NewMockHelm(ctrl *gomock.Controller) *MockHelm {
+	mock := &MockHelm{ctrl: ctrl}
+	mock.recorder = &MockHelmMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHelm) EXPECT() *MockHelmMockRecorder {
+	return m.recorder
+}
+
+// Template mocks base method.
+func (m *MockHelm) Template(arg0 string, arg1 *helm.TemplateOptions) ([]*unstructured.Unstructured, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Template", arg0, arg1)
+	ret0, _ := ret[0].([]*unstructured.Unstructured)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Template indicates an expected call of Template.
+func (mr *MockHelmMockRecorder) Template(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Template", reflect.TypeOf((*MockHelm)(nil).Template), arg0, arg1)
+}
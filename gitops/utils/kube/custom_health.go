@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// HealthChecker assesses the health of live resources with the custom health check
+// of their kind if there is one, or the built-in health checks
+type HealthChecker interface {
+	GetResourceHealth(obj *unstructured.Unstructured) (*HealthStatus, error)
+	// SetCustomHealthChecks replaces the custom health checks. Checks are keyed by
+	// <group>_<kind>, e.g. cert-manager.io_Certificate, or _<kind> for the core group.
+	// The checks that compile are loaded even if others don't.
+	SetCustomHealthChecks(checks map[string]string) error
+}
+
+type healthChecker struct {
+	env *cel.Env
+
+	lock   sync.RWMutex
+	checks map[schema.GroupKind]cel.Program
+}
+
+// NewHealthChecker returns a HealthChecker without custom health checks.
+// A custom health check is a CEL expression over the live object `obj` returning
+// a map with the status (Healthy, Progressing or Degraded) and a message, e.g.
+//
+//	has(obj.status) && obj.status.ready ? {"status": "Healthy", "message": ""} : {"status": "Progressing", "message": "Waiting"}
+func NewHealthChecker() (*healthChecker, error) {
+	env, err := cel.NewEnv(cel.Variable("obj", cel.DynType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	return &healthChecker{
+		env:    env,
+		checks: map[schema.GroupKind]cel.Program{},
+	}, nil
+}
+
+func (h *healthChecker) GetResourceHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	h.lock.RLock()
+	program, ok := h.checks[obj.GroupVersionKind().GroupKind()]
+	h.lock.RUnlock()
+	if !ok {
+		return GetResourceHealth(obj)
+	}
+
+	health, err := evalHealthCheck(program, obj)
+	if err != nil {
+		return nil, fmt.Errorf("error assessing health of %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return health, nil
+}
+
+func (h *healthChecker) SetCustomHealthChecks(checks map[string]string) error {
+	var errs []error
+	programs := make(map[schema.GroupKind]cel.Program, len(checks))
+	for key, expression := range checks {
+		groupKind, err := parseHealthCheckKey(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		program, err := h.compile(expression)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("health check %s: %w", key, err))
+			continue
+		}
+		programs[groupKind] = program
+	}
+
+	h.lock.Lock()
+	h.checks = programs
+	h.lock.Unlock()
+
+	return errors.Join(errs...)
+}
+
+func (h *healthChecker) compile(expression string) (cel.Program, error) {
+	ast, issues := h.env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !cel.MapType(cel.StringType, cel.DynType).IsAssignableType(ast.OutputType()) {
+		return nil, fmt.Errorf("must return a map of strings, got %s", ast.OutputType())
+	}
+
+	return h.env.Program(ast)
+}
+
+// parseHealthCheckKey parses <group>_<kind>, the group of core resources is empty
+func parseHealthCheckKey(key string) (schema.GroupKind, error) {
+	i := strings.LastIndex(key, "_")
+	if i < 0 || i == len(key)-1 {
+		return schema.GroupKind{}, fmt.Errorf("invalid health check key %q, expected <group>_<kind>", key)
+	}
+
+	return schema.GroupKind{Group: key[:i], Kind: key[i+1:]}, nil
+}
+
+func evalHealthCheck(program cel.Program, obj *unstructured.Unstructured) (*HealthStatus, error) {
+	out, _, err := program.Eval(map[string]interface{}{
+		"obj": obj.Object,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	native, err := out.ConvertToNative(reflect.TypeOf(map[string]string{}))
+	if err != nil {
+		return nil, fmt.Errorf("health check must return a map of strings: %w", err)
+	}
+	result := native.(map[string]string)
+
+	switch result["status"] {
+	case HealthStatusHealthy, HealthStatusProgressing, HealthStatusDegraded:
+	default:
+		return nil, fmt.Errorf("invalid health status %q", result["status"])
+	}
+
+	return &HealthStatus{
+		Status:  result["status"],
+		Message: result["message"],
+	}, nil
+}
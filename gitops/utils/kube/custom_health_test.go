@@ -0,0 +1,156 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const certificateHealthCheck = `
+has(obj.status) && has(obj.status.conditions) && obj.status.conditions.exists(c, c.type == "Ready" && c.status == "True")
+  ? {"status": "Healthy", "message": ""}
+  : has(obj.status) && has(obj.status.conditions) && obj.status.conditions.exists(c, c.type == "Ready" && c.status == "False")
+    ? {"status": "Degraded", "message": obj.status.conditions.filter(c, c.type == "Ready")[0].message}
+    : {"status": "Progressing", "message": "Waiting for certificate to be issued"}
+`
+
+func Test_HealthChecker_GetResourceHealth(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		checks          map[string]string
+		manifest        string
+		expectedStatus  string
+		expectedMessage string
+		expectedErr     string
+	}{
+		{
+			name:   "Should be progressing when the custom check says so",
+			checks: map[string]string{"cert-manager.io_Certificate": certificateHealthCheck},
+			manifest: `
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: example-com
+`,
+			expectedStatus:  HealthStatusProgressing,
+			expectedMessage: "Waiting for certificate to be issued",
+		},
+		{
+			name:   "Should be degraded with the message from the custom check",
+			checks: map[string]string{"cert-manager.io_Certificate": certificateHealthCheck},
+			manifest: `
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: example-com
+status:
+  conditions:
+    - type: Ready
+      status: "False"
+      message: Issuer not found
+`,
+			expectedStatus:  HealthStatusDegraded,
+			expectedMessage: "Issuer not found",
+		},
+		{
+			name:   "Should be healthy when the custom check says so",
+			checks: map[string]string{"cert-manager.io_Certificate": certificateHealthCheck},
+			manifest: `
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: example-com
+status:
+  conditions:
+    - type: Ready
+      status: "True"
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name:   "Should override the built-in check of core resources",
+			checks: map[string]string{"_Service": `{"status": "Degraded", "message": "always"}`},
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+`,
+			expectedStatus:  HealthStatusDegraded,
+			expectedMessage: "always",
+		},
+		{
+			name: "Should use the built-in check when there is no custom check",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+status:
+  phase: Pending
+`,
+			expectedStatus:  HealthStatusProgressing,
+			expectedMessage: "Waiting for persistent volume claim to be bound",
+		},
+		{
+			name:   "Should return error when the custom check returns an invalid status",
+			checks: map[string]string{"example.com_Database": `{"status": "Ready", "message": ""}`},
+			manifest: `
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: postgres
+`,
+			expectedErr: `error assessing health of Database postgres: invalid health status "Ready"`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := NewHealthChecker()
+			assert.NoError(t, err)
+			assert.NoError(t, checker.SetCustomHealthChecks(tt.checks))
+
+			objs, err := DecodeManifests([]byte(tt.manifest))
+			assert.NoError(t, err)
+
+			health, err := checker.GetResourceHealth(objs[0])
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, health.Status)
+			assert.Equal(t, tt.expectedMessage, health.Message)
+		})
+	}
+}
+
+func Test_HealthChecker_SetCustomHealthChecks(t *testing.T) {
+	checker, err := NewHealthChecker()
+	assert.NoError(t, err)
+
+	// The valid checks are loaded even if others are not
+	err = checker.SetCustomHealthChecks(map[string]string{
+		"example.com_Database": `{"status": "Healthy", "message": ""}`,
+		"example.com_Queue":    `{"status": `,
+		"example.com_Cache":    `"Healthy"`,
+		"Certificate":          `{"status": "Healthy", "message": ""}`,
+	})
+	assert.ErrorContains(t, err, "health check example.com_Queue")
+	assert.ErrorContains(t, err, "health check example.com_Cache: must return a map of strings")
+	assert.ErrorContains(t, err, `invalid health check key "Certificate"`)
+
+	database := &unstructured.Unstructured{}
+	database.SetAPIVersion("example.com/v1")
+	database.SetKind("Database")
+	database.SetName("postgres")
+	assert.Contains(t, checker.checks, database.GroupVersionKind().GroupKind())
+	assert.Len(t, checker.checks, 1)
+
+	// Reloading replaces all the checks
+	err = checker.SetCustomHealthChecks(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, checker.checks)
+}
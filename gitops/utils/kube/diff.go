@@ -0,0 +1,244 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "Added"
+	ChangeRemoved  ChangeType = "Removed"
+	ChangeModified ChangeType = "Modified"
+)
+
+// FieldChange is the change of a field of a resource, Old is the live value and New the desired one
+type FieldChange struct {
+	// Path of the field, e.g. spec.template.spec.containers[0].image
+	Path string
+	Type ChangeType
+	Old  interface{}
+	New  interface{}
+}
+
+func newFieldChange(path string, live interface{}, desired interface{}) FieldChange {
+	change := FieldChange{Path: path, Type: ChangeModified, Old: live, New: desired}
+	switch {
+	case live == nil:
+		change.Type = ChangeAdded
+	case desired == nil:
+		change.Type = ChangeRemoved
+	}
+	return change
+}
+
+func sortChanges(changes []FieldChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+}
+
+// DiffSummary counts the resources that differ between Git and the cluster
+type DiffSummary struct {
+	Added    int
+	Modified int
+	Removed  int
+}
+
+func (s DiffSummary) String() string {
+	return fmt.Sprintf("%d added, %d modified, %d removed", s.Added, s.Modified, s.Removed)
+}
+
+func SummarizeDiff(diffs []ResourceDiff) DiffSummary {
+	var summary DiffSummary
+	for _, d := range diffs {
+		switch d.Type() {
+		case ChangeAdded:
+			summary.Added++
+		case ChangeModified:
+			summary.Modified++
+		case ChangeRemoved:
+			summary.Removed++
+		}
+	}
+	return summary
+}
+
+// RenderDiff renders the resources that differ as unified diffs of their YAML,
+// the values of the Secrets are masked
+func RenderDiff(diffs []ResourceDiff) (string, error) {
+	var b strings.Builder
+	for _, d := range diffs {
+		if d.Type() == "" {
+			continue
+		}
+
+		desired := d.Predicted
+		if desired == nil {
+			desired = d.Desired
+		}
+		live, desired := cleanForDiff(d.Live), cleanForDiff(desired)
+		if isSecret(d) {
+			masker := newSecretMasker()
+			live, desired = masker.maskObject(live), masker.maskObject(desired)
+		}
+
+		liveYAML, err := toYAML(live)
+		if err != nil {
+			return "", err
+		}
+		desiredYAML, err := toYAML(desired)
+		if err != nil {
+			return "", err
+		}
+
+		name := d.Name()
+		fromFile, toFile := "live/"+name, "desired/"+name
+		if live == nil {
+			fromFile = "/dev/null"
+		}
+		if desired == nil {
+			toFile = "/dev/null"
+		}
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(liveYAML),
+			B:        splitLines(desiredYAML),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(text)
+	}
+
+	return b.String(), nil
+}
+
+// cleanForDiff removes the fields maintained by the API server
+func cleanForDiff(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil {
+		return nil
+	}
+
+	obj = obj.DeepCopy()
+	delete(obj.Object, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	return obj
+}
+
+func toYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("error rendering %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return string(out), nil
+}
+
+// splitLines splits the text into lines, a missing resource has no line
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return difflib.SplitLines(strings.TrimSuffix(text, "\n"))
+}
+
+func isSecret(d ResourceDiff) bool {
+	obj := d.Desired
+	if obj == nil {
+		obj = d.Live
+	}
+	return obj.GroupVersionKind().GroupKind() == schema.GroupKind{Kind: "Secret"}
+}
+
+// maskSecretChanges masks the values of the changes of a Secret
+func maskSecretChanges(changes []FieldChange) {
+	masker := newSecretMasker()
+	for i, c := range changes {
+		if !isSecretField(c.Path) {
+			continue
+		}
+		changes[i].Old = masker.maskValue(c.Old)
+		changes[i].New = masker.maskValue(c.New)
+	}
+}
+
+func isSecretField(path string) bool {
+	for _, field := range []string{"data", "stringData"} {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	// kubectl keeps a copy of the applied Secret
+	return strings.Contains(path, "kubectl.kubernetes.io/last-applied-configuration")
+}
+
+// secretMasker replaces the values with "+" strings, equal values get the same mask
+// so the changed values can be told apart without being revealed
+type secretMasker struct {
+	masks map[string]string
+}
+
+func newSecretMasker() *secretMasker {
+	return &secretMasker{masks: map[string]string{}}
+}
+
+func (m *secretMasker) maskObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil {
+		return nil
+	}
+
+	for _, field := range []string{"data", "stringData"} {
+		if value, ok := obj.Object[field]; ok {
+			obj.Object[field] = m.maskValue(value)
+		}
+	}
+	annotations := obj.GetAnnotations()
+	if value, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		annotations["kubectl.kubernetes.io/last-applied-configuration"] = m.maskValue(value).(string)
+		obj.SetAnnotations(annotations)
+	}
+	return obj
+}
+
+func (m *secretMasker) maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		// The masks don't depend on the order of the keys
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		masked := make(map[string]interface{}, len(v))
+		for _, key := range keys {
+			masked[key] = m.maskValue(v[key])
+		}
+		return masked
+	}
+
+	key := fmt.Sprint(value)
+	mask, ok := m.masks[key]
+	if !ok {
+		mask = strings.Repeat("+", 8+len(m.masks))
+		m.masks[key] = mask
+	}
+	return mask
+}
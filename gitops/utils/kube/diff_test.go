@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_RenderDiff(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		live         string
+		desired      string
+		changes      []FieldChange
+		expectedDiff string
+	}{
+		{
+			name: "Should render the whole resource if it is added",
+			desired: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+data:
+  key: value
+`,
+			expectedDiff: `--- /dev/null
++++ desired/v1/ConfigMap/default/nginx
+@@ -0,0 +1,7 @@
++apiVersion: v1
++data:
++  key: value
++kind: ConfigMap
++metadata:
++  name: nginx
++  namespace: default
+`,
+		},
+		{
+			name: "Should render the whole resource if it is removed, without the fields maintained by the API server",
+			live: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+  resourceVersion: "42"
+  uid: 6b1e3b9c-1f6f-4b8e-9d6a-4c1f8e2b7a10
+data:
+  key: value
+`,
+			expectedDiff: `--- live/v1/ConfigMap/default/nginx
++++ /dev/null
+@@ -1,7 +0,0 @@
+-apiVersion: v1
+-data:
+-  key: value
+-kind: ConfigMap
+-metadata:
+-  name: nginx
+-  namespace: default
+`,
+		},
+		{
+			name: "Should render the modified fields of the resource",
+			live: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+data:
+  key: old
+`,
+			desired: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+data:
+  key: new
+`,
+			changes: []FieldChange{{Path: "data.key", Type: ChangeModified, Old: "old", New: "new"}},
+			expectedDiff: `--- live/v1/ConfigMap/default/nginx
++++ desired/v1/ConfigMap/default/nginx
+@@ -1,6 +1,6 @@
+ apiVersion: v1
+ data:
+-  key: old
++  key: new
+ kind: ConfigMap
+ metadata:
+   name: nginx
+`,
+		},
+		{
+			name: "Should mask the values of the Secrets, keeping equal values with the same mask",
+			live: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: nginx
+  namespace: default
+data:
+  password: b2xk
+  username: YWRtaW4=
+`,
+			desired: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: nginx
+  namespace: default
+data:
+  password: bmV3
+  username: YWRtaW4=
+`,
+			changes: []FieldChange{{Path: "data.password", Type: ChangeModified}},
+			expectedDiff: `--- live/v1/Secret/default/nginx
++++ desired/v1/Secret/default/nginx
+@@ -1,6 +1,6 @@
+ apiVersion: v1
+ data:
+-  password: ++++++++
++  password: ++++++++++
+   username: +++++++++
+ kind: Secret
+ metadata:
+`,
+		},
+		{
+			name: "Should not render the resources that didn't change",
+			live: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+`,
+			desired: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+`,
+			expectedDiff: "",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := ResourceDiff{Changes: tt.changes, Modified: len(tt.changes) > 0}
+			diff.Live = decodeResource(t, tt.live)
+			diff.Desired = decodeResource(t, tt.desired)
+
+			text, err := RenderDiff([]ResourceDiff{diff})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDiff, text)
+		})
+	}
+}
+
+func Test_SummarizeDiff(t *testing.T) {
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}}
+	diffs := []ResourceDiff{
+		{Desired: configMap},
+		{Live: configMap},
+		{Live: configMap, Desired: configMap, Modified: true},
+		{Live: configMap, Desired: configMap},
+		{Desired: configMap},
+	}
+
+	summary := SummarizeDiff(diffs)
+	assert.Equal(t, DiffSummary{Added: 2, Modified: 1, Removed: 1}, summary)
+	assert.Equal(t, "2 added, 1 modified, 1 removed", summary.String())
+}
+
+func decodeResource(t *testing.T, manifest string) *unstructured.Unstructured {
+	if manifest == "" {
+		return nil
+	}
+
+	objs, err := DecodeManifests([]byte(manifest))
+	assert.NoError(t, err)
+	assert.Len(t, objs, 1)
+	return objs[0]
+}
@@ -0,0 +1,330 @@
+package k8s
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	HealthStatusHealthy     = "Healthy"
+	HealthStatusProgressing = "Progressing"
+	HealthStatusDegraded    = "Degraded"
+)
+
+// HealthStatus is the health of a live resource
+type HealthStatus struct {
+	Status  string
+	Message string
+}
+
+type healthCheck func(obj *unstructured.Unstructured) (*HealthStatus, error)
+
+// healthChecks are the built-in health checks, by group and kind
+var healthChecks = map[schema.GroupKind]healthCheck{
+	{Group: "apps", Kind: "Deployment"}:                   deploymentHealth,
+	{Group: "apps", Kind: "StatefulSet"}:                  statefulSetHealth,
+	{Group: "apps", Kind: "DaemonSet"}:                    daemonSetHealth,
+	{Group: "apps", Kind: "ReplicaSet"}:                   replicaSetHealth,
+	{Group: "", Kind: "Pod"}:                              podHealth,
+	{Group: "batch", Kind: "Job"}:                         jobHealth,
+	{Group: "", Kind: "PersistentVolumeClaim"}:            pvcHealth,
+	{Group: "", Kind: "Service"}:                          serviceHealth,
+	{Group: "networking.k8s.io", Kind: "Ingress"}:         ingressHealth,
+	{Group: "extensions", Kind: "Ingress"}:                ingressHealth,
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}: apiServiceHealth,
+}
+
+// GetResourceHealth assesses the health of a live resource.
+// Resources without a health check are always healthy.
+func GetResourceHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	check, ok := healthChecks[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		return &HealthStatus{Status: HealthStatusHealthy}, nil
+	}
+
+	health, err := check(obj)
+	if err != nil {
+		return nil, fmt.Errorf("error assessing health of %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return health, nil
+}
+
+func healthy() *HealthStatus {
+	return &HealthStatus{Status: HealthStatusHealthy}
+}
+
+func progressing(format string, args ...interface{}) *HealthStatus {
+	return &HealthStatus{Status: HealthStatusProgressing, Message: fmt.Sprintf(format, args...)}
+}
+
+func degraded(format string, args ...interface{}) *HealthStatus {
+	return &HealthStatus{Status: HealthStatusDegraded, Message: fmt.Sprintf(format, args...)}
+}
+
+// generationObserved returns whether the controller of the resource has seen its latest spec
+func generationObserved(obj *unstructured.Unstructured, observedGeneration int64) bool {
+	return observedGeneration >= obj.GetGeneration()
+}
+
+// deploymentHealth follows `kubectl rollout status`
+func deploymentHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var deployment appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &deployment); err != nil {
+		return nil, err
+	}
+
+	if !generationObserved(obj, deployment.Status.ObservedGeneration) {
+		return progressing("Waiting for rollout to start"), nil
+	}
+	if deployment.Spec.Paused {
+		return healthy(), nil
+	}
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return degraded("Deployment %q exceeded its progress deadline", deployment.Name), nil
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	if status.UpdatedReplicas < replicas {
+		return progressing("Waiting for rollout to finish: %d out of %d new replicas have been updated", status.UpdatedReplicas, replicas), nil
+	}
+	if status.Replicas > status.UpdatedReplicas {
+		return progressing("Waiting for rollout to finish: %d old replicas are pending termination", status.Replicas-status.UpdatedReplicas), nil
+	}
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return progressing("Waiting for rollout to finish: %d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas), nil
+	}
+
+	return healthy(), nil
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var sts appsv1.StatefulSet
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &sts); err != nil {
+		return nil, err
+	}
+
+	if !generationObserved(obj, sts.Status.ObservedGeneration) {
+		return progressing("Waiting for rollout to start"), nil
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	status := sts.Status
+	if status.ReadyReplicas < replicas {
+		return progressing("Waiting for %d pods to be ready", replicas-status.ReadyReplicas), nil
+	}
+
+	// Pods are only replaced when deleted manually
+	if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return healthy(), nil
+	}
+
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		partitioned := replicas - *rollingUpdate.Partition
+		if status.UpdatedReplicas < partitioned {
+			return progressing("Waiting for partitioned rollout to finish: %d out of %d new pods have been updated", status.UpdatedReplicas, partitioned), nil
+		}
+		return healthy(), nil
+	}
+	if status.UpdateRevision != status.CurrentRevision {
+		return progressing("Waiting for rollout to finish: %d out of %d new pods have been updated", status.UpdatedReplicas, replicas), nil
+	}
+
+	return healthy(), nil
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var ds appsv1.DaemonSet
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &ds); err != nil {
+		return nil, err
+	}
+
+	if !generationObserved(obj, ds.Status.ObservedGeneration) {
+		return progressing("Waiting for rollout to start"), nil
+	}
+
+	// Pods are only replaced when deleted manually
+	if ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+		return healthy(), nil
+	}
+
+	status := ds.Status
+	if status.UpdatedNumberScheduled < status.DesiredNumberScheduled {
+		return progressing("Waiting for rollout to finish: %d out of %d new pods have been updated", status.UpdatedNumberScheduled, status.DesiredNumberScheduled), nil
+	}
+	if status.NumberAvailable < status.DesiredNumberScheduled {
+		return progressing("Waiting for rollout to finish: %d of %d updated pods are available", status.NumberAvailable, status.DesiredNumberScheduled), nil
+	}
+
+	return healthy(), nil
+}
+
+func replicaSetHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var rs appsv1.ReplicaSet
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rs); err != nil {
+		return nil, err
+	}
+
+	if !generationObserved(obj, rs.Status.ObservedGeneration) {
+		return progressing("Waiting for rollout to start"), nil
+	}
+	for _, c := range rs.Status.Conditions {
+		if c.Type == appsv1.ReplicaSetReplicaFailure && c.Status == corev1.ConditionTrue {
+			return degraded("%s", c.Message), nil
+		}
+	}
+
+	replicas := int32(1)
+	if rs.Spec.Replicas != nil {
+		replicas = *rs.Spec.Replicas
+	}
+	if rs.Status.AvailableReplicas < replicas {
+		return progressing("Waiting for %d pods to be available", replicas-rs.Status.AvailableReplicas), nil
+	}
+
+	return healthy(), nil
+}
+
+// podErrorReasons are the reasons a container is waiting that won't go away on their own
+var podErrorReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+func podHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var pod corev1.Pod
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err != nil {
+		return nil, err
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return healthy(), nil
+	case corev1.PodFailed:
+		return degraded("Pod failed: %s", pod.Status.Message), nil
+	}
+
+	statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		if s.State.Waiting != nil && podErrorReasons[s.State.Waiting.Reason] {
+			return degraded("Container %q is waiting: %s", s.Name, s.State.Waiting.Reason), nil
+		}
+	}
+
+	if pod.Status.Phase == corev1.PodRunning {
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+				return healthy(), nil
+			}
+		}
+		return progressing("Waiting for pod to be ready"), nil
+	}
+
+	return progressing("Pod is %s", pod.Status.Phase), nil
+}
+
+func jobHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var job batchv1.Job
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &job); err != nil {
+		return nil, err
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobFailed:
+			return degraded("Job failed: %s", c.Message), nil
+		case batchv1.JobComplete:
+			return healthy(), nil
+		}
+	}
+
+	return progressing("Waiting for job to complete"), nil
+}
+
+func pvcHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	var pvc corev1.PersistentVolumeClaim
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pvc); err != nil {
+		return nil, err
+	}
+
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return healthy(), nil
+	case corev1.ClaimLost:
+		return degraded("Persistent volume claim lost its volume"), nil
+	}
+
+	return progressing("Waiting for persistent volume claim to be bound"), nil
+}
+
+func serviceHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	serviceType, _, err := unstructured.NestedString(obj.Object, "spec", "type")
+	if err != nil {
+		return nil, err
+	}
+	if serviceType != string(corev1.ServiceTypeLoadBalancer) {
+		return healthy(), nil
+	}
+
+	return loadBalancerHealth(obj)
+}
+
+func ingressHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	return loadBalancerHealth(obj)
+}
+
+// loadBalancerHealth waits for the load balancer of a Service or an Ingress to be provisioned
+func loadBalancerHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	ingress, _, err := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if err != nil {
+		return nil, err
+	}
+	if len(ingress) == 0 {
+		return progressing("Waiting for load balancer to be provisioned"), nil
+	}
+
+	return healthy(), nil
+}
+
+func apiServiceHealth(obj *unstructured.Unstructured) (*HealthStatus, error) {
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, raw := range conditions {
+		c, ok := raw.(map[string]interface{})
+		if !ok || c["type"] != "Available" {
+			continue
+		}
+		switch c["status"] {
+		case string(corev1.ConditionTrue):
+			return healthy(), nil
+		case string(corev1.ConditionFalse):
+			return degraded("%v: %v", c["reason"], c["message"]), nil
+		}
+	}
+
+	return progressing("Waiting for API service to be available"), nil
+}
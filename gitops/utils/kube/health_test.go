@@ -0,0 +1,326 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetResourceHealth(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		manifest       string
+		expectedStatus string
+	}{
+		{
+			name: "Should be progressing when the deployment controller hasn't seen the latest spec",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  generation: 2
+spec:
+  replicas: 2
+status:
+  observedGeneration: 1
+  replicas: 2
+  updatedReplicas: 2
+  availableReplicas: 2
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be progressing when the deployment replicas are not all updated",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  generation: 2
+spec:
+  replicas: 3
+status:
+  observedGeneration: 2
+  replicas: 3
+  updatedReplicas: 1
+  availableReplicas: 3
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be degraded when the deployment exceeded its progress deadline",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  generation: 2
+spec:
+  replicas: 3
+status:
+  observedGeneration: 2
+  replicas: 3
+  updatedReplicas: 1
+  conditions:
+    - type: Progressing
+      status: "False"
+      reason: ProgressDeadlineExceeded
+`,
+			expectedStatus: HealthStatusDegraded,
+		},
+		{
+			name: "Should be healthy when the deployment is rolled out",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  generation: 2
+spec:
+  replicas: 3
+status:
+  observedGeneration: 2
+  replicas: 3
+  updatedReplicas: 3
+  availableReplicas: 3
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be progressing when the statefulset update revision is not rolled out",
+			manifest: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: redis
+  generation: 1
+spec:
+  replicas: 2
+status:
+  observedGeneration: 1
+  readyReplicas: 2
+  updatedReplicas: 1
+  currentRevision: redis-1
+  updateRevision: redis-2
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be healthy when the statefulset pods are ready and updated",
+			manifest: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: redis
+  generation: 1
+spec:
+  replicas: 2
+status:
+  observedGeneration: 1
+  readyReplicas: 2
+  updatedReplicas: 2
+  currentRevision: redis-2
+  updateRevision: redis-2
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be progressing when the daemonset pods are not all available",
+			manifest: `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: fluentd
+  generation: 1
+status:
+  observedGeneration: 1
+  desiredNumberScheduled: 3
+  updatedNumberScheduled: 3
+  numberAvailable: 2
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be degraded when the replicaset fails to create pods",
+			manifest: `
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: nginx-7c5ddbdf54
+  generation: 1
+spec:
+  replicas: 1
+status:
+  observedGeneration: 1
+  conditions:
+    - type: ReplicaFailure
+      status: "True"
+      message: exceeded quota
+`,
+			expectedStatus: HealthStatusDegraded,
+		},
+		{
+			name: "Should be degraded when a container of the pod is crash looping",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: nginx
+status:
+  phase: Running
+  containerStatuses:
+    - name: nginx
+      state:
+        waiting:
+          reason: CrashLoopBackOff
+`,
+			expectedStatus: HealthStatusDegraded,
+		},
+		{
+			name: "Should be healthy when the pod is ready",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: nginx
+status:
+  phase: Running
+  conditions:
+    - type: Ready
+      status: "True"
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be degraded when the job failed",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+status:
+  conditions:
+    - type: Failed
+      status: "True"
+      message: Job has reached the specified backoff limit
+`,
+			expectedStatus: HealthStatusDegraded,
+		},
+		{
+			name: "Should be progressing while the job is running",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+status:
+  active: 1
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be progressing while the persistent volume claim is pending",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+status:
+  phase: Pending
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be healthy when the persistent volume claim is bound",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+status:
+  phase: Bound
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be progressing while the load balancer is provisioned",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+spec:
+  type: LoadBalancer
+`,
+			expectedStatus: HealthStatusProgressing,
+		},
+		{
+			name: "Should be healthy for a ClusterIP service",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+spec:
+  type: ClusterIP
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be healthy when the ingress has an address",
+			manifest: `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: nginx
+status:
+  loadBalancer:
+    ingress:
+      - ip: 10.0.0.1
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+		{
+			name: "Should be degraded when the API service is not available",
+			manifest: `
+apiVersion: apiregistration.k8s.io/v1
+kind: APIService
+metadata:
+  name: v1beta1.metrics.k8s.io
+status:
+  conditions:
+    - type: Available
+      status: "False"
+      reason: FailedDiscoveryCheck
+      message: failing or missing response
+`,
+			expectedStatus: HealthStatusDegraded,
+		},
+		{
+			name: "Should be healthy for kinds without health check",
+			manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+`,
+			expectedStatus: HealthStatusHealthy,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := DecodeManifests([]byte(tt.manifest))
+			assert.NoError(t, err)
+			assert.Len(t, objs, 1)
+
+			health, err := GetResourceHealth(objs[0])
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, health.Status)
+			if tt.expectedStatus != HealthStatusHealthy {
+				assert.NotEmpty(t, health.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// IgnoreRule selects the fields of resources that are owned by others, e.g. the replicas
+// scaled by an HPA. Empty Group is the core group, empty Name and Namespace match all resources.
+type IgnoreRule struct {
+	Group     string
+	Kind      string
+	Name      string
+	Namespace string
+	// JSONPointers are RFC 6901 pointers, e.g. /spec/replicas
+	JSONPointers []string
+	// JSONPaths are JSONPath expressions, e.g. .webhooks[*].clientConfig.caBundle
+	JSONPaths []string
+}
+
+// IgnoreDifferences removes the fields selected by the rules from the resources
+type IgnoreDifferences struct {
+	rules []compiledIgnoreRule
+}
+
+type compiledIgnoreRule struct {
+	IgnoreRule
+	paths [][]pathSegment
+}
+
+// pathSegment is a key of an object, an index of a list or a wildcard matching all of them
+type pathSegment struct {
+	key      string
+	wildcard bool
+}
+
+// NewIgnoreDifferences parses the paths of the rules
+func NewIgnoreDifferences(rules []IgnoreRule) (*IgnoreDifferences, error) {
+	ignore := &IgnoreDifferences{}
+	for _, rule := range rules {
+		if rule.Kind == "" {
+			return nil, fmt.Errorf("invalid ignore rule, kind is empty")
+		}
+
+		compiled := compiledIgnoreRule{IgnoreRule: rule}
+		for _, pointer := range rule.JSONPointers {
+			path, err := parseJSONPointer(pointer)
+			if err != nil {
+				return nil, err
+			}
+			compiled.paths = append(compiled.paths, path)
+		}
+		for _, expression := range rule.JSONPaths {
+			path, err := parseJSONPath(expression)
+			if err != nil {
+				return nil, err
+			}
+			compiled.paths = append(compiled.paths, path)
+		}
+		ignore.rules = append(ignore.rules, compiled)
+	}
+
+	return ignore, nil
+}
+
+// Normalize removes the ignored fields from the resource, in place
+func (i *IgnoreDifferences) Normalize(obj *unstructured.Unstructured) {
+	if i == nil || obj == nil {
+		return
+	}
+
+	for _, rule := range i.rules {
+		if !rule.matches(obj) {
+			continue
+		}
+		for _, path := range rule.paths {
+			removeField(obj.Object, path)
+		}
+	}
+}
+
+func (r *compiledIgnoreRule) matches(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return r.Group == gvk.Group && r.Kind == gvk.Kind &&
+		(r.Name == "" || r.Name == obj.GetName()) &&
+		(r.Namespace == "" || r.Namespace == obj.GetNamespace())
+}
+
+// removeField removes the values at the path, the items of lists are removed
+// if the path ends with an index
+func removeField(value interface{}, path []pathSegment) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	segment := path[0]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if !segment.wildcard && key != segment.key {
+				continue
+			}
+			if len(path) == 1 {
+				delete(v, key)
+				continue
+			}
+			v[key] = removeField(child, path[1:])
+		}
+		return v
+	case []interface{}:
+		kept := make([]interface{}, 0, len(v))
+		for index, item := range v {
+			if !segment.wildcard && strconv.Itoa(index) != segment.key {
+				kept = append(kept, item)
+				continue
+			}
+			if len(path) == 1 {
+				continue
+			}
+			kept = append(kept, removeField(item, path[1:]))
+		}
+		return kept
+	}
+
+	return value
+}
+
+// parseJSONPointer parses a RFC 6901 pointer, e.g. /metadata/annotations/example.com~1key
+func parseJSONPointer(pointer string) ([]pathSegment, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q, must start with /", pointer)
+	}
+
+	var path []pathSegment
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		path = append(path, pathSegment{key: token})
+	}
+	return path, nil
+}
+
+// parseJSONPath parses the JSONPath expressions made of fields, indexes and wildcards,
+// e.g. $.spec.template.spec.containers[*].resources or .metadata.annotations['example.com/key']
+func parseJSONPath(expression string) ([]pathSegment, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid JSONPath %q: %s", expression, reason)
+	}
+
+	rest := strings.TrimPrefix(expression, "$")
+	var path []pathSegment
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			field := rest[:end]
+			if field == "" {
+				return nil, invalid("empty field, recursive descent is not supported")
+			}
+			path = append(path, pathSegment{key: field, wildcard: field == "*"})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid("missing ]")
+			}
+			subscript := rest[1:end]
+			rest = rest[end+1:]
+
+			switch {
+			case subscript == "*":
+				path = append(path, pathSegment{wildcard: true})
+			case len(subscript) >= 2 && (subscript[0] == '\'' || subscript[0] == '"') && subscript[len(subscript)-1] == subscript[0]:
+				path = append(path, pathSegment{key: subscript[1 : len(subscript)-1]})
+			default:
+				if _, err := strconv.Atoi(subscript); err != nil {
+					return nil, invalid(fmt.Sprintf("unsupported subscript [%s]", subscript))
+				}
+				path = append(path, pathSegment{key: subscript})
+			}
+		default:
+			return nil, invalid(fmt.Sprintf("unexpected %q", rest[0]))
+		}
+	}
+
+	if len(path) == 0 {
+		return nil, invalid("empty path")
+	}
+	return path, nil
+}
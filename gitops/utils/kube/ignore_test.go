@@ -0,0 +1,164 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IgnoreDifferences_Normalize(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		rules            []IgnoreRule
+		manifest         string
+		expectedManifest string
+		expectedErr      bool
+	}{
+		{
+			name:  "Should remove the fields selected by JSON pointers",
+			rules: []IgnoreRule{{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas", "/metadata/annotations/example.com~1revision"}}},
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  annotations:
+    example.com/revision: "3"
+    example.com/owner: team
+spec:
+  replicas: 3
+  paused: false
+`,
+			expectedManifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  annotations:
+    example.com/owner: team
+spec:
+  paused: false
+`,
+		},
+		{
+			name:  "Should remove the fields selected by JSONPath expressions with wildcards and quoted keys",
+			rules: []IgnoreRule{{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration", JSONPaths: []string{"$.webhooks[*].clientConfig.caBundle", ".metadata.annotations['example.com/injected']"}}},
+			manifest: `
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: webhook
+  annotations:
+    example.com/injected: "true"
+webhooks:
+  - name: a.example.com
+    clientConfig:
+      caBundle: Y2E=
+      url: https://a.example.com
+  - name: b.example.com
+    clientConfig:
+      caBundle: Y2E=
+`,
+			expectedManifest: `
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: webhook
+  annotations: {}
+webhooks:
+  - name: a.example.com
+    clientConfig:
+      url: https://a.example.com
+  - name: b.example.com
+    clientConfig: {}
+`,
+		},
+		{
+			name:  "Should remove the items of lists selected by index",
+			rules: []IgnoreRule{{Kind: "ConfigMap", JSONPaths: []string{".data.list[1]"}, JSONPointers: []string{"/data/other/0"}}},
+			manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+data:
+  list: [a, b, c]
+  other: [a]
+`,
+			expectedManifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+data:
+  list: [a, c]
+  other: []
+`,
+		},
+		{
+			name: "Should keep the fields of the resources not matching the rules",
+			rules: []IgnoreRule{
+				{Group: "apps", Kind: "Deployment", Name: "other", JSONPointers: []string{"/spec/replicas"}},
+				{Group: "apps", Kind: "Deployment", Namespace: "other", JSONPointers: []string{"/spec/replicas"}},
+				{Group: "apps", Kind: "StatefulSet", JSONPointers: []string{"/spec/replicas"}},
+			},
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  replicas: 3
+`,
+			expectedManifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  replicas: 3
+`,
+		},
+		{
+			name:        "Should return error if a JSON pointer is invalid",
+			rules:       []IgnoreRule{{Kind: "ConfigMap", JSONPointers: []string{"data"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if a JSONPath expression is not supported",
+			rules:       []IgnoreRule{{Kind: "ConfigMap", JSONPaths: []string{"..data"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if a JSONPath filter is used",
+			rules:       []IgnoreRule{{Kind: "ConfigMap", JSONPaths: []string{".items[?(@.name=='a')]"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "Should return error if the kind is empty",
+			rules:       []IgnoreRule{{JSONPointers: []string{"/data"}}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore, err := NewIgnoreDifferences(tt.rules)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			objs, err := DecodeManifests([]byte(tt.manifest))
+			assert.NoError(t, err)
+			expected, err := DecodeManifests([]byte(tt.expectedManifest))
+			assert.NoError(t, err)
+
+			ignore.Normalize(objs[0])
+			assert.Equal(t, expected[0].Object, objs[0].Object)
+		})
+	}
+}
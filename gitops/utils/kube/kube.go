@@ -1,35 +1,41 @@
 package k8s
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 )
 
+// FieldManager owns the fields applied by the controller
+const FieldManager = "application/apply-patch"
+
 type K8s interface {
-	CreateResource(ctx context.Context, obj *unstructured.Unstructured, namespace string) error
+	CreateResource(ctx context.Context, obj *unstructured.Unstructured, namespace string) (*unstructured.Unstructured, error)
 	PatchResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string) error
-	DeleteResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string) error
+	DeleteResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string, opts metav1.DeleteOptions) error
 	GenerateManifests(path string) ([]*unstructured.Unstructured, error)
-	GetResourceWithLabel(label map[string]string) ([]*unstructured.Unstructured, error)
-	DiffResources(old []*unstructured.Unstructured, new []*unstructured.Unstructured) (bool, error)
+	// DiffResources compares the live and desired resources. The fields ignored are removed
+	// from the desired resources, so they are left to their other owners when applied.
+	DiffResources(ctx context.Context, live []*unstructured.Unstructured, desired []*unstructured.Unstructured, namespace string, ignore *IgnoreDifferences) ([]ResourceDiff, error)
 	SetLabelsForResources(resources []*unstructured.Unstructured, labels map[string]string) error
 }
 
@@ -45,7 +51,9 @@ func NewK8s(discoveryClient discovery.DiscoveryInterface, dynClientSet dynamic.I
 	}
 }
 
-func (k *k8s) CreateResource(ctx context.Context, obj *unstructured.Unstructured, namespace string) error {
+// CreateResource applies the resource and returns the resulting live resource.
+// The fields changed by other managers, e.g. with kubectl edit, are taken over.
+func (k *k8s) CreateResource(ctx context.Context, obj *unstructured.Unstructured, namespace string) (*unstructured.Unstructured, error) {
 	gvk := obj.GroupVersionKind()
 	apiResource, err := ServerResourceForGroupVersionKind(
 		k.discoveryClient,
@@ -53,7 +61,7 @@ func (k *k8s) CreateResource(ctx context.Context, obj *unstructured.Unstructured
 		"create",
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resource := gvk.GroupVersion().WithResource(apiResource.Name)
@@ -64,10 +72,10 @@ func (k *k8s) CreateResource(ctx context.Context, obj *unstructured.Unstructured
 	}
 
 	opts := metav1.ApplyOptions{
-		FieldManager: "application/apply-patch",
+		FieldManager: FieldManager,
+		Force:        true,
 	}
-	_, err = dynInterface.Apply(ctx, obj.GetName(), obj, opts)
-	return err
+	return dynInterface.Apply(ctx, obj.GetName(), obj, opts)
 }
 
 func (k *k8s) PatchResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string) error {
@@ -101,7 +109,7 @@ func (k *k8s) PatchResource(ctx context.Context, currentObj *unstructured.Unstru
 	return err
 }
 
-func (k *k8s) DeleteResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string) error {
+func (k *k8s) DeleteResource(ctx context.Context, currentObj *unstructured.Unstructured, namespace string, opts metav1.DeleteOptions) error {
 	gvk := currentObj.GroupVersionKind()
 	apiResource, err := ServerResourceForGroupVersionKind(
 		k.discoveryClient,
@@ -118,7 +126,7 @@ func (k *k8s) DeleteResource(ctx context.Context, currentObj *unstructured.Unstr
 	if apiResource.Namespaced {
 		dynInterface = k.dynClientSet.Resource(resource).Namespace(namespace)
 	}
-	return dynInterface.Delete(ctx, currentObj.GetName(), metav1.DeleteOptions{})
+	return dynInterface.Delete(ctx, currentObj.GetName(), opts)
 }
 
 func (k *k8s) GenerateManifests(path string) ([]*unstructured.Unstructured, error) {
@@ -130,8 +138,8 @@ func (k *k8s) GenerateManifests(path string) ([]*unstructured.Unstructured, erro
 			return err
 		}
 
-		// Skip directories
-		if info.IsDir() {
+		// Skip directories and files that are not manifests
+		if info.IsDir() || !isManifestFile(path) {
 			return nil
 		}
 
@@ -141,13 +149,11 @@ func (k *k8s) GenerateManifests(path string) ([]*unstructured.Unstructured, erro
 			return err
 		}
 
-		var unstructured unstructured.Unstructured
-		dec := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader([]byte(content)), 1000)
-		if err := dec.Decode(&unstructured); err != nil {
-			return nil
+		fileObjs, err := DecodeManifests(content)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", path, err)
 		}
-
-		objs = append(objs, &unstructured)
+		objs = append(objs, fileObjs...)
 
 		return nil
 	})
@@ -158,108 +164,356 @@ func (k *k8s) GenerateManifests(path string) ([]*unstructured.Unstructured, erro
 	return objs, nil
 }
 
-func (k *k8s) GetResourceWithLabel(label map[string]string) ([]*unstructured.Unstructured, error) {
+func isManifestFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// DecodeManifests decodes every document of a YAML or JSON stream.
+// Empty documents are skipped and List kinds are expanded into their items.
+func DecodeManifests(content []byte) ([]*unstructured.Unstructured, error) {
 	var objs []*unstructured.Unstructured
-	var apiError error
-	var wg sync.WaitGroup
-	var lock sync.Mutex
-	listOption := metav1.ListOptions{
-		LabelSelector: labels.Set(label).String(),
+
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for i := 0; ; i++ {
+		raw, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+
+		// Unmarshal keeps integers as int64, the same as objects read from the API server
+		var doc map[string]interface{}
+		if err := k8syaml.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: doc}
+		if obj.GetKind() == "" {
+			return nil, fmt.Errorf("document %d: Object 'Kind' is missing", i)
+		}
+
+		if !obj.IsList() {
+			objs = append(objs, obj)
+			continue
+		}
+
+		list, err := obj.ToList()
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		for j := range list.Items {
+			if list.Items[j].GetKind() == "" {
+				return nil, fmt.Errorf("document %d: item %d: Object 'Kind' is missing", i, j)
+			}
+			objs = append(objs, &list.Items[j])
+		}
 	}
 
-	if len(label) == 0 {
-		return nil, fmt.Errorf("label is empty")
+	return objs, nil
+}
+
+// ResourceDiff is the comparison of the live and desired state of a resource.
+// Live is nil when the resource doesn't exist in the cluster, Desired is nil
+// when the resource is no longer defined in Git.
+type ResourceDiff struct {
+	Live    *unstructured.Unstructured
+	Desired *unstructured.Unstructured
+	// Predicted is the live resource once the desired resource is applied,
+	// nil when the apply can't be dry-run
+	Predicted *unstructured.Unstructured
+	Modified  bool
+	// Changes of the fields of a modified resource, sorted by path
+	Changes []FieldChange
+}
+
+// Type returns whether the resource is added, removed or modified, or empty without differences
+func (d ResourceDiff) Type() ChangeType {
+	switch {
+	case d.Live == nil:
+		return ChangeAdded
+	case d.Desired == nil:
+		return ChangeRemoved
+	case d.Modified:
+		return ChangeModified
 	}
+	return ""
+}
 
-	// Get the list of all API resources available
-	serverResources, err := k.discoveryClient.ServerPreferredResources()
-	if err != nil {
-		return nil, err
+// ModifiedFields returns the paths of the modified fields
+func (d ResourceDiff) ModifiedFields() []string {
+	var fields []string
+	for _, c := range d.Changes {
+		fields = append(fields, c.Path)
 	}
+	return fields
+}
 
-	wg.Add(len(serverResources))
-	for _, group := range serverResources {
-		go func(group *metav1.APIResourceList) {
-			defer wg.Done()
+// Name identifies the resource, e.g. apps/v1/Deployment/default/nginx
+func (d ResourceDiff) Name() string {
+	obj := d.Desired
+	if obj == nil {
+		obj = d.Live
+	}
 
-			for _, resource := range group.APIResources {
-				gv, err := schema.ParseGroupVersion(group.GroupVersion)
-				if err != nil {
-					log.Warningf("parsing GroupVersion %s failed: %s", group.GroupVersion, err)
-				}
-				gvr := schema.GroupVersionResource{
-					Group:    gv.Group,
-					Version:  gv.Version,
-					Resource: resource.Name,
-				}
+	parts := []string{obj.GetAPIVersion(), obj.GetKind()}
+	if obj.GetNamespace() != "" {
+		parts = append(parts, obj.GetNamespace())
+	}
+	return strings.Join(append(parts, obj.GetName()), "/")
+}
 
-				var list *unstructured.UnstructuredList
-				list, err = k.dynClientSet.Resource(gvr).List(context.TODO(), listOption)
-				if err != nil {
-					log.Debugf("Error listing resource %s, %s", gvr.String(), err)
-					continue
-				}
+// resourceKey identifies a resource in every version of its group
+type resourceKey struct {
+	schema.GroupKind
+	Namespace string
+	Name      string
+}
 
-				// Append the resources to the list
-				lock.Lock()
-				for _, item := range list.Items {
-					objs = append(objs, &item)
-				}
-				lock.Unlock()
+func newResourceKey(obj *unstructured.Unstructured) resourceKey {
+	return resourceKey{
+		GroupKind: obj.GroupVersionKind().GroupKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+// DiffResources pairs every desired resource with its live resource, followed by the
+// live resources that are no longer desired. Resources are matched by group, kind,
+// namespace and name, the namespaced desired resources without a namespace are set
+// to the given namespace. Live resources owned by another object (e.g. the ReplicaSets
+// of a Deployment) are managed by their owner and are skipped.
+//
+// A desired resource is modified when a server-side apply dry-run changes the fields
+// owned by our field manager, so the defaults and the fields of other managers are ignored.
+func (k *k8s) DiffResources(
+	ctx context.Context,
+	live []*unstructured.Unstructured,
+	desired []*unstructured.Unstructured,
+	namespace string,
+	ignore *IgnoreDifferences,
+) ([]ResourceDiff, error) {
+	var diffs []ResourceDiff
+
+	liveByKey := make(map[resourceKey]*unstructured.Unstructured, len(live))
+	for _, l := range live {
+		liveByKey[newResourceKey(l)] = l
+	}
+
+	matched := make(map[*unstructured.Unstructured]bool)
+	for _, d := range desired {
+		diff := ResourceDiff{Desired: d}
+
+		// Kinds that are not served yet, e.g. defined by a CRD of the application, can't exist
+		apiResource, err := ServerResourceForGroupVersionKind(k.discoveryClient, d.GroupVersionKind(), "patch")
+		if err != nil {
+			log.Debugf("Found new resource %s with name %s of an unknown kind: %s", d.GetKind(), d.GetName(), err)
+			ignore.Normalize(d)
+			diffs = append(diffs, diff)
+			continue
+		}
+		if apiResource.Namespaced && d.GetNamespace() == "" {
+			d.SetNamespace(namespace)
+		}
+		ignore.Normalize(d)
+
+		diff.Live = liveByKey[newResourceKey(d)]
+		if diff.Live == nil {
+			log.Debugf("Found new resource %s with name %s", d.GetKind(), d.GetName())
+			diffs = append(diffs, diff)
+			continue
+		}
+		matched[diff.Live] = true
+
+		diff.Predicted, diff.Changes, err = k.diffResource(ctx, apiResource, diff.Live, d, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("error diffing %s %s: %w", d.GetKind(), d.GetName(), err)
+		}
+		if len(diff.Changes) > 0 {
+			log.Debugf("Resource %s with name %s has changed: %s", d.GetKind(), d.GetName(), strings.Join(diff.ModifiedFields(), ", "))
+			diff.Modified = true
+			if isSecret(diff) {
+				maskSecretChanges(diff.Changes)
 			}
-		}(group)
+		}
+		diffs = append(diffs, diff)
+	}
+
+	for _, l := range live {
+		if matched[l] || len(l.GetOwnerReferences()) > 0 {
+			continue
+		}
+		log.Debugf("Resource %s with name %s is no longer desired", l.GetKind(), l.GetName())
+		diffs = append(diffs, ResourceDiff{Live: l})
 	}
-	wg.Wait()
 
-	return objs, apiError
+	return diffs, nil
 }
 
-func (k *k8s) DiffResources(current []*unstructured.Unstructured, new []*unstructured.Unstructured) (bool, error) {
-	isChanged := false
+// diffResource returns the resource predicted by a server-side apply dry-run of
+// the desired resource, with the changes of the fields owned by our field manager
+func (k *k8s) diffResource(
+	ctx context.Context,
+	apiResource *metav1.APIResource,
+	live *unstructured.Unstructured,
+	desired *unstructured.Unstructured,
+	ignore *IgnoreDifferences,
+) (*unstructured.Unstructured, []FieldChange, error) {
+	resource := desired.GroupVersionKind().GroupVersion().WithResource(apiResource.Name)
+	dynInterface := ToResourceInterface(k.dynClientSet, apiResource, resource, desired.GetNamespace())
+
+	// The dry-run returns the desired version, the live resource is compared in the same version
+	if live.GetAPIVersion() != desired.GetAPIVersion() {
+		converted, err := dynInterface.Get(ctx, desired.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		live = converted
+	}
+	// The ignored fields are compared as if they were equal
+	live = live.DeepCopy()
+	ignore.Normalize(live)
+
+	predicted, err := dynInterface.Apply(ctx, desired.GetName(), desired, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+		DryRun:       []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		// The apply reports the same error, e.g. the change of an immutable field
+		log.Warnf("Server-side dry-run of %s %s failed, comparing the manifest: %s", desired.GetKind(), desired.GetName(), err)
+		return nil, modifiedFields(live, desired), nil
+	}
 
-	// Should use a cache to store current resources
-	hashTable := make(map[string]*unstructured.Unstructured)
-	for _, c := range current {
-		hashTable[c.GetKind()+c.GetName()] = c
+	// The fields applied before and now, so the removed fields are compared too
+	set, err := managedFieldSet(predicted, FieldManager)
+	if err != nil {
+		return nil, nil, err
+	}
+	liveSet, err := managedFieldSet(live, FieldManager)
+	if err != nil {
+		return nil, nil, err
 	}
+	mergeFieldSets(set, liveSet)
 
-	for _, n := range new {
-		key := n.GetKind() + n.GetName()
-		c, ok := hashTable[key]
-		if !ok {
-			log.Debugf("Found new resource %s with name %s", n.GetKind(), n.GetName())
-			isChanged = true
+	// The predicted resource is returned as is, it is what the apply will do
+	normalized := predicted.DeepCopy()
+	ignore.Normalize(normalized)
+	return predicted, managedFieldsDiff(set, live, normalized), nil
+}
+
+// modifiedFields returns the changes of the fields set in the desired resource that have another
+// value in the live resource, when the server-side dry-run isn't possible. Fields only set in
+// the live resource, like the default values set by the API server, are not compared.
+func modifiedFields(live *unstructured.Unstructured, desired *unstructured.Unstructured) []FieldChange {
+	var changes []FieldChange
+	for key, value := range desired.Object {
+		switch key {
+		case "apiVersion", "kind", "status":
+			continue
+		case "metadata":
+			for _, field := range []string{"labels", "annotations"} {
+				desiredValue, found, _ := unstructured.NestedFieldNoCopy(desired.Object, "metadata", field)
+				if !found {
+					continue
+				}
+				liveValue, _, _ := unstructured.NestedFieldNoCopy(live.Object, "metadata", field)
+				changes = compareFields("metadata."+field, liveValue, desiredValue, changes)
+			}
 			continue
 		}
+		changes = compareFields(key, live.Object[key], value, changes)
+	}
+	sortChanges(changes)
+
+	return changes
+}
 
-		// TODO: handle kubernetes default values or else the comparison is useless
-		if !equality.Semantic.DeepEqual(c.Object["spec"], n.Object["spec"]) {
-			log.Debugf("Resource %s with name %s has changed", n.GetKind(), n.GetName())
-			isChanged = true
+func compareFields(path string, live interface{}, desired interface{}, changes []FieldChange) []FieldChange {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return append(changes, newFieldChange(path, live, desired))
+		}
+		for key, value := range d {
+			changes = compareFields(path+"."+key, l[key], value, changes)
+		}
+		return changes
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return append(changes, newFieldChange(path, live, desired))
 		}
-		delete(hashTable, key)
+		for i := range d {
+			changes = compareFields(fmt.Sprintf("%s[%d]", path, i), l[i], d[i], changes)
+		}
+		return changes
 	}
 
-	// Check if there are resources that need to be deleted
-	if len(hashTable) > 0 {
-		for _, c := range hashTable {
-			log.Debugf("Resource %s with name %s not found, could be deleted or a child resource", c.GetKind(), c.GetName())
+	// Numbers may be decoded with different types
+	if liveNumber, ok := toFloat(live); ok {
+		if desiredNumber, ok := toFloat(desired); ok && liveNumber == desiredNumber {
+			return changes
 		}
-		isChanged = true
 	}
+	if !equality.Semantic.DeepEqual(live, desired) {
+		return append(changes, newFieldChange(path, live, desired))
+	}
+	return changes
+}
 
-	return isChanged, nil
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
 }
 
+// SetLabelsForResources adds the labels to the resources, keeping their other labels
 func (k *k8s) SetLabelsForResources(resources []*unstructured.Unstructured, labels map[string]string) error {
 	for _, r := range resources {
-		r.SetLabels(labels)
+		merged := r.GetLabels()
+		if merged == nil {
+			merged = make(map[string]string, len(labels))
+		}
+		for key, value := range labels {
+			merged[key] = value
+		}
+		r.SetLabels(merged)
 	}
 
 	return nil
 }
 
+// ServerResourceForGroupVersionKind returns the API resource of the kind. A cached discovery
+// is refreshed once if the kind is missing, e.g. added by a CRD applied in the same sync.
 func ServerResourceForGroupVersionKind(disco discovery.DiscoveryInterface, gvk schema.GroupVersionKind, verb string) (*metav1.APIResource, error) {
+	apiResource, err := serverResourceForGroupVersionKind(disco, gvk, verb)
+	cached, ok := disco.(discovery.CachedDiscoveryInterface)
+	if ok && (apierr.IsNotFound(err) || errors.Is(err, memory.ErrCacheNotFound)) {
+		cached.Invalidate()
+		return serverResourceForGroupVersionKind(disco, gvk, verb)
+	}
+	return apiResource, err
+}
+
+func serverResourceForGroupVersionKind(disco discovery.DiscoveryInterface, gvk schema.GroupVersionKind, verb string) (*metav1.APIResource, error) {
 	// default is to return a not found for the requested resource
 	retErr := apierr.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, "")
 	resources, err := disco.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
@@ -1,62 +1,129 @@
 package k8s
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/minhthong582000/k8s-controller-pattern/gitops/common"
 	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	dynclientfake "k8s.io/client-go/dynamic/fake"
-	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 func Test_GenerateManifests(t *testing.T) {
 	var testCases = []struct {
-		name        string
-		testPath    string
-		expectedErr string
+		name          string
+		testPath      string
+		files         map[string]string
+		expectedKinds []string
+		expectedErr   string
 	}{
 		{
-			name:        "Should generate manifests",
-			testPath:    filepath.Join(".", "testdata"),
-			expectedErr: "",
+			name:     "Should generate manifests",
+			testPath: filepath.Join(".", "testdata"),
+			expectedKinds: []string{
+				"Deployment",
+				"ConfigMap", "ConfigMap",
+				"Deployment", "Service",
+				"ClusterRole", "ClusterRoleBinding", "ServiceAccount",
+			},
+		},
+		{
+			name:          "Should decode every document in a file",
+			testPath:      filepath.Join(".", "testdata", "multidoc"),
+			expectedKinds: []string{"Deployment", "Service"},
+		},
+		{
+			name:          "Should expand List kinds into their items",
+			testPath:      filepath.Join(".", "testdata", "list"),
+			expectedKinds: []string{"ConfigMap", "ConfigMap"},
 		},
 		{
 			name:        "Should return error when the path is invalid",
 			testPath:    "invalid-path",
 			expectedErr: "lstat invalid-path: no such file or directory",
 		},
+		{
+			name: "Should return error with the file path and document index when a document is invalid",
+			files: map[string]string{
+				"broken.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: valid\n---\napiVersion: v1\nkind: [\n",
+			},
+			expectedErr: "error parsing %s: document 1: error converting YAML to JSON: yaml: line 2: did not find expected node content",
+		},
+		{
+			name: "Should return error when a document has no kind",
+			files: map[string]string{
+				"broken.yaml": "apiVersion: v1\nmetadata:\n  name: no-kind\n",
+			},
+			expectedErr: "error parsing %s: document 0: Object 'Kind' is missing",
+		},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
+			testPath := tt.testPath
+			expectedErr := tt.expectedErr
+			if tt.files != nil {
+				testPath = t.TempDir()
+				for name, content := range tt.files {
+					filePath := filepath.Join(testPath, name)
+					assert.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+					expectedErr = fmt.Sprintf(expectedErr, filePath)
+				}
+			}
+
 			k8sUtil := NewK8s(nil, nil)
-			objs, err := k8sUtil.GenerateManifests(tt.testPath)
+			objs, err := k8sUtil.GenerateManifests(testPath)
 			if err != nil {
-				assert.Equal(t, tt.expectedErr, err.Error())
+				assert.Equal(t, expectedErr, err.Error())
 				return
 			}
+			assert.Empty(t, expectedErr)
 
-			assert.NotEmpty(t, objs)
-			assert.Len(t, objs, 4) // 4 is the number of resources in the /testdata directory
+			var kinds []string
 			for _, obj := range objs {
 				assert.NotEmpty(t, obj)
+				kinds = append(kinds, obj.GetKind())
 			}
+			assert.Equal(t, tt.expectedKinds, kinds)
 		})
 	}
 }
 
+func Test_DecodeManifests(t *testing.T) {
+	content := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: nginx\nspec:\n  replicas: 3\n"
+
+	objs, err := DecodeManifests([]byte(content))
+	assert.NoError(t, err)
+	assert.Len(t, objs, 1)
+
+	// Integers must be decoded as int64 to be comparable with the live objects
+	replicas, found, err := unstructured.NestedInt64(objs[0].Object, "spec", "replicas")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(3), replicas)
+}
+
 func Test_DiffResources(t *testing.T) {
 	var testCases = []struct {
 		name           string
 		current        []*unstructured.Unstructured
 		new            []*unstructured.Unstructured
-		expectedResult bool
+		expectedResult []string
 	}{
 		{
-			name: "Should return false when there are no differences between the resources",
+			name: "Should mark the resource synced when there are no differences",
 			current: []*unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
@@ -79,10 +146,10 @@ func Test_DiffResources(t *testing.T) {
 					},
 				},
 			},
-			expectedResult: false,
+			expectedResult: []string{"Pod/nginx: Synced"},
 		},
 		{
-			name: "Should return true when there are differences between the resources",
+			name: "Should mark the resource modified when there are differences",
 			current: []*unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
@@ -92,8 +159,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "nginx",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "nginx",
 									"image": "nginx:1.26",
 								},
@@ -111,8 +178,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "nginx",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "nginx",
 									"image": "nginx:latest",
 								},
@@ -121,10 +188,10 @@ func Test_DiffResources(t *testing.T) {
 					},
 				},
 			},
-			expectedResult: true,
+			expectedResult: []string{"Pod/nginx: OutOfSync"},
 		},
 		{
-			name: "Should return true when resources exist in the current but not in the new",
+			name: "Should mark the resources missing or orphaned when they only exist on one side",
 			current: []*unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
@@ -134,8 +201,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "nginx",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "nginx",
 									"image": "nginx:1.26",
 								},
@@ -153,8 +220,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "apache",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "apache",
 									"image": "httpd:1.1",
 								},
@@ -163,10 +230,10 @@ func Test_DiffResources(t *testing.T) {
 					},
 				},
 			},
-			expectedResult: true,
+			expectedResult: []string{"Pod/apache: Missing", "Pod/nginx: Orphaned"},
 		},
 		{
-			name: "Should return true when resources exist in the current but new is empty",
+			name: "Should mark the resources orphaned when new is empty",
 			current: []*unstructured.Unstructured{
 				{
 					Object: map[string]interface{}{
@@ -176,8 +243,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "nginx",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "nginx",
 									"image": "nginx:1.26",
 								},
@@ -187,10 +254,10 @@ func Test_DiffResources(t *testing.T) {
 				},
 			},
 			new:            []*unstructured.Unstructured{},
-			expectedResult: true,
+			expectedResult: []string{"Pod/nginx: Orphaned"},
 		},
 		{
-			name:    "Should return true when resources exist in new but not in the current",
+			name:    "Should mark the resources missing when current is empty",
 			current: []*unstructured.Unstructured{},
 			new: []*unstructured.Unstructured{
 				{
@@ -201,8 +268,8 @@ func Test_DiffResources(t *testing.T) {
 							"name": "nginx",
 						},
 						"spec": map[string]interface{}{
-							"containers": []map[string]interface{}{
-								{
+							"containers": []interface{}{
+								map[string]interface{}{
 									"name":  "nginx",
 									"image": "nginx:1.26",
 								},
@@ -211,16 +278,16 @@ func Test_DiffResources(t *testing.T) {
 					},
 				},
 			},
-			expectedResult: true,
+			expectedResult: []string{"Pod/nginx: Missing"},
 		},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			k8sUtil := NewK8s(nil, nil)
-			result, err := k8sUtil.DiffResources(tt.current, tt.new)
+			k8sUtil := newFakeK8s(applyOnto(tt.current))
+			diffs, err := k8sUtil.DiffResources(context.Background(), tt.current, tt.new, "", nil)
 			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedResult, result)
+			assert.Equal(t, tt.expectedResult, describeDiffs(diffs))
 		})
 	}
 }
@@ -267,6 +334,43 @@ func Test_SetLabelsForResources(t *testing.T) {
 			},
 			expectedErr: "",
 		},
+		{
+			name: "Should keep the labels of the resources",
+			resources: []*unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name": "nginx",
+							"labels": map[string]interface{}{
+								"app.kubernetes.io/name":   "nginx",
+								common.LabelKeyAppInstance: "other-app",
+							},
+						},
+					},
+				},
+			},
+			labels: map[string]string{
+				common.LabelKeyAppInstance: "example-app",
+			},
+			expectedOutput: []*unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name": "nginx",
+							"labels": map[string]interface{}{
+								"app.kubernetes.io/name":   "nginx",
+								common.LabelKeyAppInstance: "example-app",
+							},
+						},
+					},
+				},
+			},
+			expectedErr: "",
+		},
 	}
 
 	for _, tt := range testCases {
@@ -283,39 +387,705 @@ func Test_SetLabelsForResources(t *testing.T) {
 	}
 }
 
-func Test_GetResourceWithLabel(t *testing.T) {
+func Test_DiffResources_Matching(t *testing.T) {
+	newObj := func(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj
+	}
+
+	ownedReplicaSet := newObj("apps/v1", "ReplicaSet", "default", "nginx-7c5ddbdf54")
+	ownedReplicaSet.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "nginx"},
+	})
+
 	var testCases = []struct {
-		name              string
-		label             map[string]string
-		expectedResources []*unstructured.Unstructured
-		expectedErr       string
+		name          string
+		live          []*unstructured.Unstructured
+		desired       []*unstructured.Unstructured
+		expectedDiffs []string
 	}{
 		{
-			name:        "Should return error when the label is empty",
-			label:       map[string]string{},
-			expectedErr: "label is empty",
+			name: "Should set the namespace of the namespaced desired resources without namespace",
+			live: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "default", "nginx"),
+				newObj("v1", "Service", "default", "nginx"),
+				newObj("v1", "Namespace", "", "nginx"),
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "", "nginx"),
+				newObj("v1", "Service", "default", "nginx"),
+				newObj("v1", "Namespace", "", "nginx"),
+			},
+			expectedDiffs: []string{"Deployment/nginx: Synced", "Service/nginx: Synced", "Namespace/nginx: Synced"},
 		},
 		{
-			name:        "Should return error when the label is missing",
-			label:       nil,
-			expectedErr: "label is empty",
+			name: "Should not mix up the resources with the same name in different namespaces",
+			live: []*unstructured.Unstructured{
+				newObj("v1", "Service", "staging", "nginx"),
+				newObj("v1", "Service", "production", "nginx"),
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("v1", "Service", "production", "nginx"),
+				newObj("v1", "Service", "staging", "nginx"),
+			},
+			expectedDiffs: []string{"Service/nginx: Synced", "Service/nginx: Synced"},
+		},
+		{
+			name: "Should mark the resources of unknown kinds missing",
+			desired: []*unstructured.Unstructured{
+				newObj("example.com/v1", "Database", "default", "postgres"),
+			},
+			expectedDiffs: []string{"Database/postgres: Missing"},
+		},
+		{
+			name: "Should return the live resources that are not desired as orphaned",
+			live: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "default", "nginx"),
+				newObj("v1", "Service", "default", "nginx"),
+				newObj("v1", "ConfigMap", "default", "nginx"),
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "default", "nginx"),
+			},
+			expectedDiffs: []string{"Deployment/nginx: Synced", "Service/nginx: Orphaned", "ConfigMap/nginx: Orphaned"},
+		},
+		{
+			name: "Should compare the namespace when the desired resource has one",
+			live: []*unstructured.Unstructured{
+				newObj("v1", "Service", "staging", "nginx"),
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("v1", "Service", "production", "nginx"),
+			},
+			expectedDiffs: []string{"Service/nginx: Missing", "Service/nginx: Orphaned"},
+		},
+		{
+			name: "Should compare the group of the resources",
+			live: []*unstructured.Unstructured{
+				newObj("networking.k8s.io/v1", "Ingress", "default", "nginx"),
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("extensions/v1beta1", "Ingress", "default", "nginx"),
+			},
+			expectedDiffs: []string{"Ingress/nginx: Missing", "Ingress/nginx: Orphaned"},
+		},
+		{
+			name: "Should skip resources owned by another resource",
+			live: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "default", "nginx"),
+				ownedReplicaSet,
+			},
+			desired: []*unstructured.Unstructured{
+				newObj("apps/v1", "Deployment", "default", "nginx"),
+			},
+			expectedDiffs: []string{"Deployment/nginx: Synced"},
 		},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			clientSet := fake.NewSimpleClientset()
-			discoveryClient := clientSet.Discovery()
-			dynClientSet := dynclientfake.NewSimpleDynamicClient(runtime.NewScheme())
-			k8sUtil := NewK8s(discoveryClient, dynClientSet)
+			diffs, err := newFakeK8s(applyOnto(tt.live)).DiffResources(context.Background(), tt.live, tt.desired, "default", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDiffs, describeDiffs(diffs))
+		})
+	}
+}
 
-			resources, err := k8sUtil.GetResourceWithLabel(tt.label)
-			if err != nil {
-				assert.Equal(t, tt.expectedErr, err.Error())
-				return
+// describeDiffs summarizes the diffs as "<kind>/<name>: <sync status>"
+func describeDiffs(diffs []ResourceDiff) []string {
+	var result []string
+	for _, d := range diffs {
+		switch {
+		case d.Desired == nil:
+			result = append(result, d.Live.GetKind()+"/"+d.Live.GetName()+": Orphaned")
+		case d.Live == nil:
+			result = append(result, d.Desired.GetKind()+"/"+d.Desired.GetName()+": Missing")
+		case d.Modified:
+			result = append(result, d.Desired.GetKind()+"/"+d.Desired.GetName()+": OutOfSync")
+		default:
+			result = append(result, d.Desired.GetKind()+"/"+d.Desired.GetName()+": Synced")
+		}
+	}
+	return result
+}
+
+func Test_DiffResources_ManagedFields(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		live           string
+		desired        string
+		predicted      string
+		dryRunErr      error
+		expectedFields []string
+	}{
+		{
+			name: "Should ignore the defaults and the fields of other managers",
+			live: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+  namespace: default
+  annotations:
+    cloud.example.com/load-balancer-id: lb-42
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:ports": {"k:{\"port\":80,\"protocol\":\"TCP\"}": {".": {}, "f:port": {}}}}}
+    - manager: cloud-controller
+      operation: Update
+      apiVersion: v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:metadata": {"f:annotations": {"f:cloud.example.com/load-balancer-id": {}}}}
+spec:
+  type: ClusterIP
+  clusterIP: 10.96.0.42
+  ports:
+    - port: 80
+      protocol: TCP
+      targetPort: 80
+`,
+			desired: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  ports:
+    - port: 80
+`,
+			predicted: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+  namespace: default
+  annotations:
+    cloud.example.com/load-balancer-id: lb-42
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:ports": {"k:{\"port\":80,\"protocol\":\"TCP\"}": {".": {}, "f:port": {}}}}}
+spec:
+  type: ClusterIP
+  clusterIP: 10.96.0.42
+  ports:
+    - port: 80
+      protocol: TCP
+      targetPort: 80
+`,
+		},
+		{
+			name: "Should return the owned fields changed by another manager",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: apps/v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:template": {"f:spec": {"f:containers": {"k:{\"name\":\"nginx\"}": {".": {}, "f:name": {}}}}}}}
+    - manager: kubectl-edit
+      operation: Update
+      apiVersion: apps/v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:template": {"f:spec": {"f:containers": {"k:{\"name\":\"nginx\"}": {"f:image": {}}}}}}}
+spec:
+  template:
+    spec:
+      containers:
+        - name: sidecar
+          image: envoy:1.30
+        - name: nginx
+          image: nginx:1.25
+`,
+			desired: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+        - name: nginx
+          image: nginx:1.27
+`,
+			predicted: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: apps/v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:template": {"f:spec": {"f:containers": {"k:{\"name\":\"nginx\"}": {".": {}, "f:name": {}, "f:image": {}}}}}}}
+spec:
+  template:
+    spec:
+      containers:
+        - name: sidecar
+          image: envoy:1.30
+        - name: nginx
+          image: nginx:1.27
+`,
+			expectedFields: []string{"spec.template.spec.containers[1].image"},
+		},
+		{
+			name: "Should return the fields removed from the manifest",
+			live: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:data": {"f:key": {}, "f:old": {}}}
+data:
+  key: value
+  old: value
+`,
+			desired: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+data:
+  key: value
+`,
+			predicted: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+  namespace: default
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:data": {"f:key": {}}}
+data:
+  key: value
+`,
+			expectedFields: []string{"data.old"},
+		},
+		{
+			name: "Should compare the manifest if the dry-run fails",
+			live: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+        - name: migrate
+          image: migrate:1.0
+`,
+			desired: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+        - name: migrate
+          image: migrate:2.0
+`,
+			dryRunErr:      fmt.Errorf("field is immutable"),
+			expectedFields: []string{"spec.template.spec.containers[0].image"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			live, err := DecodeManifests([]byte(tt.live))
+			assert.NoError(t, err)
+			desired, err := DecodeManifests([]byte(tt.desired))
+			assert.NoError(t, err)
+
+			k8sUtil := newFakeK8s(func(*unstructured.Unstructured) (*unstructured.Unstructured, error) {
+				if tt.dryRunErr != nil {
+					return nil, tt.dryRunErr
+				}
+				predicted, err := DecodeManifests([]byte(tt.predicted))
+				if err != nil {
+					return nil, err
+				}
+				return predicted[0], nil
+			})
+			diffs, err := k8sUtil.DiffResources(context.Background(), live, desired, "default", nil)
+			assert.NoError(t, err)
+			assert.Len(t, diffs, 1)
+			assert.Equal(t, len(tt.expectedFields) > 0, diffs[0].Modified)
+			assert.Equal(t, tt.expectedFields, diffs[0].ModifiedFields())
+		})
+	}
+}
+
+func Test_DiffResources_IgnoreDifferences(t *testing.T) {
+	live := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+  managedFields:
+    - manager: application/apply-patch
+      operation: Apply
+      apiVersion: apps/v1
+      fieldsType: FieldsV1
+      fieldsV1: {"f:spec": {"f:replicas": {}}}
+spec:
+  replicas: 5
+`
+	desired := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+spec:
+  replicas: 2
+`
+	var testCases = []struct {
+		name            string
+		rules           []IgnoreRule
+		expectedFields  []string
+		expectedApplied bool
+	}{
+		{
+			name:           "Should ignore the fields selected by the rules and leave them out of the desired resource",
+			rules:          []IgnoreRule{{Group: "apps", Kind: "Deployment", JSONPointers: []string{"/spec/replicas"}}},
+			expectedFields: nil,
+		},
+		{
+			name:            "Should compare the fields of the resources not selected by the rules",
+			rules:           []IgnoreRule{{Group: "apps", Kind: "Deployment", Name: "other", JSONPaths: []string{".spec.replicas"}}},
+			expectedFields:  []string{"spec.replicas"},
+			expectedApplied: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			liveObjs, err := DecodeManifests([]byte(live))
+			assert.NoError(t, err)
+			desiredObjs, err := DecodeManifests([]byte(desired))
+			assert.NoError(t, err)
+			ignore, err := NewIgnoreDifferences(tt.rules)
+			assert.NoError(t, err)
+
+			diffs, err := newFakeK8s(applyOnto(liveObjs)).DiffResources(context.Background(), liveObjs, desiredObjs, "default", ignore)
+			assert.NoError(t, err)
+			assert.Len(t, diffs, 1)
+			assert.Equal(t, tt.expectedFields, diffs[0].ModifiedFields())
+			_, applied, _ := unstructured.NestedFieldNoCopy(desiredObjs[0].Object, "spec", "replicas")
+			assert.Equal(t, tt.expectedApplied, applied)
+		})
+	}
+}
+
+func Test_DiffResources_ModifiedFields(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		live           string
+		desired        string
+		expectedFields []string
+	}{
+		{
+			name: "Should ignore the fields only set in the live resource",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  resourceVersion: "42"
+  labels:
+    app: nginx
+spec:
+  replicas: 2
+  progressDeadlineSeconds: 600
+status:
+  replicas: 2
+`,
+			desired: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  labels:
+    app: nginx
+spec:
+  replicas: 2
+`,
+		},
+		{
+			name: "Should return the paths of the modified fields",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  labels:
+    app: nginx
+spec:
+  replicas: 5
+  template:
+    spec:
+      containers:
+        - name: nginx
+          image: nginx:1.26
+`,
+			desired: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  labels:
+    app: nginx
+    tier: frontend
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: nginx
+          image: nginx:1.27
+`,
+			expectedFields: []string{
+				"metadata.labels.tier",
+				"spec.replicas",
+				"spec.template.spec.containers[0].image",
+			},
+		},
+		{
+			name: "Should compare the fields outside of the spec",
+			live: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+data:
+  key: old
+`,
+			desired: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nginx
+data:
+  key: new
+`,
+			expectedFields: []string{"data.key"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			live, err := DecodeManifests([]byte(tt.live))
+			assert.NoError(t, err)
+			desired, err := DecodeManifests([]byte(tt.desired))
+			assert.NoError(t, err)
+
+			diffs, err := newFakeK8s(applyOnto(live)).DiffResources(context.Background(), live, desired, "", nil)
+			assert.NoError(t, err)
+			assert.Len(t, diffs, 1)
+			assert.Equal(t, len(tt.expectedFields) > 0, diffs[0].Modified)
+			assert.Equal(t, tt.expectedFields, diffs[0].ModifiedFields())
+		})
+	}
+}
+
+// newFakeK8s returns a K8s serving a few built-in kinds, whose server-side apply
+// dry-runs return the resource predicted by the given function
+func newFakeK8s(predict func(applied *unstructured.Unstructured) (*unstructured.Unstructured, error)) *k8s {
+	verbs := metav1.Verbs{"get", "list", "watch", "create", "patch", "delete"}
+	discoveryClient := &fakediscovery.FakeDiscovery{
+		Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: verbs},
+						{Name: "services", Kind: "Service", Namespaced: true, Verbs: verbs},
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: verbs},
+						{Name: "namespaces", Kind: "Namespace", Verbs: verbs},
+					},
+				},
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: verbs},
+						{Name: "replicasets", Kind: "ReplicaSet", Namespaced: true, Verbs: verbs},
+					},
+				},
+				{
+					GroupVersion: "batch/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "jobs", Kind: "Job", Namespaced: true, Verbs: verbs},
+					},
+				},
+				{
+					GroupVersion: "networking.k8s.io/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "ingresses", Kind: "Ingress", Namespaced: true, Verbs: verbs},
+					},
+				},
+				{
+					GroupVersion: "extensions/v1beta1",
+					APIResources: []metav1.APIResource{
+						{Name: "ingresses", Kind: "Ingress", Namespaced: true, Verbs: verbs},
+					},
+				},
+			},
+		},
+	}
+
+	dynClientSet := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dynClientSet.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		applied := &unstructured.Unstructured{}
+		if err := json.Unmarshal(action.(clienttesting.PatchAction).GetPatch(), &applied.Object); err != nil {
+			return true, nil, err
+		}
+		predicted, err := predict(applied)
+		return true, predicted, err
+	})
+
+	return NewK8s(discoveryClient, dynClientSet)
+}
+
+// applyOnto predicts the server-side apply of a resource by merging it into the live
+// resource, the applied fields are owned by our field manager
+func applyOnto(live []*unstructured.Unstructured) func(applied *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return func(applied *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		predicted := applied.DeepCopy()
+		for _, l := range live {
+			if newResourceKey(l) == newResourceKey(applied) {
+				predicted.Object = mergeValues(l.DeepCopy().Object, applied.Object).(map[string]interface{})
 			}
+		}
 
-			assert.Equal(t, tt.expectedResources, resources)
+		fieldsV1, err := json.Marshal(fieldSetOf(applied.Object))
+		if err != nil {
+			return nil, err
+		}
+		predicted.SetManagedFields([]metav1.ManagedFieldsEntry{
+			{
+				Manager:    FieldManager,
+				Operation:  metav1.ManagedFieldsOperationApply,
+				APIVersion: applied.GetAPIVersion(),
+				FieldsType: "FieldsV1",
+				FieldsV1:   &metav1.FieldsV1{Raw: fieldsV1},
+			},
 		})
+		return predicted, nil
+	}
+}
+
+// mergeValues merges the maps, the other values are replaced
+func mergeValues(dst interface{}, src interface{}) interface{} {
+	dstMap, dstOk := dst.(map[string]interface{})
+	srcMap, srcOk := src.(map[string]interface{})
+	if !dstOk || !srcOk {
+		return runtime.DeepCopyJSONValue(src)
+	}
+	for key, value := range srcMap {
+		dstMap[key] = mergeValues(dstMap[key], value)
+	}
+	return dstMap
+}
+
+// fieldSetOf returns the fields set in the value, in the FieldsV1 format
+func fieldSetOf(value interface{}) map[string]interface{} {
+	set := map[string]interface{}{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key == "apiVersion" || key == "kind" {
+				continue
+			}
+			set["f:"+key] = fieldSetOf(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			set[fmt.Sprintf("i:%d", i)] = fieldSetOf(child)
+		}
 	}
+	return set
+}
+
+func Test_ServerResourceForGroupVersionKind_Cached(t *testing.T) {
+	fake := &clienttesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"get", "patch"}},
+				},
+			},
+		},
+	}
+	discoveryClient := memory.NewMemCacheClient(&fakediscovery.FakeDiscovery{Fake: fake})
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	databaseGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"}
+
+	// The kinds are discovered once
+	_, err := ServerResourceForGroupVersionKind(discoveryClient, configMapGVK, "patch")
+	assert.NoError(t, err)
+	discoveries := len(fake.Actions())
+	for i := 0; i < 3; i++ {
+		apiResource, err := ServerResourceForGroupVersionKind(discoveryClient, configMapGVK, "patch")
+		assert.NoError(t, err)
+		assert.Equal(t, "configmaps", apiResource.Name)
+	}
+	assert.Equal(t, discoveries, len(fake.Actions()))
+
+	// A kind added since is found by discovering again
+	fake.Resources = append(fake.Resources, &metav1.APIResourceList{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "databases", Kind: "Database", Namespaced: true, Verbs: metav1.Verbs{"get", "patch"}},
+		},
+	})
+	apiResource, err := ServerResourceForGroupVersionKind(discoveryClient, databaseGVK, "patch")
+	assert.NoError(t, err)
+	assert.Equal(t, "databases", apiResource.Name)
+	assert.Greater(t, len(fake.Actions()), discoveries)
+
+	// A kind that doesn't exist is still not found
+	_, err = ServerResourceForGroupVersionKind(discoveryClient, schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Table"}, "patch")
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// The verbs are still checked
+	_, err = ServerResourceForGroupVersionKind(discoveryClient, configMapGVK, "delete")
+	assert.True(t, apierrors.IsMethodNotSupported(err))
 }
@@ -0,0 +1,162 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// managedFieldSet returns the fields applied by the field manager, in the FieldsV1 format, e.g.
+//
+//	{"f:spec": {"f:containers": {"k:{\"name\":\"nginx\"}": {".": {}, "f:image": {}}}}}
+func managedFieldSet(obj *unstructured.Unstructured, manager string) (map[string]interface{}, error) {
+	set := map[string]interface{}{}
+	for _, entry := range obj.GetManagedFields() {
+		// The paths of the fields depend on the version they were applied with
+		if entry.Manager != manager || entry.Operation != metav1.ManagedFieldsOperationApply ||
+			entry.APIVersion != obj.GetAPIVersion() || entry.FieldsV1 == nil {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return nil, fmt.Errorf("error decoding managed fields of %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		mergeFieldSets(set, fields)
+	}
+
+	return set, nil
+}
+
+func mergeFieldSets(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		srcChild, _ := value.(map[string]interface{})
+		dstChild, ok := dst[key].(map[string]interface{})
+		if !ok {
+			dst[key] = srcChild
+			continue
+		}
+		mergeFieldSets(dstChild, srcChild)
+	}
+}
+
+// managedFieldsDiff returns the changes of the fields of the set between the
+// live resource and the resource predicted by a server-side apply
+func managedFieldsDiff(set map[string]interface{}, live *unstructured.Unstructured, predicted *unstructured.Unstructured) []FieldChange {
+	changes := compareManagedFields("", set, live.Object, predicted.Object, nil)
+
+	sortChanges(changes)
+	return slices.CompactFunc(changes, func(a, b FieldChange) bool {
+		return a.Path == b.Path
+	})
+}
+
+func compareManagedFields(path string, set map[string]interface{}, live interface{}, predicted interface{}, changes []FieldChange) []FieldChange {
+	// A leaf of the set owns the whole value
+	if len(set) == 0 {
+		if !valuesEqual(live, predicted) {
+			return append(changes, newFieldChange(path, live, predicted))
+		}
+		return changes
+	}
+
+	// The element itself is owned, e.g. an item of a list
+	if _, ok := set["."]; ok && (live == nil) != (predicted == nil) {
+		return append(changes, newFieldChange(path, live, predicted))
+	}
+
+	for key, value := range set {
+		child, _ := value.(map[string]interface{})
+		switch {
+		case strings.HasPrefix(key, "f:"):
+			name := strings.TrimPrefix(key, "f:")
+			liveMap, _ := live.(map[string]interface{})
+			predictedMap, _ := predicted.(map[string]interface{})
+			childPath := name
+			if path != "" {
+				childPath = path + "." + name
+			}
+			changes = compareManagedFields(childPath, child, liveMap[name], predictedMap[name], changes)
+		case strings.HasPrefix(key, "k:"):
+			var itemKey map[string]interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "k:")), &itemKey); err != nil {
+				continue
+			}
+			match := func(item interface{}) bool {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					return false
+				}
+				for k, v := range itemKey {
+					if !valuesEqual(itemMap[k], v) {
+						return false
+					}
+				}
+				return true
+			}
+			changes = compareListItems(path, child, live, predicted, match, changes)
+		case strings.HasPrefix(key, "v:"):
+			var setValue interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "v:")), &setValue); err != nil {
+				continue
+			}
+			match := func(item interface{}) bool {
+				return valuesEqual(item, setValue)
+			}
+			changes = compareListItems(path, child, live, predicted, match, changes)
+		case strings.HasPrefix(key, "i:"):
+			index, err := strconv.Atoi(strings.TrimPrefix(key, "i:"))
+			if err != nil {
+				continue
+			}
+			changes = compareManagedFields(fmt.Sprintf("%s[%d]", path, index), child, listItem(live, index), listItem(predicted, index), changes)
+		}
+	}
+
+	return changes
+}
+
+// compareListItems compares the items of the live and predicted lists matching an element of the set
+func compareListItems(path string, set map[string]interface{}, live interface{}, predicted interface{}, match func(interface{}) bool, changes []FieldChange) []FieldChange {
+	liveIndex, liveItem := findListItem(live, match)
+	predictedIndex, predictedItem := findListItem(predicted, match)
+
+	index := predictedIndex
+	if index < 0 {
+		index = liveIndex
+	}
+	return compareManagedFields(fmt.Sprintf("%s[%d]", path, index), set, liveItem, predictedItem, changes)
+}
+
+func findListItem(list interface{}, match func(interface{}) bool) (int, interface{}) {
+	items, _ := list.([]interface{})
+	for i, item := range items {
+		if match(item) {
+			return i, item
+		}
+	}
+	return -1, nil
+}
+
+func listItem(list interface{}, index int) interface{} {
+	items, _ := list.([]interface{})
+	if index < 0 || index >= len(items) {
+		return nil
+	}
+	return items[index]
+}
+
+// valuesEqual compares JSON values, numbers may be decoded with different types
+func valuesEqual(a interface{}, b interface{}) bool {
+	if aNumber, ok := toFloat(a); ok {
+		bNumber, ok := toFloat(b)
+		return ok && aNumber == bNumber
+	}
+	return equality.Semantic.DeepEqual(a, b)
+}
@@ -5,3 +5,4 @@ import (
 )
 
 //go:generate mockgen -destination=mock_kube.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube K8s
+//go:generate mockgen -destination=mock_health.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube HealthChecker
@@ -0,0 +1,70 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube (interfaces: HealthChecker)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock_health.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube HealthChecker
+//
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+
+	k8s "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
+	gomock "go.uber.org/mock/gomock"
+	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MockHealthChecker is a mock of HealthChecker interface.
+type MockHealthChecker struct {
+	ctrl     *gomock.Controller
+	recorder *MockHealthCheckerMockRecorder
+}
+
+// MockHealthCheckerMockRecorder is the mock recorder for MockHealthChecker.
+type MockHealthCheckerMockRecorder struct {
+	mock *MockHealthChecker
+}
+
+// NewMockHealthChecker creates a new mock instance.
+func NewMockHealthChecker(ctrl *gomock.Controller) *MockHealthChecker {
+	mock := &MockHealthChecker{ctrl: ctrl}
+	mock.recorder = &MockHealthCheckerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHealthChecker) EXPECT() *MockHealthCheckerMockRecorder {
+	return m.recorder
+}
+
+// GetResourceHealth mocks base method.
+func (m *MockHealthChecker) GetResourceHealth(arg0 *unstructured.Unstructured) (*k8s.HealthStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetResourceHealth", arg0)
+	ret0, _ := ret[0].(*k8s.HealthStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetResourceHealth indicates an expected call of GetResourceHealth.
+func (mr *MockHealthCheckerMockRecorder) GetResourceHealth(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResourceHealth", reflect.TypeOf((*MockHealthChecker)(nil).GetResourceHealth), arg0)
+}
+
+// SetCustomHealthChecks mocks base method.
+func (m *MockHealthChecker) SetCustomHealthChecks(arg0 map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetCustomHealthChecks", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetCustomHealthChecks indicates an expected call of SetCustomHealthChecks.
+func (mr *MockHealthCheckerMockRecorder) SetCustomHealthChecks(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCustomHealthChecks", reflect.TypeOf((*MockHealthChecker)(nil).SetCustomHealthChecks), arg0)
+}
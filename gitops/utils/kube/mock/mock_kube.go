@@ -13,7 +13,9 @@ import (
 	context "context"
 	reflect "reflect"
 
+	k8s "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kube"
 	gomock "go.uber.org/mock/gomock"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -41,11 +43,12 @@ func (m *MockK8s) EXPECT() *MockK8sMockRecorder {
 }
 
 // CreateResource mocks base method.
-func (m *MockK8s) CreateResource(arg0 context.Context, arg1 *unstructured.Unstructured, arg2 string) error {
+func (m *MockK8s) CreateResource(arg0 context.Context, arg1 *unstructured.Unstructured, arg2 string) (*unstructured.Unstructured, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "CreateResource", arg0, arg1, arg2)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*unstructured.Unstructured)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // CreateResource indicates an expected call of CreateResource.
@@ -55,32 +58,32 @@ func (mr *MockK8sMockRecorder) CreateResource(arg0, arg1, arg2 any) *gomock.Call
 }
 
 // DeleteResource mocks base method.
-func (m *MockK8s) DeleteResource(arg0 context.Context, arg1 *unstructured.Unstructured, arg2 string) error {
+func (m *MockK8s) DeleteResource(arg0 context.Context, arg1 *unstructured.Unstructured, arg2 string, arg3 v1.DeleteOptions) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteResource", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "DeleteResource", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteResource indicates an expected call of DeleteResource.
-func (mr *MockK8sMockRecorder) DeleteResource(arg0, arg1, arg2 any) *gomock.Call {
+func (mr *MockK8sMockRecorder) DeleteResource(arg0, arg1, arg2, arg3 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteResource", reflect.TypeOf((*MockK8s)(nil).DeleteResource), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteResource", reflect.TypeOf((*MockK8s)(nil).DeleteResource), arg0, arg1, arg2, arg3)
 }
 
 // DiffResources mocks base method.
-func (m *MockK8s) DiffResources(arg0, arg1 []*unstructured.Unstructured) (bool, error) {
+func (m *MockK8s) DiffResources(arg0 context.Context, arg1, arg2 []*unstructured.Unstructured, arg3 string, arg4 *k8s.IgnoreDifferences) ([]k8s.ResourceDiff, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DiffResources", arg0, arg1)
-	ret0, _ := ret[0].(bool)
+	ret := m.ctrl.Call(m, "DiffResources", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].([]k8s.ResourceDiff)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // DiffResources indicates an expected call of DiffResources.
-func (mr *MockK8sMockRecorder) DiffResources(arg0, arg1 any) *gomock.Call {
+func (mr *MockK8sMockRecorder) DiffResources(arg0, arg1, arg2, arg3, arg4 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiffResources", reflect.TypeOf((*MockK8s)(nil).DiffResources), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiffResources", reflect.TypeOf((*MockK8s)(nil).DiffResources), arg0, arg1, arg2, arg3, arg4)
 }
 
 // GenerateManifests mocks base method.
@@ -98,21 +101,6 @@ func (mr *MockK8sMockRecorder) GenerateManifests(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateManifests", reflect.TypeOf((*MockK8s)(nil).GenerateManifests), arg0)
 }
 
-// GetResourceWithLabel mocks base method.
-func (m *MockK8s) GetResourceWithLabel(arg0 map[string]string) ([]*unstructured.Unstructured, error) {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetResourceWithLabel", arg0)
-	ret0, _ := ret[0].([]*unstructured.Unstructured)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
-}
-
-// GetResourceWithLabel indicates an expected call of GetResourceWithLabel.
-func (mr *MockK8sMockRecorder) GetResourceWithLabel(arg0 any) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResourceWithLabel", reflect.TypeOf((*MockK8s)(nil).GetResourceWithLabel), arg0)
-}
-
 // PatchResource mocks base method.
 func (m *MockK8s) PatchResource(arg0 context.Context, arg1 *unstructured.Unstructured, arg2 string) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,70 @@
+package k8s
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// installOrder is the order in which resource kinds are applied to the cluster,
+// resources that others depend on come first. Kinds not listed are applied last.
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+}
+
+var installOrderIndex = func() map[string]int {
+	index := make(map[string]int, len(installOrder))
+	for i, kind := range installOrder {
+		index[kind] = i
+	}
+	return index
+}()
+
+func kindRank(kind string) int {
+	if i, ok := installOrderIndex[kind]; ok {
+		return i
+	}
+	return len(installOrder)
+}
+
+// SortByInstallOrder sorts the resources so that dependencies are applied first
+func SortByInstallOrder(resources []*unstructured.Unstructured) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return kindRank(resources[i].GetKind()) < kindRank(resources[j].GetKind())
+	})
+}
+
+// SortByUninstallOrder sorts the resources so that dependents are deleted first
+func SortByUninstallOrder(resources []*unstructured.Unstructured) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return kindRank(resources[i].GetKind()) > kindRank(resources[j].GetKind())
+	})
+}
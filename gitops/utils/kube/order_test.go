@@ -0,0 +1,42 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newOrderTestResources(kinds ...string) []*unstructured.Unstructured {
+	var resources []*unstructured.Unstructured
+	for _, kind := range kinds {
+		obj := &unstructured.Unstructured{}
+		obj.SetKind(kind)
+		resources = append(resources, obj)
+	}
+	return resources
+}
+
+func resourceKinds(resources []*unstructured.Unstructured) []string {
+	var kinds []string
+	for _, r := range resources {
+		kinds = append(kinds, r.GetKind())
+	}
+	return kinds
+}
+
+func Test_SortByInstallOrder(t *testing.T) {
+	resources := newOrderTestResources("MyCustomKind", "Deployment", "Service", "ConfigMap", "Namespace")
+
+	SortByInstallOrder(resources)
+
+	assert.Equal(t, []string{"Namespace", "ConfigMap", "Service", "Deployment", "MyCustomKind"}, resourceKinds(resources))
+}
+
+func Test_SortByUninstallOrder(t *testing.T) {
+	resources := newOrderTestResources("Namespace", "Service", "MyCustomKind", "Deployment", "ConfigMap")
+
+	SortByUninstallOrder(resources)
+
+	assert.Equal(t, []string{"MyCustomKind", "Deployment", "Service", "ConfigMap", "Namespace"}, resourceKinds(resources))
+}
@@ -0,0 +1,154 @@
+package kustomize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/pkg/util"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+)
+
+type Kustomize interface {
+	Build(path string, opts *BuildOptions) ([]*unstructured.Unstructured, error)
+}
+
+// BuildOptions are applied on top of the kustomization being built
+type BuildOptions struct {
+	// Images overrides the images, in the same format as `kustomize edit set image`,
+	// e.g. nginx:1.27, nginx=my-registry/nginx:1.27 or nginx@sha256:...
+	Images       []string
+	NamePrefix   string
+	NameSuffix   string
+	CommonLabels map[string]string
+	Namespace    string
+}
+
+type kustomize struct{}
+
+func NewKustomize() *kustomize {
+	return &kustomize{}
+}
+
+// IsKustomization returns whether the directory contains a kustomization file
+func IsKustomization(path string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *kustomize) Build(path string, opts *BuildOptions) ([]*unstructured.Unstructured, error) {
+	buildPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The overrides are applied by an overlay that uses the kustomization as its base,
+	// so the files in the repository are never modified.
+	if opts != nil && !opts.isEmpty() {
+		overlayPath, err := os.MkdirTemp("", "kustomize-overlay-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+		defer os.RemoveAll(overlayPath)
+
+		err = writeOverlay(overlayPath, buildPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		buildPath = overlayPath
+	}
+
+	kustomizer := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resMap, err := kustomizer.Run(filesys.MakeFsOnDisk(), buildPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization: %w", err)
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, r := range resMap.Resources() {
+		obj, err := r.Map()
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert resource %s: %w", r.CurId(), err)
+		}
+		objs = append(objs, &unstructured.Unstructured{Object: obj})
+	}
+
+	return objs, nil
+}
+
+func (o *BuildOptions) isEmpty() bool {
+	return len(o.Images) == 0 &&
+		o.NamePrefix == "" &&
+		o.NameSuffix == "" &&
+		len(o.CommonLabels) == 0 &&
+		o.Namespace == ""
+}
+
+func writeOverlay(overlayPath string, basePath string, opts *BuildOptions) error {
+	kustomization := types.Kustomization{
+		TypeMeta: types.TypeMeta{
+			APIVersion: types.KustomizationVersion,
+			Kind:       types.KustomizationKind,
+		},
+		Resources:  []string{basePath},
+		NamePrefix: opts.NamePrefix,
+		NameSuffix: opts.NameSuffix,
+		Namespace:  opts.Namespace,
+	}
+	if len(opts.CommonLabels) > 0 {
+		kustomization.Labels = []types.Label{
+			{
+				Pairs:            opts.CommonLabels,
+				IncludeSelectors: true,
+			},
+		}
+	}
+	for _, image := range opts.Images {
+		kustomization.Images = append(kustomization.Images, parseImage(image))
+	}
+
+	content, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return fmt.Errorf("failed to marshal overlay: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(overlayPath, konfig.DefaultKustomizationFileName()), content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write overlay: %w", err)
+	}
+
+	return nil
+}
+
+// parseImage parses an image override, either `name=newName:newTag` or `name:newTag`
+func parseImage(image string) types.Image {
+	name, ref, found := strings.Cut(image, "=")
+	if !found {
+		ref = image
+	}
+
+	newName, tag, digest := util.SplitImageName(ref)
+	if !found {
+		name = newName
+	}
+
+	result := types.Image{
+		Name:   name,
+		NewTag: tag,
+		Digest: digest,
+	}
+	if newName != name {
+		result.NewName = newName
+	}
+
+	return result
+}
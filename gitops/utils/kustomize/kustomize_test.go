@@ -0,0 +1,112 @@
+package kustomize
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+func Test_IsKustomization(t *testing.T) {
+	assert.True(t, IsKustomization(filepath.Join(".", "testdata", "base")))
+	assert.False(t, IsKustomization(filepath.Join(".", "testdata", "plain")))
+	assert.False(t, IsKustomization("invalid-path"))
+}
+
+func Test_Build(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		testPath    string
+		opts        *BuildOptions
+		validate    func(t *testing.T, objs []*unstructured.Unstructured)
+		expectedErr string
+	}{
+		{
+			name:     "Should build the kustomization",
+			testPath: filepath.Join(".", "testdata", "base"),
+			validate: func(t *testing.T, objs []*unstructured.Unstructured) {
+				assert.Len(t, objs, 2)
+				assert.Equal(t, "Deployment", objs[0].GetKind())
+				assert.Equal(t, "nginx", objs[0].GetName())
+				assert.Equal(t, "Service", objs[1].GetKind())
+				assert.Equal(t, "nginx", objs[1].GetName())
+			},
+		},
+		{
+			name:     "Should apply the overrides on top of the kustomization",
+			testPath: filepath.Join(".", "testdata", "base"),
+			opts: &BuildOptions{
+				Images:       []string{"nginx=my-registry/nginx:1.27"},
+				NamePrefix:   "dev-",
+				NameSuffix:   "-v1",
+				CommonLabels: map[string]string{"team": "platform"},
+				Namespace:    "development",
+			},
+			validate: func(t *testing.T, objs []*unstructured.Unstructured) {
+				assert.Len(t, objs, 2)
+				for _, obj := range objs {
+					assert.Equal(t, "dev-nginx-v1", obj.GetName())
+					assert.Equal(t, "development", obj.GetNamespace())
+					assert.Equal(t, "platform", obj.GetLabels()["team"])
+				}
+
+				selector, _, _ := unstructured.NestedStringMap(objs[0].Object, "spec", "selector", "matchLabels")
+				assert.Equal(t, "platform", selector["team"])
+
+				containers, _, _ := unstructured.NestedSlice(objs[0].Object, "spec", "template", "spec", "containers")
+				assert.Equal(t, "my-registry/nginx:1.27", containers[0].(map[string]interface{})["image"])
+			},
+		},
+		{
+			name:        "Should return error when the path is not a kustomization",
+			testPath:    filepath.Join(".", "testdata", "plain"),
+			expectedErr: "failed to build kustomization",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKustomize()
+			objs, err := k.Build(tt.testPath, tt.opts)
+			if err != nil {
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			assert.Empty(t, tt.expectedErr)
+
+			tt.validate(t, objs)
+		})
+	}
+}
+
+func Test_ParseImage(t *testing.T) {
+	var testCases = []struct {
+		image    string
+		expected types.Image
+	}{
+		{
+			image:    "nginx:1.27",
+			expected: types.Image{Name: "nginx", NewTag: "1.27"},
+		},
+		{
+			image:    "nginx=my-registry/nginx:1.27",
+			expected: types.Image{Name: "nginx", NewName: "my-registry/nginx", NewTag: "1.27"},
+		},
+		{
+			image:    "nginx=my-registry/nginx",
+			expected: types.Image{Name: "nginx", NewName: "my-registry/nginx"},
+		},
+		{
+			image:    "nginx@sha256:24235f4cd6ccd69aa0bbe6e4f62fb7bd59bc0cb3cd1fbd0e0a2b5b8b8c33d1c4",
+			expected: types.Image{Name: "nginx", Digest: "sha256:24235f4cd6ccd69aa0bbe6e4f62fb7bd59bc0cb3cd1fbd0e0a2b5b8b8c33d1c4"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseImage(tt.image))
+		})
+	}
+}
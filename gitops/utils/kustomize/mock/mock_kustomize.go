@@ -0,0 +1,56 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize (interfaces: Kustomize)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock_kustomize.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize Kustomize
+//
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+
+	kustomize "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/kustomize"
+	gomock "go.uber.org/mock/gomock"
+	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MockKustomize is a mock of Kustomize interface.
+type MockKustomize struct {
+	ctrl     *gomock.Controller
+	recorder *MockKustomizeMockRecorder
+}
+
+// MockKustomizeMockRecorder is the mock recorder for MockKustomize.
+type MockKustomizeMockRecorder struct {
+	mock *MockKustomize
+}
+
+// NewMockKustomize creates a new mock instance.
+func NewMockKustomize(ctrl *gomock.Controller) *MockKustomize {
+	mock := &MockKustomize{ctrl: ctrl}
+	mock.recorder = &MockKustomizeMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKustomize) EXPECT() *MockKustomizeMockRecorder {
+	return m.recorder
+}
+
+// Build mocks base method.
+func (m *MockKustomize) Build(arg0 string, arg1 *kustomize.BuildOptions) ([]*unstructured.Unstructured, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Build", arg0, arg1)
+	ret0, _ := ret[0].([]*unstructured.Unstructured)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Build indicates an expected call of Build.
+func (mr *MockKustomizeMockRecorder) Build(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Build", reflect.TypeOf((*MockKustomize)(nil).Build), arg0, arg1)
+}
@@ -0,0 +1,68 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook (interfaces: Webhook)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock_webhook.go -package=mock github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook Webhook
+//
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	http "net/http"
+	reflect "reflect"
+
+	webhook "github.com/minhthong582000/k8s-controller-pattern/gitops/utils/webhook"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockWebhook is a mock of Webhook interface.
+type MockWebhook struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookMockRecorder
+}
+
+// MockWebhookMockRecorder is the mock recorder for MockWebhook.
+type MockWebhookMockRecorder struct {
+	mock *MockWebhook
+}
+
+// NewMockWebhook creates a new mock instance.
+func NewMockWebhook(ctrl *gomock.Controller) *MockWebhook {
+	mock := &MockWebhook{ctrl: ctrl}
+	mock.recorder = &MockWebhookMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhook) EXPECT() *MockWebhookMockRecorder {
+	return m.recorder
+}
+
+// Parse mocks base method.
+func (m *MockWebhook) Parse(arg0 *http.Request) (*webhook.PushEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Parse", arg0)
+	ret0, _ := ret[0].(*webhook.PushEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Parse indicates an expected call of Parse.
+func (mr *MockWebhookMockRecorder) Parse(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Parse", reflect.TypeOf((*MockWebhook)(nil).Parse), arg0)
+}
+
+// SetSecrets mocks base method.
+func (m *MockWebhook) SetSecrets(arg0 map[string][]byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetSecrets", arg0)
+}
+
+// SetSecrets indicates an expected call of SetSecrets.
+func (mr *MockWebhookMockRecorder) SetSecrets(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSecrets", reflect.TypeOf((*MockWebhook)(nil).SetSecrets), arg0)
+}
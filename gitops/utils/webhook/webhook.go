@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Keys of the data of the Secret holding the secrets of the webhooks, per provider
+const (
+	SecretKeyGitHub    = "github"
+	SecretKeyGitLab    = "gitlab"
+	SecretKeyGitea     = "gitea"
+	SecretKeyBitbucket = "bitbucket"
+)
+
+const (
+	branchPrefix = "refs/heads/"
+	tagPrefix    = "refs/tags/"
+
+	// maxPayloadSize is the largest payload GitHub sends
+	maxPayloadSize = 25 << 20
+)
+
+var (
+	// ErrIgnoredEvent is returned for the events that are not pushes, e.g. pings
+	ErrIgnoredEvent = errors.New("ignored event")
+	// ErrUnauthorized is returned if the signature or the token of the payload doesn't match
+	// the secret of its provider, or if the provider has no secret
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// PushEvent is a push of branches or tags to a repository
+type PushEvent struct {
+	// URLs are the URLs of the repository, e.g. over HTTPS and SSH
+	URLs []string
+	// Refs are the full names of the pushed references, e.g. refs/heads/main
+	Refs []string
+	// DefaultBranch is the branch of HEAD, empty if the provider doesn't send it
+	DefaultBranch string
+}
+
+type Webhook interface {
+	Parse(r *http.Request) (*PushEvent, error)
+	SetSecrets(secrets map[string][]byte)
+}
+
+type webhook struct {
+	mutex   sync.RWMutex
+	secrets map[string][]byte
+}
+
+func NewWebhook() Webhook {
+	return &webhook{}
+}
+
+// SetSecrets replaces the secrets of the providers, keyed by SecretKey*
+func (w *webhook) SetSecrets(secrets map[string][]byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.secrets = secrets
+}
+
+func (w *webhook) secret(provider string) []byte {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.secrets[provider]
+}
+
+// Parse checks the signature of a push notified by GitHub, GitLab, Gitea or Bitbucket
+// and parses its payload
+func (w *webhook) Parse(r *http.Request) (*PushEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+
+	// Gitea also sends the headers of GitHub
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		if r.Header.Get("X-Gitea-Event") != "push" {
+			return nil, ErrIgnoredEvent
+		}
+		if err := w.verifyHMAC(SecretKeyGitea, body, r.Header.Get("X-Gitea-Signature")); err != nil {
+			return nil, err
+		}
+		return parseGitHub(body)
+	case r.Header.Get("X-GitHub-Event") != "":
+		if r.Header.Get("X-GitHub-Event") != "push" {
+			return nil, ErrIgnoredEvent
+		}
+		signature, _ := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if err := w.verifyHMAC(SecretKeyGitHub, body, signature); err != nil {
+			return nil, err
+		}
+		return parseGitHub(body)
+	case r.Header.Get("X-Gitlab-Event") != "":
+		if event := r.Header.Get("X-Gitlab-Event"); event != "Push Hook" && event != "Tag Push Hook" {
+			return nil, ErrIgnoredEvent
+		}
+		if err := w.verifyToken(SecretKeyGitLab, r.Header.Get("X-Gitlab-Token")); err != nil {
+			return nil, err
+		}
+		return parseGitLab(body)
+	case r.Header.Get("X-Event-Key") != "":
+		event := r.Header.Get("X-Event-Key")
+		if event != "repo:push" && event != "repo:refs_changed" {
+			return nil, ErrIgnoredEvent
+		}
+		signature, _ := strings.CutPrefix(r.Header.Get("X-Hub-Signature"), "sha256=")
+		if err := w.verifyHMAC(SecretKeyBitbucket, body, signature); err != nil {
+			return nil, err
+		}
+		if event == "repo:refs_changed" {
+			return parseBitbucketServer(body)
+		}
+		return parseBitbucketCloud(body)
+	}
+
+	return nil, fmt.Errorf("unknown provider, the event header is missing")
+}
+
+// verifyHMAC checks the hex HMAC-SHA256 of the payload with the secret of the provider
+func (w *webhook) verifyHMAC(provider string, body []byte, signature string) error {
+	secret := w.secret(provider)
+	if len(secret) == 0 {
+		return fmt.Errorf("%w: no secret for %s", ErrUnauthorized, provider)
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil || signature == "" {
+		return fmt.Errorf("%w: invalid %s signature", ErrUnauthorized, provider)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("%w: invalid %s signature", ErrUnauthorized, provider)
+	}
+	return nil
+}
+
+// verifyToken checks the token sent as is with the secret of the provider
+func (w *webhook) verifyToken(provider string, token string) error {
+	secret := w.secret(provider)
+	if len(secret) == 0 {
+		return fmt.Errorf("%w: no secret for %s", ErrUnauthorized, provider)
+	}
+	if subtle.ConstantTimeCompare(secret, []byte(token)) != 1 {
+		return fmt.Errorf("%w: invalid %s token", ErrUnauthorized, provider)
+	}
+	return nil
+}
+
+// parseGitHub parses the push payloads of GitHub and Gitea
+func parseGitHub(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			HTMLURL       string `json:"html_url"`
+			CloneURL      string `json:"clone_url"`
+			SSHURL        string `json:"ssh_url"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	return &PushEvent{
+		URLs:          []string{payload.Repository.HTMLURL, payload.Repository.CloneURL, payload.Repository.SSHURL},
+		Refs:          []string{payload.Ref},
+		DefaultBranch: payload.Repository.DefaultBranch,
+	}, nil
+}
+
+func parseGitLab(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Ref     string `json:"ref"`
+		Project struct {
+			WebURL        string `json:"web_url"`
+			GitHTTPURL    string `json:"git_http_url"`
+			GitSSHURL     string `json:"git_ssh_url"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	return &PushEvent{
+		URLs:          []string{payload.Project.WebURL, payload.Project.GitHTTPURL, payload.Project.GitSSHURL},
+		Refs:          []string{payload.Ref},
+		DefaultBranch: payload.Project.DefaultBranch,
+	}, nil
+}
+
+func parseBitbucketCloud(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Push struct {
+			Changes []struct {
+				New *struct {
+					Type string `json:"type"`
+					Name string `json:"name"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+		Repository struct {
+			Links struct {
+				HTML struct {
+					Href string `json:"href"`
+				} `json:"html"`
+			} `json:"links"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	event := &PushEvent{URLs: []string{payload.Repository.Links.HTML.Href}}
+	for _, change := range payload.Push.Changes {
+		// The deleted references have no new target
+		if change.New == nil {
+			continue
+		}
+		switch change.New.Type {
+		case "branch":
+			event.Refs = append(event.Refs, branchPrefix+change.New.Name)
+		case "tag":
+			event.Refs = append(event.Refs, tagPrefix+change.New.Name)
+		}
+	}
+	return event, nil
+}
+
+func parseBitbucketServer(body []byte) (*PushEvent, error) {
+	var payload struct {
+		Changes []struct {
+			Ref struct {
+				ID string `json:"id"`
+			} `json:"ref"`
+		} `json:"changes"`
+		Repository struct {
+			Links struct {
+				Clone []struct {
+					Href string `json:"href"`
+				} `json:"clone"`
+			} `json:"links"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	event := &PushEvent{}
+	for _, link := range payload.Repository.Links.Clone {
+		event.URLs = append(event.URLs, link.Href)
+	}
+	for _, change := range payload.Changes {
+		event.Refs = append(event.Refs, change.Ref.ID)
+	}
+	return event, nil
+}
+
+// Affects returns whether the push may move the revision of the repository: the pushed
+// branch or tag, HEAD if the default branch is pushed, or a semver constraint if a tag is pushed
+func (e *PushEvent) Affects(repository, revision string) bool {
+	if !e.hasURL(repository) {
+		return false
+	}
+
+	for _, ref := range e.Refs {
+		branch, isBranch := strings.CutPrefix(ref, branchPrefix)
+		tag, isTag := strings.CutPrefix(ref, tagPrefix)
+		switch {
+		case revision == ref:
+			return true
+		case revision == "" || revision == "HEAD":
+			// The default branch is unknown without a provider sending it
+			if isBranch && (e.DefaultBranch == "" || branch == e.DefaultBranch) {
+				return true
+			}
+		case isBranch && revision == branch, isTag && revision == tag:
+			return true
+		case isTag:
+			if _, err := semver.NewConstraint(revision); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *PushEvent) hasURL(repository string) bool {
+	repository = normalizeURL(repository)
+	if repository == "" {
+		return false
+	}
+	for _, url := range e.URLs {
+		if normalizeURL(url) == repository {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURL returns the host and the path of the repository, the same over HTTPS and SSH,
+// e.g. github.com/org/repo for https://github.com/org/repo.git and git@github.com:org/repo.git
+func normalizeURL(url string) string {
+	if url == "" {
+		return ""
+	}
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return ""
+	}
+
+	path := strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git")
+	return strings.ToLower(endpoint.Host + "/" + path)
+}
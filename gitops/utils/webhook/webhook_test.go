@@ -0,0 +1,333 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newTestServer parses the requests with the webhook, it answers with the event
+func newTestServer(w Webhook) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		event, err := w.Parse(r)
+		switch {
+		case errors.Is(err, ErrIgnoredEvent):
+			rw.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, ErrUnauthorized):
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+		case err != nil:
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		default:
+			json.NewEncoder(rw).Encode(event)
+		}
+	}))
+}
+
+func TestWebhook_Parse(t *testing.T) {
+	w := NewWebhook()
+	w.SetSecrets(map[string][]byte{
+		SecretKeyGitHub:    []byte("github-secret"),
+		SecretKeyGitLab:    []byte("gitlab-token"),
+		SecretKeyGitea:     []byte("gitea-secret"),
+		SecretKeyBitbucket: []byte("bitbucket-secret"),
+	})
+	server := newTestServer(w)
+	defer server.Close()
+
+	testCases := []struct {
+		name           string
+		payload        string
+		headers        func(payload []byte) map[string]string
+		expectedStatus int
+		expectedEvent  *PushEvent
+	}{
+		{
+			name:    "Should parse a GitHub push",
+			payload: "github-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-GitHub-Event":      "push",
+					"X-Hub-Signature-256": "sha256=" + sign("github-secret", payload),
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedEvent: &PushEvent{
+				URLs:          []string{"https://github.com/org/repo", "https://github.com/org/repo.git", "git@github.com:org/repo.git"},
+				Refs:          []string{"refs/heads/main"},
+				DefaultBranch: "main",
+			},
+		},
+		{
+			name:    "Should reject a GitHub push signed with another secret",
+			payload: "github-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-GitHub-Event":      "push",
+					"X-Hub-Signature-256": "sha256=" + sign("other-secret", payload),
+				}
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "Should reject an unsigned GitHub push",
+			payload: "github-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{"X-GitHub-Event": "push"}
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "Should ignore a GitHub ping",
+			payload: "github-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{"X-GitHub-Event": "ping"}
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:    "Should parse a GitLab tag push",
+			payload: "gitlab-tag-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-Gitlab-Event": "Tag Push Hook",
+					"X-Gitlab-Token": "gitlab-token",
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedEvent: &PushEvent{
+				URLs:          []string{"https://gitlab.com/group/example", "https://gitlab.com/group/example.git", "git@gitlab.com:group/example.git"},
+				Refs:          []string{"refs/tags/v1.2.0"},
+				DefaultBranch: "master",
+			},
+		},
+		{
+			name:    "Should reject a GitLab push with another token",
+			payload: "gitlab-tag-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-Gitlab-Event": "Tag Push Hook",
+					"X-Gitlab-Token": "other-token",
+				}
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:    "Should parse a Gitea push sent with the headers of GitHub",
+			payload: "gitea-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-Gitea-Event":     "push",
+					"X-Gitea-Signature": sign("gitea-secret", payload),
+					"X-GitHub-Event":    "push",
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedEvent: &PushEvent{
+				URLs:          []string{"https://gitea.example.com/org/repo", "https://gitea.example.com/org/repo.git", "git@gitea.example.com:org/repo.git"},
+				Refs:          []string{"refs/heads/develop"},
+				DefaultBranch: "main",
+			},
+		},
+		{
+			name:    "Should parse a Bitbucket Cloud push without the deleted branches",
+			payload: "bitbucket-cloud-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-Event-Key":     "repo:push",
+					"X-Hub-Signature": "sha256=" + sign("bitbucket-secret", payload),
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedEvent: &PushEvent{
+				URLs: []string{"https://bitbucket.org/team/repo"},
+				Refs: []string{"refs/heads/main"},
+			},
+		},
+		{
+			name:    "Should parse a Bitbucket Server push",
+			payload: "bitbucket-server-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{
+					"X-Event-Key":     "repo:refs_changed",
+					"X-Hub-Signature": "sha256=" + sign("bitbucket-secret", payload),
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedEvent: &PushEvent{
+				URLs: []string{"ssh://git@bitbucket.example.com:7999/proj/repo.git", "https://bitbucket.example.com/scm/proj/repo.git"},
+				Refs: []string{"refs/tags/v2.0.0"},
+			},
+		},
+		{
+			name:    "Should reject a payload of an unknown provider",
+			payload: "github-push.json",
+			headers: func(payload []byte) map[string]string {
+				return map[string]string{}
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", tt.payload))
+			assert.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(payload))
+			assert.NoError(t, err)
+			for key, value := range tt.headers(payload) {
+				req.Header.Set(key, value)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			if tt.expectedEvent != nil {
+				var event PushEvent
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&event))
+				assert.Equal(t, tt.expectedEvent, &event)
+			}
+		})
+	}
+}
+
+func TestWebhook_Parse_NoSecret(t *testing.T) {
+	payload, err := os.ReadFile(filepath.Join("testdata", "gitlab-tag-push.json"))
+	assert.NoError(t, err)
+
+	// The payloads of the providers without a secret are rejected, even without token
+	w := NewWebhook()
+	w.SetSecrets(map[string][]byte{SecretKeyGitHub: []byte("github-secret")})
+	req := httptest.NewRequest(http.MethodPost, "/api/webhook", bytes.NewReader(payload))
+	req.Header.Set("X-Gitlab-Event", "Tag Push Hook")
+
+	_, err = w.Parse(req)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestPushEvent_Affects(t *testing.T) {
+	branchPush := &PushEvent{
+		URLs:          []string{"https://github.com/org/repo", "git@github.com:org/repo.git"},
+		Refs:          []string{"refs/heads/main"},
+		DefaultBranch: "main",
+	}
+	tagPush := &PushEvent{
+		URLs: []string{"ssh://git@bitbucket.example.com:7999/proj/repo.git"},
+		Refs: []string{"refs/tags/v2.0.0"},
+	}
+
+	testCases := []struct {
+		name       string
+		event      *PushEvent
+		repository string
+		revision   string
+		expected   bool
+	}{
+		{
+			name:       "Should affect the pushed branch",
+			event:      branchPush,
+			repository: "https://github.com/org/repo.git",
+			revision:   "main",
+			expected:   true,
+		},
+		{
+			name:       "Should affect the pushed branch over SSH",
+			event:      branchPush,
+			repository: "ssh://git@github.com/Org/Repo",
+			revision:   "main",
+			expected:   true,
+		},
+		{
+			name:       "Should affect the full name of the pushed branch",
+			event:      branchPush,
+			repository: "https://github.com/org/repo.git",
+			revision:   "refs/heads/main",
+			expected:   true,
+		},
+		{
+			name:       "Should affect HEAD if the default branch is pushed",
+			event:      branchPush,
+			repository: "https://github.com/org/repo.git",
+			expected:   true,
+		},
+		{
+			name:       "Should affect HEAD if the default branch is unknown",
+			event:      &PushEvent{URLs: branchPush.URLs, Refs: []string{"refs/heads/develop"}},
+			repository: "https://github.com/org/repo.git",
+			revision:   "HEAD",
+			expected:   true,
+		},
+		{
+			name:       "Should not affect HEAD if another branch is pushed",
+			event:      &PushEvent{URLs: branchPush.URLs, Refs: []string{"refs/heads/develop"}, DefaultBranch: "main"},
+			repository: "https://github.com/org/repo.git",
+			revision:   "HEAD",
+			expected:   false,
+		},
+		{
+			name:       "Should not affect another branch",
+			event:      branchPush,
+			repository: "https://github.com/org/repo.git",
+			revision:   "develop",
+			expected:   false,
+		},
+		{
+			name:       "Should not affect another repository",
+			event:      branchPush,
+			repository: "https://github.com/org/other.git",
+			revision:   "main",
+			expected:   false,
+		},
+		{
+			name:       "Should affect the pushed tag",
+			event:      tagPush,
+			repository: "ssh://git@bitbucket.example.com:7999/proj/repo.git",
+			revision:   "v2.0.0",
+			expected:   true,
+		},
+		{
+			name:       "Should affect a semver constraint if a tag is pushed",
+			event:      tagPush,
+			repository: "ssh://git@bitbucket.example.com:7999/proj/repo.git",
+			revision:   ">=1.0.0",
+			expected:   true,
+		},
+		{
+			name:       "Should not affect a commit SHA",
+			event:      tagPush,
+			repository: "ssh://git@bitbucket.example.com:7999/proj/repo.git",
+			revision:   "178864a7d521b6f5e720b386b2c2b0ef8563e0dc",
+			expected:   false,
+		},
+		{
+			name:       "Should not affect a semver constraint if a branch is pushed",
+			event:      branchPush,
+			repository: "https://github.com/org/repo.git",
+			revision:   "v1.*",
+			expected:   false,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.event.Affects(tt.repository, tt.revision))
+		})
+	}
+}